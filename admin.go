@@ -0,0 +1,167 @@
+package gorqlite
+
+// this file contains the cluster administration:
+//
+//   Connection.RemoveNode() for /remove
+//   Connection.NonVoters() and Connection.UnreachableNodes() to list nodes
+//   Connection.PruneUnreachable() to remove the nodes that stay unreachable
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoQuorum is returned by PruneUnreachable when the reachable voters are
+// not a majority of the cluster: removing nodes is then refused, since it
+// could make a minority partition elect its own leader.
+var ErrNoQuorum = errors.New("gorqlite: the reachable voters are not a quorum")
+
+// RemoveNode removes the node with the given ID from the cluster.
+func (conn *Connection) RemoveNode(ctx context.Context, nodeID string) error {
+	if conn.isClosed() {
+		return ErrClosed
+	}
+	if nodeID == "" {
+		return errors.New("no node ID to remove")
+	}
+	trace("%s: RemoveNode() for %s", conn.ID, nodeID)
+
+	body, err := json.Marshal(map[string]string{"id": nodeID})
+	if err != nil {
+		return err
+	}
+	_, err = conn.rqliteApiCall(ctx, api_REMOVE, "DELETE", "", body)
+	if err != nil {
+		trace("%s: rqliteApiCall() ERROR: %s", conn.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// NonVoters returns the read-only nodes of the cluster.
+func (conn *Connection) NonVoters(ctx context.Context) ([]Node, error) {
+	nodes, err := conn.Nodes(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	var ret []Node
+	for _, n := range nodes {
+		if !n.Voter {
+			ret = append(ret, n)
+		}
+	}
+	return ret, nil
+}
+
+// UnreachableNodes returns the nodes, read-only ones included, that the node
+// answering the call could not reach.
+func (conn *Connection) UnreachableNodes(ctx context.Context) ([]Node, error) {
+	nodes, err := conn.Nodes(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	var ret []Node
+	for _, n := range nodes {
+		if !n.Reachable {
+			ret = append(ret, n)
+		}
+	}
+	return ret, nil
+}
+
+// PruneUnreachable removes the nodes that have been unreachable for at least
+// olderThan and returns them.
+//
+// rqlite doesn't tell for how long a node has been unreachable, so the time
+// is measured from the first PruneUnreachable call of the connection (or any
+// of its views) that saw the node unreachable: call it periodically, e.g.
+// from a controller loop. A node that is reachable again starts over.
+//
+// Nothing is removed when the reachable voters are not a majority of the
+// voters, in which case ErrNoQuorum is returned, nor when the cluster has no
+// leader. The leader itself is never removed.
+func (conn *Connection) PruneUnreachable(ctx context.Context, olderThan time.Duration) ([]Node, error) {
+	if conn.isClosed() {
+		return nil, ErrClosed
+	}
+	nodes, err := conn.Nodes(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := conn.trackUnreachable(nodes, time.Now(), olderThan)
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	if err := checkQuorum(nodes); err != nil {
+		return nil, err
+	}
+
+	var removed []Node
+	for _, n := range candidates {
+		trace("%s: pruning node %s, unreachable: %s", conn.ID, n.ID, n.Error)
+		if err := conn.RemoveNode(ctx, n.ID); err != nil {
+			return removed, fmt.Errorf("could not remove node %s: %w", n.ID, err)
+		}
+		conn.mu.Lock()
+		delete(conn.unreachableSince, n.ID)
+		conn.mu.Unlock()
+		removed = append(removed, n)
+	}
+	return removed, nil
+}
+
+// trackUnreachable records when the nodes were first seen unreachable and
+// returns those unreachable for at least olderThan.
+func (conn *Connection) trackUnreachable(nodes []Node, now time.Time, olderThan time.Duration) []Node {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	seen := make(map[string]time.Time)
+	var candidates []Node
+	for _, n := range nodes {
+		if n.Reachable || n.Leader {
+			continue
+		}
+		since, ok := conn.unreachableSince[n.ID]
+		if !ok {
+			since = now
+		}
+		seen[n.ID] = since
+		if now.Sub(since) >= olderThan {
+			candidates = append(candidates, n)
+		}
+	}
+	// forget the nodes that are reachable again or already gone
+	conn.unreachableSince = seen
+	return candidates
+}
+
+// checkQuorum returns ErrNoQuorum unless the cluster has a leader and a
+// majority of reachable voters.
+func checkQuorum(nodes []Node) error {
+	voters, reachable := 0, 0
+	hasLeader := false
+	for _, n := range nodes {
+		if n.Leader {
+			hasLeader = true
+		}
+		if !n.Voter {
+			continue
+		}
+		voters++
+		if n.Reachable {
+			reachable++
+		}
+	}
+	if !hasLeader {
+		return fmt.Errorf("%w: the cluster has no leader", ErrNoQuorum)
+	}
+	if reachable < voters/2+1 {
+		return fmt.Errorf("%w: %d of %d voters reachable", ErrNoQuorum, reachable, voters)
+	}
+	return nil
+}
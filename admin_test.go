@@ -0,0 +1,74 @@
+package gorqlite
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckQuorum(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []Node
+		ok    bool
+	}{
+		{
+			name: "majority",
+			nodes: []Node{
+				{ID: "1", Voter: true, Reachable: true, Leader: true},
+				{ID: "2", Voter: true, Reachable: true},
+				{ID: "3", Voter: true},
+			},
+			ok: true,
+		},
+		{
+			name: "minority",
+			nodes: []Node{
+				{ID: "1", Voter: true, Reachable: true, Leader: true},
+				{ID: "2", Voter: true},
+				{ID: "3", Voter: true},
+				{ID: "4", Reachable: true},
+			},
+		},
+		{
+			name: "no leader",
+			nodes: []Node{
+				{ID: "1", Voter: true, Reachable: true},
+				{ID: "2", Voter: true, Reachable: true},
+				{ID: "3", Voter: true},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkQuorum(test.nodes)
+			requireBool(t, test.ok, err == nil)
+			if err != nil && !errors.Is(err, ErrNoQuorum) {
+				t.Errorf("expected ErrNoQuorum, got %v", err)
+			}
+		})
+	}
+}
+
+func TestTrackUnreachable(t *testing.T) {
+	conn := Connection{connShared: &connShared{}}
+	nodes := []Node{
+		{ID: "1", Voter: true, Reachable: true, Leader: true},
+		{ID: "2", Voter: true},
+		{ID: "3"},
+	}
+
+	// first seen now
+	requireInt(t, 0, len(conn.trackUnreachable(nodes, time.Now(), time.Minute)))
+	requireInt(t, 2, len(conn.trackUnreachable(nodes, time.Now(), 0)))
+
+	// a node that is reachable again starts over
+	conn.unreachableSince["2"] = time.Now().Add(-time.Hour)
+	conn.unreachableSince["3"] = time.Now().Add(-time.Hour)
+	nodes[2].Reachable = true
+	candidates := conn.trackUnreachable(nodes, time.Now(), time.Minute)
+	requireInt(t, 1, len(candidates))
+	requireString(t, "2", candidates[0].ID)
+	_, ok := conn.unreachableSince["3"]
+	requireBool(t, false, ok)
+}
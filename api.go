@@ -10,13 +10,19 @@ package gorqlite
 import (
 	"bytes"
 	"context"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type ParameterizedStatement = Statement
@@ -103,72 +109,162 @@ func (s *Statement) MarshalJSON() ([]byte, error) {
 	if s.Returning {
 		all = append(all, true)
 	}
-	all = append(append(all, s.Query), s.Arguments...)
+	args, err := argumentValues(s.Arguments)
+	if err != nil {
+		return nil, err
+	}
+	all = append(append(all, s.Query), args...)
 	return json.Marshal(all)
 }
 
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+// argumentValues returns the arguments with the driver.Valuer ones, e.g.
+// sql.NullString or uuids, replaced by their values.
+func argumentValues(args []interface{}) ([]interface{}, error) {
+	var values []interface{}
+	for i, arg := range args {
+		valuer, ok := arg.(driver.Valuer)
+		if !ok {
+			continue
+		}
+		if values == nil {
+			values = append(make([]interface{}, 0, len(args)), args...)
+		}
+		// like database/sql, a nil pointer to a type whose Value method has
+		// a value receiver stands for NULL
+		if rv := reflect.ValueOf(arg); rv.Kind() == reflect.Ptr && rv.IsNil() && rv.Type().Elem().Implements(valuerType) {
+			values[i] = nil
+			continue
+		}
+		v, err := valuer.Value()
+		if err != nil {
+			return nil, fmt.Errorf("argument #%d (%T): %w", i, arg, err)
+		}
+		values[i] = v
+	}
+	if values == nil {
+		return args, nil
+	}
+	return values, nil
+}
+
 // method: rqliteApiCall() - internally handles api calls,
 // not supposed to be used by other files
 //
 //   - handles retries
 //   - handles timeouts
-func (conn *Connection) rqliteApiCall(ctx context.Context, apiOp apiOperation, method string, requestBody []byte) ([]byte, error) {
-	// Verify that we have at least a single peer to which we can make the request
-	peers := conn.cluster.PeerList()
+//   - emits a span for the call and a child span per peer attempt
+func (conn *Connection) rqliteApiCall(ctx context.Context, apiOp apiOperation, method string, query string, requestBody []byte) (_ []byte, err error) {
+	ctx, span := conn.startSpan(ctx, "gorqlite."+apiOperationNames[apiOp])
+	span.SetAttribute("rqlite.operation", apiOperationNames[apiOp])
+	span.SetAttribute("http.method", method)
+	defer func() { span.End(err) }()
+
+	if timeout := conn.callTimeout(apiOp); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Verify that we have at least a single peer to which we can make the request.
+	// Reads with no consistency go to the read-only nodes first, anything
+	// else only ever goes to the voters.
+	rc := conn.clusterInfo()
+	peers := rc.PeerList()
+	pinned := 0
+	if rc.leader != "" {
+		pinned = 1
+	}
+	if apiOp == api_QUERY && conn.consistencyLevel == ConsistencyLevelNone {
+		peers = rc.readPeerList(atomic.AddUint64(&conn.nonVoterReads, 1))
+		pinned += len(rc.nonVoters)
+	}
+	// The leader (and the read-only nodes) keep their place, the other peers
+	// are tried by latency, and those known to be failing are skipped.
+	peers = conn.health.arrange(peers, pinned)
 	if len(peers) < 1 {
 		return nil, errors.New("don't have any cluster info")
 	}
 	trace("%s: I have a peer list %d peers long", conn.ID, len(peers))
+	span.SetAttribute("rqlite.peers", len(peers))
 
 	// Keep list of failed requests to each peer, return in case all peers fail to answer
 	var failureLog []string
 
-	for i, peer := range peers {
-		trace("%s: attempting to contact peer %d (%s)", conn.ID, i, peer)
-		surl := conn.assembleURL(apiOp, peer)
-
-		// Prepare request
-		req, err := http.NewRequestWithContext(ctx, method, surl, bytes.NewBuffer(requestBody))
-		if err != nil {
-			trace("%s: got error '%s' doing http.NewRequest", conn.ID, err.Error())
-			failureLog = append(failureLog, fmt.Sprintf("%s failed due to %s", redactURL(surl), err.Error()))
-			continue
-		}
-		trace("%s: http.NewRequest() OK", conn.ID)
-		req.Header.Set("Content-Type", "application/json")
-
-		// Execute request using shared client
-		// We will close the response body as soon as we can to allow
-		// the TCP connection to escape back into client's pool
-		c := conn.apiClient(method == "GET")
-		response, err := c.Do(req)
-		if err != nil {
-			trace("%s: got error '%s' doing client.Do", conn.ID, err.Error())
-			failureLog = append(failureLog, fmt.Sprintf("%s failed due to %s", redactURL(surl), err.Error()))
-			continue
+	attempt := 0
+passes:
+	for pass := 0; pass < conn.retry.Attempts; pass++ {
+		if backoff := conn.retry.backoff(pass); backoff > 0 {
+			trace("%s: all peers failed, waiting %v before pass %d", conn.ID, backoff, pass)
+			if err := sleepContext(ctx, backoff); err != nil {
+				failureLog = append(failureLog, err.Error())
+				break
+			}
 		}
 
-		// Read response body even if not a successful answer to return a descriptive error message
-		responseBody, err := io.ReadAll(response.Body)
-		if err != nil {
-			trace("%s: got error '%s' doing ioutil.ReadAll", conn.ID, err.Error())
-			failureLog = append(failureLog, fmt.Sprintf("%s failed due to %s", redactURL(surl), err.Error()))
-			_ = response.Body.Close()
+		// reads with no consistency may be hedged: the next peer is tried
+		// when the previous one is slow to answer
+		if delay := conn.hedgeDelay(apiOp); delay > 0 && len(peers) > 1 {
+			responseBody, ok, err := conn.rqliteApiCallHedged(ctx, apiOp, method, peers, query, requestBody, delay, &attempt, &failureLog)
+			if ok {
+				return responseBody, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if ctx.Err() != nil {
+				break passes
+			}
 			continue
 		}
-		trace("%s: ioutil.ReadAll() OK", conn.ID)
 
-		// Check that we've got a successful answer
-		if response.StatusCode != http.StatusOK {
-			trace("%s: got code %s", conn.ID, response.Status)
-			failureLog = append(failureLog, fmt.Sprintf("%s failed, got: %s, message: %s", redactURL(surl), response.Status, string(responseBody)))
-			_ = response.Body.Close()
-			continue
+		for i, peer := range peers {
+			for busy := 0; ; busy++ {
+				trace("%s: attempting to contact peer %d (%s)", conn.ID, i, peer)
+				conn.health.begin(peer)
+				start := time.Now()
+				responseBody, err := conn.rqliteApiCallPeer(ctx, attempt, apiOp, method, peer, query, requestBody)
+				attempt++
+				if err != nil {
+					failureLog = append(failureLog, err.Error())
+					if ambiguous := conn.ambiguousWrite(apiOp, err); ambiguous != nil {
+						// replaying the write on another peer could apply it twice
+						conn.recordAttempt(apiOp, peer, time.Since(start), err)
+						return nil, ambiguous
+					}
+					if ctx.Err() != nil {
+						// the call was canceled or its deadline exceeded
+						break passes
+					}
+				}
+				conn.recordAttempt(apiOp, peer, time.Since(start), err)
+				if err == nil {
+					return responseBody, nil
+				}
+				var pe *peerError
+				if !errors.As(err, &pe) {
+					break
+				}
+				if pe.unauthorized() {
+					// the credentials are the same for the whole cluster
+					return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+				}
+				if !pe.busy() || busy >= conn.retry.BusyRetries {
+					break
+				}
+				delay := conn.retry.busyDelay(pe.retryAfter)
+				if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+					trace("%s: peer %s is busy for longer than the call deadline", conn.ID, peer)
+					break
+				}
+				trace("%s: peer %s is busy, trying it again in %v", conn.ID, peer, delay)
+				if err := sleepContext(ctx, delay); err != nil {
+					failureLog = append(failureLog, err.Error())
+					break passes
+				}
+			}
 		}
-		_ = response.Body.Close()
-		trace("%s: client.Do() OK", conn.ID)
-
-		return responseBody, nil
 	}
 
 	// All peers have failed to answer us, build a verbose error message
@@ -180,6 +276,308 @@ func (conn *Connection) rqliteApiCall(ctx context.Context, apiOp apiOperation, m
 	return nil, errors.New(builder.String())
 }
 
+// rqliteApiCallHedged makes one pass over the peers for a hedged read: the
+// next peer is called as soon as a previous one fails, or when none answered
+// within the hedge delay. The first answer wins and the attempts still running
+// are canceled.
+//
+// The answers are classified as in rqliteApiCall: an unauthorized answer
+// fails the call at once, and a busy peer is tried again after its
+// Retry-After, up to RetryPolicy.BusyRetries times, while the hedge goes on.
+// It returns false if the pass failed, with an error if the call must fail
+// without another pass.
+func (conn *Connection) rqliteApiCallHedged(ctx context.Context, apiOp apiOperation, method string, peers []peer, query string, requestBody []byte, delay time.Duration, attempt *int, failureLog *[]string) ([]byte, bool, error) {
+	type result struct {
+		peer    peer
+		body    []byte
+		err     error
+		elapsed time.Duration
+	}
+
+	hedgeCtx, cancel := context.WithCancel(ctx)
+	defer cancel() // cancels the losers
+	results := make(chan result, len(peers)*(conn.retry.BusyRetries+1))
+
+	next, inflight := 0, 0
+	busy := make(map[peer]int)
+	launch := func(p peer, wait time.Duration) {
+		a := *attempt
+		inflight++
+		*attempt++
+		trace("%s: attempting to contact peer %s in %v, %d in flight", conn.ID, p, wait, inflight)
+		go func() {
+			if err := sleepContext(hedgeCtx, wait); err != nil {
+				results <- result{peer: p, err: err}
+				return
+			}
+			conn.health.begin(p)
+			start := time.Now()
+			body, err := conn.rqliteApiCallPeer(hedgeCtx, a, apiOp, method, p, query, requestBody)
+			results <- result{peer: p, body: body, err: err, elapsed: time.Since(start)}
+		}()
+	}
+	launchNext := func() {
+		next++
+		launch(peers[next-1], 0)
+	}
+
+	launchNext()
+	hedge := time.After(delay)
+	for inflight > 0 {
+		select {
+		case <-hedge:
+			if next < len(peers) {
+				trace("%s: no answer after %v, hedging", conn.ID, delay)
+				launchNext()
+				hedge = time.After(delay)
+			}
+		case r := <-results:
+			inflight--
+			if r.err == nil {
+				conn.recordAttempt(apiOp, r.peer, r.elapsed, nil)
+				return r.body, true, nil
+			}
+			*failureLog = append(*failureLog, r.err.Error())
+			if ctx.Err() != nil {
+				// the call was canceled or its deadline exceeded
+				return nil, false, nil
+			}
+			conn.recordAttempt(apiOp, r.peer, r.elapsed, r.err)
+
+			var pe *peerError
+			if errors.As(r.err, &pe) {
+				if pe.unauthorized() {
+					// the credentials are the same for the whole cluster
+					return nil, false, fmt.Errorf("%w: %v", ErrUnauthorized, r.err)
+				}
+				if pe.busy() && busy[r.peer] < conn.retry.BusyRetries {
+					wait := conn.retry.busyDelay(pe.retryAfter)
+					if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) >= wait {
+						busy[r.peer]++
+						trace("%s: peer %s is busy, trying it again in %v", conn.ID, r.peer, wait)
+						launch(r.peer, wait)
+						continue
+					}
+					trace("%s: peer %s is busy for longer than the call deadline", conn.ID, r.peer)
+				}
+			}
+			if next < len(peers) {
+				launchNext()
+				hedge = time.After(delay)
+			}
+		}
+	}
+	return nil, false, nil
+}
+
+// recordAttempt updates the health of the peer with the outcome of an
+// attempt, and the latency samples of the hedged reads.
+func (conn *Connection) recordAttempt(apiOp apiOperation, p peer, elapsed time.Duration, err error) {
+	if err == nil {
+		conn.health.success(p, elapsed)
+		if apiOp == api_QUERY && conn.consistencyLevel == ConsistencyLevelNone {
+			conn.hedge.observe(elapsed)
+		}
+		return
+	}
+	var pe *peerError
+	if errors.As(err, &pe) {
+		if pe.answered() {
+			conn.health.success(p, elapsed)
+		} else {
+			conn.health.failure(p, err)
+		}
+	}
+}
+
+// rqliteApiCallPeer makes a single attempt to call the api on the given peer.
+// The returned error describes the failure for the failure log of
+// rqliteApiCall.
+func (conn *Connection) rqliteApiCallPeer(ctx context.Context, attempt int, apiOp apiOperation, method string, p peer, query string, requestBody []byte) (_ []byte, err error) {
+	ctx, span := conn.startSpan(ctx, "gorqlite.attempt")
+	span.SetAttribute("rqlite.attempt", attempt)
+	span.SetAttribute("net.peer.name", string(p))
+	defer func() { span.End(err) }()
+
+	surl := conn.assembleURL(apiOp, p, query)
+
+	// GETs are limited by the connection timeout, unless the client was
+	// provided by the user
+	if method == "GET" && conn.ownsClient && conn.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conn.timeout)
+		defer cancel()
+	}
+	// every attempt is limited by the peer timeout, so that the next peer
+	// can be tried before the deadline of the call
+	if conn.peerTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conn.peerTimeout)
+		defer cancel()
+	}
+
+	// Prepare request
+	req, err := http.NewRequestWithContext(ctx, method, surl, bytes.NewBuffer(requestBody))
+	if err != nil {
+		trace("%s: got error '%s' doing http.NewRequest", conn.ID, err.Error())
+		return nil, fmt.Errorf("%s failed due to %s", redactURL(surl), err.Error())
+	}
+	trace("%s: http.NewRequest() OK", conn.ID)
+	for key, values := range conn.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	conn.injectTraceHeaders(ctx, req)
+	if conn.authProvider != nil {
+		if err = conn.authProvider.Authenticate(ctx, req); err != nil {
+			trace("%s: got error '%s' authenticating request", conn.ID, err.Error())
+			return nil, fmt.Errorf("%s failed due to %s", redactURL(surl), err.Error())
+		}
+	}
+
+	// Track whether the request was (even partly) written: if not, the peer
+	// can't have executed it
+	var sent int32
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		WroteHeaders: func() { atomic.StoreInt32(&sent, 1) },
+	}))
+
+	// Execute request using shared client
+	// We will close the response body as soon as we can to allow
+	// the TCP connection to escape back into client's pool
+	response, err := conn.client.Do(req)
+	if err != nil {
+		trace("%s: got error '%s' doing client.Do", conn.ID, err.Error())
+		return nil, &peerError{sent: atomic.LoadInt32(&sent) == 1, err: fmt.Errorf("%s failed due to %s", redactURL(surl), err.Error())}
+	}
+	defer func() { _ = response.Body.Close() }()
+	span.SetAttribute("http.status_code", response.StatusCode)
+
+	// Read response body even if not a successful answer to return a descriptive error message
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		trace("%s: got error '%s' doing ioutil.ReadAll", conn.ID, err.Error())
+		return nil, &peerError{sent: true, err: fmt.Errorf("%s failed due to %s", redactURL(surl), err.Error())}
+	}
+	trace("%s: ioutil.ReadAll() OK", conn.ID)
+
+	// Check that we've got a successful answer; /readyz describes why a
+	// node isn't ready in the body of a 503
+	if response.StatusCode != http.StatusOK && !(apiOp == api_READY && response.StatusCode == http.StatusServiceUnavailable) {
+		trace("%s: got code %s", conn.ID, response.Status)
+		return nil, &peerError{sent: true, status: response.StatusCode, retryAfter: parseRetryAfter(response.Header.Get("Retry-After"), time.Now()), err: fmt.Errorf("%s failed, got: %s, message: %s", redactURL(surl), response.Status, string(responseBody))}
+	}
+	trace("%s: client.Do() OK", conn.ID)
+
+	return responseBody, nil
+}
+
+// peerError is the error of an attempt that reached the network: the peer
+// either didn't answer (status 0) or answered with an unexpected status.
+type peerError struct {
+	sent       bool // false if the request was never written
+	status     int
+	retryAfter time.Duration // from the Retry-After header, 0 if none
+	err        error
+}
+
+func (pe *peerError) Error() string {
+	return pe.err.Error()
+}
+
+func (pe *peerError) Unwrap() error {
+	return pe.err
+}
+
+// answered tells whether the peer is alive, though it rejected the request:
+// a busy peer is alive too, it doesn't count against its breaker.
+func (pe *peerError) answered() bool {
+	return pe.status != 0 && (pe.status < http.StatusInternalServerError || pe.busy())
+}
+
+// busy tells whether the peer asked to be tried again later: the leader is
+// not known yet, or its queue is full.
+func (pe *peerError) busy() bool {
+	return pe.status == http.StatusServiceUnavailable || pe.status == http.StatusTooManyRequests
+}
+
+// unauthorized tells whether the peer rejected the credentials, which no
+// other peer would accept either.
+func (pe *peerError) unauthorized() bool {
+	return pe.status == http.StatusUnauthorized || pe.status == http.StatusForbidden
+}
+
+// parseRetryAfter parses a Retry-After header, either a number of seconds or
+// an HTTP date, returning 0 if it is missing or invalid.
+func parseRetryAfter(h string, now time.Time) time.Duration {
+	h = strings.TrimSpace(h)
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// maybeApplied tells whether the peer may have executed the request: it was
+// sent and either the response was lost, or the status doesn't tell (e.g. a
+// gateway timeout). 4xx, 429 and 503 answers are rejections.
+func (pe *peerError) maybeApplied() bool {
+	if !pe.sent {
+		return false
+	}
+	if pe.status == 0 {
+		return true
+	}
+	return pe.status >= http.StatusInternalServerError && pe.status != http.StatusServiceUnavailable
+}
+
+// ambiguousWrite returns ErrAmbiguousWrite wrapping err if the failed attempt
+// of a write may have been applied, and replaying it is not known to be safe.
+func (conn *Connection) ambiguousWrite(apiOp apiOperation, err error) error {
+	if (apiOp != api_WRITE && apiOp != api_REQUEST) || conn.idempotencyKey != "" {
+		return nil
+	}
+	var pe *peerError
+	if !errors.As(err, &pe) || !pe.maybeApplied() {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrAmbiguousWrite, err)
+}
+
+// callTimeout returns the timeout of a whole api call for the given
+// operation, 0 if unlimited.
+func (conn *Connection) callTimeout(apiOp apiOperation) time.Duration {
+	switch apiOp {
+	case api_QUERY:
+		return conn.queryTimeout
+	case api_WRITE, api_REQUEST, api_REMOVE:
+		return conn.writeTimeout
+	}
+	return 0
+}
+
+// sleepContext pauses for the given duration, or until the context is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // redactURL redacts URL from the given parameter to be
 // safely read by the client
 func redactURL(surl string) string {
@@ -190,20 +588,21 @@ func redactURL(surl string) string {
 	return u.Redacted()
 }
 
-//	   method: rqliteApiGet() - for api_STATUS and api_NODES
+//	   method: rqliteApiGet() - for api_STATUS, api_NODES and api_READY
 //
 //		- lowest level interface - does not do any JSON unmarshalling
 //		- handles retries
 //		- handles timeouts
-func (conn *Connection) rqliteApiGet(ctx context.Context, apiOp apiOperation) ([]byte, error) {
+//		- the optional query is appended to the URL as is
+func (conn *Connection) rqliteApiGet(ctx context.Context, apiOp apiOperation, query ...string) ([]byte, error) {
 	var responseBody []byte
 	trace("%s: rqliteApiGet() called", conn.ID)
 
-	// Allow only api_STATUS and api_NODES now - maybe someday BACKUP
-	if apiOp != api_STATUS && apiOp != api_NODES {
+	// Allow only api_STATUS, api_NODES and api_READY now - maybe someday BACKUP
+	if apiOp != api_STATUS && apiOp != api_NODES && apiOp != api_READY {
 		return responseBody, errors.New("rqliteApiGet() called for invalid api operation")
 	}
-	return conn.rqliteApiCall(ctx, apiOp, "GET", nil)
+	return conn.rqliteApiCall(ctx, apiOp, "GET", strings.Join(query, "&"), nil)
 }
 
 //	   method: rqliteApiPost() - for api_QUERY, api_WRITE & api_REQUEST
@@ -221,6 +620,12 @@ func (conn *Connection) rqliteApiPost(ctx context.Context, apiOp apiOperation, s
 
 	trace("%s: rqliteApiPost() called for a QUERY of %d statements", conn.ID, len(sqlStatements))
 
+	if apiOp == api_REQUEST {
+		if err := conn.checkFeature(ctx, featureRequest); err != nil {
+			return responseBody, err
+		}
+	}
+
 	formattedStatements := make([][]interface{}, 0, len(sqlStatements))
 
 	for _, statement := range sqlStatements {
@@ -229,10 +634,17 @@ func (conn *Connection) rqliteApiPost(ctx context.Context, apiOp apiOperation, s
 			if apiOp != api_REQUEST {
 				return responseBody, errors.New("returning clause only available on api REQUEST")
 			}
+			if err := conn.checkFeature(ctx, featureReturning); err != nil {
+				return responseBody, err
+			}
 			formattedStatement = append(formattedStatement, statement.Returning)
 		}
+		args, err := argumentValues(statement.Arguments)
+		if err != nil {
+			return responseBody, err
+		}
 		formattedStatement = append(formattedStatement, statement.Query)
-		formattedStatement = append(formattedStatement, statement.Arguments...)
+		formattedStatement = append(formattedStatement, args...)
 		formattedStatements = append(formattedStatements, formattedStatement)
 	}
 
@@ -241,5 +653,5 @@ func (conn *Connection) rqliteApiPost(ctx context.Context, apiOp apiOperation, s
 		return nil, err
 	}
 
-	return conn.rqliteApiCall(ctx, apiOp, "POST", body)
+	return conn.rqliteApiCall(ctx, apiOp, "POST", "", body)
 }
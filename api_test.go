@@ -2,8 +2,12 @@ package gorqlite
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"log"
 	"testing"
+	"time"
 )
 
 func TestOpen(t *testing.T) {
@@ -62,3 +66,34 @@ func TestRedactURL(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	requireDuration(t, 0, parseRetryAfter("", now))
+	requireDuration(t, 3*time.Second, parseRetryAfter("3", now))
+	requireDuration(t, 0, parseRetryAfter("-3", now))
+	requireDuration(t, 0, parseRetryAfter("soon", now))
+	requireDuration(t, 90*time.Second, parseRetryAfter("Mon, 01 Jan 2024 12:01:30 GMT", now))
+	requireDuration(t, 0, parseRetryAfter("Mon, 01 Jan 2024 11:00:00 GMT", now))
+}
+
+type failingValuer struct{}
+
+func (failingValuer) Value() (driver.Value, error) {
+	return nil, errors.New("no value")
+}
+
+func TestStatementValuerArguments(t *testing.T) {
+	var nilTime *sql.NullTime
+	stmt := NewStatement("INSERT INTO t VALUES (?, ?, ?, ?, ?)",
+		1, sql.NullString{String: "a", Valid: true}, sql.NullInt64{}, Null[int64]{V: 7, Valid: true}, nilTime)
+	b, err := stmt.MarshalJSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requireString(t, `["INSERT INTO t VALUES (?, ?, ?, ?, ?)",1,"a",null,7,null]`, string(b))
+
+	if _, err := NewStatement("SELECT ?", failingValuer{}).MarshalJSON(); err == nil {
+		t.Error("expected the error of the valuer")
+	}
+}
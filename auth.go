@@ -0,0 +1,140 @@
+package gorqlite
+
+// this file contains request authentication:
+//
+//   AuthProvider
+//   StaticAuthProvider, EnvAuthProvider and FileAuthProvider
+//   ErrUnauthorized
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrUnauthorized is returned, wrapped, when a peer rejects the credentials
+// with a 401 or 403. The other peers are not tried, since they share the
+// credentials.
+var ErrUnauthorized = errors.New("gorqlite: unauthorized")
+
+// AuthProvider authenticates requests sent to rqlite. Authenticate is called
+// for every request, right before it is sent, and may set basic auth or any
+// other header on the request.
+type AuthProvider interface {
+	Authenticate(ctx context.Context, req *http.Request) error
+}
+
+// AuthProviderFunc adapts an ordinary function to the AuthProvider interface.
+type AuthProviderFunc func(ctx context.Context, req *http.Request) error
+
+func (f AuthProviderFunc) Authenticate(ctx context.Context, req *http.Request) error {
+	return f(ctx, req)
+}
+
+// StaticAuthProvider authenticates requests with fixed basic auth credentials
+// and/or headers. It is used for the credentials given in the connection
+// string.
+type StaticAuthProvider struct {
+	Username string
+	Password string
+	Header   http.Header // e.g. an "Authorization: Bearer ..." header
+}
+
+func (p *StaticAuthProvider) Authenticate(_ context.Context, req *http.Request) error {
+	if p.Username != "" || p.Password != "" {
+		req.SetBasicAuth(p.Username, p.Password)
+	}
+	for key, values := range p.Header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	return nil
+}
+
+// EnvAuthProvider authenticates requests with basic auth credentials read
+// from environment variables on every request.
+type EnvAuthProvider struct {
+	UsernameVar string // name of the variable holding the username
+	PasswordVar string // name of the variable holding the password
+}
+
+func (p *EnvAuthProvider) Authenticate(_ context.Context, req *http.Request) error {
+	username := os.Getenv(p.UsernameVar)
+	password, ok := os.LookupEnv(p.PasswordVar)
+	if !ok {
+		return fmt.Errorf("auth: environment variable %s is not set", p.PasswordVar)
+	}
+	req.SetBasicAuth(username, password)
+	return nil
+}
+
+// FileAuthProvider authenticates requests with basic auth credentials read
+// from files, such as a mounted Kubernetes secret. The files are read again
+// whenever they change on disk, so rotated passwords take effect without
+// recreating the Connection. Leading and trailing white space is trimmed from
+// the file contents.
+type FileAuthProvider struct {
+	usernameFile string
+	passwordFile string
+
+	mu       sync.Mutex
+	stamps   []fileStamp
+	username string
+	password string
+}
+
+// NewFileAuthProvider returns a FileAuthProvider reading the username and
+// password from the given files. usernameFile may be empty if the password
+// file is the only credential.
+func NewFileAuthProvider(usernameFile, passwordFile string) *FileAuthProvider {
+	return &FileAuthProvider{
+		usernameFile: usernameFile,
+		passwordFile: passwordFile,
+	}
+}
+
+func (p *FileAuthProvider) Authenticate(_ context.Context, req *http.Request) error {
+	username, password, err := p.credentials()
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(username, password)
+	return nil
+}
+
+// credentials returns the current credentials, reading the files again if
+// they changed since the last call.
+func (p *FileAuthProvider) credentials() (string, string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stamps, err := statFiles(p.usernameFile, p.passwordFile)
+	if err != nil {
+		return "", "", fmt.Errorf("auth: %v", err)
+	}
+	if p.stamps != nil && equalStamps(stamps, p.stamps) {
+		return p.username, p.password, nil
+	}
+
+	var username string
+	if p.usernameFile != "" {
+		b, err := os.ReadFile(p.usernameFile)
+		if err != nil {
+			return "", "", fmt.Errorf("auth: %v", err)
+		}
+		username = strings.TrimSpace(string(b))
+	}
+	b, err := os.ReadFile(p.passwordFile)
+	if err != nil {
+		return "", "", fmt.Errorf("auth: %v", err)
+	}
+
+	trace("auth: credentials loaded from %s", p.passwordFile)
+	p.username = username
+	p.password = strings.TrimSpace(string(b))
+	p.stamps = stamps
+	return p.username, p.password, nil
+}
@@ -0,0 +1,91 @@
+package gorqlite
+
+// this file contains the per-call options:
+//
+//   CallOption and its constructors (CallConsistency, CallTransaction, ...)
+//   Connection.withCallOptions() returning the view a single call runs on
+
+import (
+	"fmt"
+)
+
+// callOptions holds the settings of a single api call. They default to the
+// settings of the connection (or view) the call is made on.
+type callOptions struct {
+	level          consistencyLevel
+	transaction    bool
+	queue          bool
+	idempotencyKey string
+}
+
+// CallOption overrides a setting of the connection for a single call, or for
+// all the calls of a view created with Connection.With(). The CallOptions are
+// named Call... to tell them from the Options of the connection:
+//
+//	rows, err := conn.QueryContext(ctx, stmts,
+//		gorqlite.CallConsistency(gorqlite.ConsistencyLevelStrong),
+//		gorqlite.CallTransaction(false))
+type CallOption func(*callOptions)
+
+// CallConsistency sets the read consistency level of the call.
+func CallConsistency(level consistencyLevel) CallOption {
+	return func(co *callOptions) {
+		co.level = level
+	}
+}
+
+// CallTransaction sets whether the statements of the call are executed in a
+// transaction.
+func CallTransaction(transaction bool) CallOption {
+	return func(co *callOptions) {
+		co.transaction = transaction
+	}
+}
+
+// CallQueueing makes the writes of the call queued writes, see
+// https://rqlite.io/docs/api/queued-writes/
+//
+// The Write functions then return as soon as the statements are queued: the
+// results carry the sequence number of the queue instead of the rows affected.
+// Queries ignore this option.
+func CallQueueing() CallOption {
+	return func(co *callOptions) {
+		co.queue = true
+	}
+}
+
+// CallIdempotencyKey records the key in the IdempotencyTable within the
+// transaction of the write, so that the write is applied at most once: a
+// write whose key is already recorded fails with ErrAlreadyApplied. The key
+// lets the write be replayed on another peer when its response was lost,
+// instead of failing with ErrAmbiguousWrite.
+//
+// The key identifies a single write: don't use it for a view shared by
+// several calls. Queries ignore this option, queued writes reject it.
+func CallIdempotencyKey(key string) CallOption {
+	return func(co *callOptions) {
+		co.idempotencyKey = key
+	}
+}
+
+// defaultCallOptions returns the call options of the connection settings.
+func (conn *Connection) defaultCallOptions() callOptions {
+	return callOptions{
+		level:          conn.consistencyLevel,
+		transaction:    conn.wantsTransactions,
+		queue:          conn.wantsQueueing,
+		idempotencyKey: conn.idempotencyKey,
+	}
+}
+
+// withCallOptions returns the connection the call runs on: the connection
+// itself if there are no options, otherwise a view with the options applied.
+func (conn *Connection) withCallOptions(opts []CallOption) (*Connection, error) {
+	if len(opts) > 0 {
+		conn = conn.With(opts...)
+	}
+	if _, ok := consistencyLevelNames[conn.consistencyLevel]; !ok {
+		return nil, fmt.Errorf("unknown consistency level: %d", conn.consistencyLevel)
+	}
+	return conn, nil
+}
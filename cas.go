@@ -0,0 +1,180 @@
+package gorqlite
+
+// this file contains the optimistic concurrency helpers:
+//
+//   Connection.CompareAndSwap() updating a row only in its expected state
+//   Connection.UpdateIfVersion() for the rows with a version column
+//   Connection.UpdateWithRetry() re-reading the row on conflicts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrConflict is returned, wrapped, when a compare-and-swap update
+	// affected no row: the row changed since it was read, or is gone.
+	ErrConflict = errors.New("gorqlite: conflict")
+
+	// ErrNoRow is returned, wrapped, when the row to read or update doesn't
+	// exist.
+	ErrNoRow = errors.New("gorqlite: no such row")
+)
+
+// VersionColumn is the version column of the rows updated by UpdateIfVersion
+// and UpdateWithRetry, incremented by each update.
+const VersionColumn = "version"
+
+// Values maps column names to values, e.g. the key of a row or the changes
+// to make to it.
+type Values map[string]interface{}
+
+// sortedColumns returns the columns of the values in a stable order, so
+// that the statements are the same for the same columns.
+func (v Values) sortedColumns() []string {
+	cols := make([]string, 0, len(v))
+	for c := range v {
+		cols = append(cols, c)
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+// where returns the conditions matching the values, NULL included, with
+// their arguments.
+func where(conds ...Values) (string, []interface{}) {
+	var parts []string
+	var args []interface{}
+	for _, v := range conds {
+		for _, c := range v.sortedColumns() {
+			parts = append(parts, quoteIdentifier(c)+" IS ?")
+			args = append(args, v[c])
+		}
+	}
+	return strings.Join(parts, " AND "), args
+}
+
+// compareAndSwapStatement returns the UPDATE of the row with the given key,
+// if its columns have the expected values.
+func compareAndSwapStatement(table string, key, expected, changes Values) (Statement, error) {
+	if len(key) == 0 {
+		return Statement{}, errors.New("compare-and-swap needs a key")
+	}
+	if len(changes) == 0 {
+		return Statement{}, errors.New("compare-and-swap needs changes")
+	}
+	var sets []string
+	var args []interface{}
+	for _, c := range changes.sortedColumns() {
+		sets = append(sets, quoteIdentifier(c)+" = ?")
+		args = append(args, changes[c])
+	}
+	cond, condArgs := where(key, expected)
+	return Statement{
+		Query:     "UPDATE " + quoteIdentifier(table) + " SET " + strings.Join(sets, ", ") + " WHERE " + cond,
+		Arguments: append(args, condArgs...),
+	}, nil
+}
+
+// CompareAndSwap sets the changes on the row with the given key, provided
+// its columns still have the expected values, in a single statement. It
+// fails with ErrConflict if no row was updated: the row changed, or doesn't
+// exist.
+//
+//	_, err := conn.CompareAndSwap(ctx, "jobs", gorqlite.Values{"id": 42},
+//		gorqlite.Values{"state": "pending"}, gorqlite.Values{"state": "running"})
+func (conn *Connection) CompareAndSwap(ctx context.Context, table string, key, expected, changes Values, opts ...CallOption) (WriteResult, error) {
+	stmt, err := compareAndSwapStatement(table, key, expected, changes)
+	if err != nil {
+		return WriteResult{Err: err}, err
+	}
+	wr, err := conn.WriteOneParameterizedContext(ctx, stmt, opts...)
+	if err != nil {
+		if wr.Err != nil {
+			return wr, wr.Err
+		}
+		return wr, err
+	}
+	if wr.RowsAffected == 0 {
+		trace("%s: compare-and-swap on %s affected no row", conn.ID, table)
+		return wr, fmt.Errorf("%w: %s row %v doesn't match %v", ErrConflict, table, map[string]interface{}(key), map[string]interface{}(expected))
+	}
+	return wr, nil
+}
+
+// UpdateIfVersion sets the changes on the row with the given key, provided
+// its VersionColumn is expectedVersion, and increments the version. It
+// returns the new version, or fails with ErrConflict if the row is at
+// another version, or doesn't exist.
+func (conn *Connection) UpdateIfVersion(ctx context.Context, table string, key Values, expectedVersion int64, changes Values, opts ...CallOption) (int64, error) {
+	if _, ok := changes[VersionColumn]; ok {
+		return 0, fmt.Errorf("the changes can't set the %s column", VersionColumn)
+	}
+	withVersion := make(Values, len(changes)+1)
+	for c, v := range changes {
+		withVersion[c] = v
+	}
+	withVersion[VersionColumn] = expectedVersion + 1
+
+	_, err := conn.CompareAndSwap(ctx, table, key, Values{VersionColumn: expectedVersion}, withVersion, opts...)
+	if err != nil {
+		return 0, err
+	}
+	return expectedVersion + 1, nil
+}
+
+// UpdateWithRetry reads the row with the given key, calls update with it to
+// get the changes, and applies them with UpdateIfVersion. On a conflict, the
+// row is read again and update called again, up to attempts times in all.
+// It returns the new version of the row, or fails with ErrNoRow if the row
+// doesn't exist, or ErrConflict if the last attempt conflicted.
+//
+// The row is read with the consistency level of the call: use
+// ConsistencyLevelStrong to avoid conflicts on stale reads.
+func (conn *Connection) UpdateWithRetry(ctx context.Context, table string, key Values, attempts int, update func(row map[string]interface{}) (Values, error), opts ...CallOption) (int64, error) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	cond, args := where(key)
+	read := Statement{
+		Query:     "SELECT * FROM " + quoteIdentifier(table) + " WHERE " + cond,
+		Arguments: args,
+	}
+
+	var conflict error
+	for attempt := 0; attempt < attempts; attempt++ {
+		qr, err := conn.queryOne(ctx, read, opts)
+		if err != nil {
+			return 0, err
+		}
+		if !qr.Next() {
+			return 0, fmt.Errorf("%w: %s row %v", ErrNoRow, table, map[string]interface{}(key))
+		}
+		row, err := qr.Map()
+		if err != nil {
+			return 0, err
+		}
+		version, ok := row[VersionColumn].(int64)
+		if !ok {
+			return 0, fmt.Errorf("%s row %v has no integer %s column", table, map[string]interface{}(key), VersionColumn)
+		}
+
+		changes, err := update(row)
+		if err != nil {
+			return 0, err
+		}
+		newVersion, err := conn.UpdateIfVersion(ctx, table, key, version, changes, opts...)
+		if err == nil {
+			return newVersion, nil
+		}
+		if !errors.Is(err, ErrConflict) {
+			return 0, err
+		}
+		conflict = err
+		trace("%s: conflict on attempt %d to update %s row %v", conn.ID, attempt, table, map[string]interface{}(key))
+	}
+	return 0, conflict
+}
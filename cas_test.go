@@ -0,0 +1,38 @@
+package gorqlite
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCompareAndSwapStatement(t *testing.T) {
+	stmt, err := compareAndSwapStatement("jobs", Values{"id": 42},
+		Values{"state": "pending", "owner": nil},
+		Values{"state": "running", "owner": "w1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requireString(t, `UPDATE "jobs" SET "owner" = ?, "state" = ? WHERE "id" IS ? AND "owner" IS ? AND "state" IS ?`, stmt.Query)
+	expected := []interface{}{"w1", "running", 42, nil, "pending"}
+	requireInt(t, len(expected), len(stmt.Arguments))
+	for i, arg := range expected {
+		if stmt.Arguments[i] != arg {
+			t.Errorf("argument %d: expected %v, got %v", i, arg, stmt.Arguments[i])
+		}
+	}
+
+	if _, err := compareAndSwapStatement("jobs", nil, nil, Values{"state": "running"}); err == nil {
+		t.Error("expected an error without a key")
+	}
+	if _, err := compareAndSwapStatement("jobs", Values{"id": 42}, nil, nil); err == nil {
+		t.Error("expected an error without changes")
+	}
+}
+
+func TestUpdateIfVersionRejectsVersionChanges(t *testing.T) {
+	conn := &Connection{connShared: &connShared{}}
+	_, err := conn.UpdateIfVersion(context.Background(), "jobs", Values{"id": 42}, 1, Values{VersionColumn: 5})
+	if err == nil {
+		t.Error("expected an error when changing the version column")
+	}
+}
@@ -2,6 +2,7 @@ package gorqlite
 
 import (
 	"context"
+	"fmt"
 	"testing"
 )
 
@@ -102,3 +103,35 @@ func TestPeers(t *testing.T) {
 		t.Errorf("expected peers to be at least 1, got %d", len(peers))
 	}
 }
+
+func TestProcessInfoResponseNonVoters(t *testing.T) {
+	testNodeInfoResponse := `{
+  "1": {"api_addr": "http://host1:4001", "addr": "host1:4002", "reachable": true, "leader": true, "voter": true},
+  "2": {"api_addr": "http://host2:4001", "addr": "host2:4002", "reachable": true, "leader": false, "voter": true},
+  "ro1": {"api_addr": "http://ro1:4001", "addr": "ro1:4002", "reachable": true, "leader": false, "voter": false},
+  "ro2": {"api_addr": "http://ro2:4001", "addr": "ro2:4002", "reachable": true, "leader": false, "voter": false},
+  "ro3": {"api_addr": "http://ro3:4001", "addr": "ro3:4002", "reachable": false, "leader": false, "voter": false}
+}`
+	testConn := Connection{}
+	var rc rqliteCluster
+	if err := testConn.processNodeInfoBody([]byte(testNodeInfoResponse), &rc); err != nil {
+		t.Fatal(err)
+	}
+	requireString(t, "host1:4001", string(rc.leader))
+	requireInt(t, 1, len(rc.otherPeers))
+	requireInt(t, 2, len(rc.nonVoters))
+
+	rc.peerList = append([]peer{rc.leader}, rc.otherPeers...)
+	if got := fmt.Sprint(rc.PeerList()); got != "[host1:4001 host2:4001]" {
+		t.Errorf("read-only nodes should not be in the peer list, got %s", got)
+	}
+	for offset, want := range []string{
+		"[ro1:4001 ro2:4001 host1:4001 host2:4001]",
+		"[ro2:4001 ro1:4001 host1:4001 host2:4001]",
+		"[ro1:4001 ro2:4001 host1:4001 host2:4001]",
+	} {
+		if got := fmt.Sprint(rc.readPeerList(uint64(offset))); got != want {
+			t.Errorf("offset %d: expected %s, got %s", offset, want, got)
+		}
+	}
+}
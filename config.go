@@ -0,0 +1,620 @@
+package gorqlite
+
+// this file contains the connection configuration:
+//
+//   Config, ParseDSN() and FormatDSN()
+//   Option and the With...() functional options
+//   RetryPolicy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds all the settings of a Connection. Use NewConfig() to get a
+// Config initialized with the defaults, then either set its fields directly,
+// parse a connection string with ParseDSN() or use Option functions with
+// NewConnection().
+type Config struct {
+	// Peers are the host:port of the "seed" nodes of the cluster. The first
+	// one is assumed to be the leader until cluster discovery tells otherwise.
+	Peers []string
+	// HTTPS is true to talk to rqlite with https
+	HTTPS bool
+	// Username and Password are used for basic auth if both are set and no
+	// AuthProvider is given
+	Username string
+	Password string
+	// Consistency is the default consistency level of reads
+	Consistency consistencyLevel
+	// Timeout is the timeout of each GET request (status, nodes)
+	Timeout time.Duration
+	// QueryTimeout limits a whole query call, over all peers and retries.
+	// 0 means no limit other than the context deadline.
+	QueryTimeout time.Duration
+	// WriteTimeout limits a whole write, queue or request call, over all
+	// peers and retries. 0 means no limit other than the context deadline.
+	WriteTimeout time.Duration
+	// PeerTimeout limits each attempt to contact a peer, so that a hung node
+	// does not consume the whole budget of a call before failover. 0 means
+	// no limit.
+	PeerTimeout time.Duration
+	// DisableClusterDiscovery restricts all requests to the given peers
+	DisableClusterDiscovery bool
+	// Transactions is true to execute multi-statement calls in a transaction
+	Transactions bool
+	// Retry is the retry policy applied when no peer could answer a request
+	Retry RetryPolicy
+	// Breaker configures the circuit breakers skipping the failing peers
+	Breaker BreakerPolicy
+	// Hedge enables hedged reads for queries with no consistency
+	Hedge HedgePolicy
+	// TLSConfig is the TLS configuration used with https, nil for defaults
+	TLSConfig *tls.Config
+	// TLSCAFile is a PEM bundle of the CAs used to verify server certificates
+	TLSCAFile string
+	// TLSCertFile and TLSKeyFile are the PEM client certificate and key used
+	// for mutual TLS
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSServerName overrides the name used to verify server certificates
+	TLSServerName string
+	// TLSInsecureSkipVerify disables the verification of server certificates.
+	// Only use it for development.
+	TLSInsecureSkipVerify bool
+	// Transport tunes the HTTP transport owned by the connection
+	Transport TransportConfig
+	// Headers are added to every request sent to rqlite
+	Headers http.Header
+	// AuthProvider, if set, is called to authenticate every request. It takes
+	// precedence over Username and Password.
+	AuthProvider AuthProvider
+	// Propagator, if set, injects trace headers into every request
+	Propagator Propagator
+	// SpanTracer, if set, receives a span for every API call
+	SpanTracer SpanTracer
+	// HTTPClient, if set, is used for all requests. The TLS settings,
+	// Transport and Timeout are then ignored: configure them on the client
+	// instead.
+	HTTPClient *http.Client
+}
+
+// RetryPolicy tells how often the whole list of peers is tried before an API
+// call fails.
+type RetryPolicy struct {
+	// Attempts is the number of passes over the peer list, at least 1
+	Attempts int
+	// Backoff is the pause before the second pass, doubled for each
+	// subsequent pass
+	Backoff time.Duration
+	// MaxBackoff caps the pause between passes, 0 for no cap
+	MaxBackoff time.Duration
+	// BusyRetries is the number of times a busy peer, answering 503 or 429,
+	// is tried again before moving to the next peer. The peer is tried again
+	// after the delay of its Retry-After header, or 100ms if it has none.
+	BusyRetries int
+	// MaxRetryAfter caps the delay asked by a busy peer, 0 for no cap: the
+	// peer isn't tried again if the delay exceeds the deadline of the call
+	MaxRetryAfter time.Duration
+}
+
+const defaultBusyDelay = 100 * time.Millisecond
+
+// busyDelay returns the pause before trying a busy peer again, given the
+// delay it asked for.
+func (rp RetryPolicy) busyDelay(retryAfter time.Duration) time.Duration {
+	d := retryAfter
+	if d <= 0 {
+		d = defaultBusyDelay
+	}
+	if rp.MaxRetryAfter > 0 && d > rp.MaxRetryAfter {
+		d = rp.MaxRetryAfter
+	}
+	return d
+}
+
+// backoff returns the pause before the given pass (starting at 0).
+func (rp RetryPolicy) backoff(pass int) time.Duration {
+	if pass <= 0 || rp.Backoff <= 0 {
+		return 0
+	}
+	d := rp.Backoff
+	for i := 1; i < pass; i++ {
+		d *= 2
+		if rp.MaxBackoff > 0 && d >= rp.MaxBackoff {
+			break
+		}
+	}
+	if rp.MaxBackoff > 0 && d > rp.MaxBackoff {
+		d = rp.MaxBackoff
+	}
+	return d
+}
+
+// NewConfig returns a Config initialized with the defaults:
+//
+//	peers:        localhost:4001
+//	level:        weak
+//	timeout:      2 seconds
+//	transactions: true
+//	retry:        every peer is tried once, busy peers 3 more times
+//	breaker:      opens after 3 consecutive failures, probes after 5 seconds
+func NewConfig() *Config {
+	return &Config{
+		Peers:        []string{"localhost:4001"},
+		Consistency:  ConsistencyLevelWeak,
+		Timeout:      defaultTimeout,
+		Transactions: true,
+		Retry:        RetryPolicy{Attempts: 1, BusyRetries: 3},
+		Breaker:      defaultBreakerPolicy,
+	}
+}
+
+// ParseDSN parses a connection string as accepted by Open() into the config.
+// The settings a connection string may hold are replaced by what it says,
+// those it leaves out being reset to the defaults of NewConfig(). The other
+// settings, e.g. the retry policy, are left untouched.
+//
+// The connection string is a comma-separated list of URLs to individual
+// "seed" nodes of the cluster. The first URL may have additional settings:
+//
+//	http[s]://[username:password@]host[:port][?level=none|weak|strong&timeout=1&disableClusterDiscovery=true]
+//
+// Timeouts are given either as a number of seconds or as a Go duration
+// string (e.g. timeout=500ms):
+//
+//	timeout         timeout of each status/nodes request
+//	connectTimeout  timeout to establish a TCP connection
+//	queryTimeout    timeout of a whole query call
+//	writeTimeout    timeout of a whole write/queue/request call
+//	peerTimeout     timeout of each attempt to contact a peer
+//
+// as well as TLS settings for https:
+//
+//	tlsCA                  path of a PEM bundle of the CAs that verify the server
+//	tlsCert, tlsKey        paths of the PEM client certificate and key for mTLS
+//	tlsServerName          name used to verify the server certificates
+//	tlsInsecureSkipVerify  true to skip verifying the server (development only)
+//
+// The CA bundle and client certificate files are reloaded when they change on
+// disk.
+func (c *Config) ParseDSN(dsn string) error {
+	urls := strings.Split(dsn, ",")
+
+	// parse the first URL for full settings (including options)
+	if err := c.parseURL(urls[0]); err != nil {
+		return err
+	}
+
+	// any subsequent URLs are used as additional peers
+	for _, peerUrl := range urls[1:] {
+		pu, err := url.Parse(peerUrl)
+		if err != nil {
+			return err
+		}
+		if _, _, err = net.SplitHostPort(pu.Host); err != nil {
+			c.Peers = append(c.Peers, fmt.Sprintf("%s:%d", pu.Host, 4001))
+		} else {
+			c.Peers = append(c.Peers, pu.Host)
+		}
+	}
+	return nil
+}
+
+// resetDSN resets the settings a connection string may hold to their
+// defaults.
+func (c *Config) resetDSN() {
+	d := NewConfig()
+	c.Peers = d.Peers
+	c.HTTPS = false
+	c.Username, c.Password = "", ""
+	c.Consistency = d.Consistency
+	c.Timeout = d.Timeout
+	c.Transport.DialTimeout = 0
+	c.QueryTimeout, c.WriteTimeout, c.PeerTimeout = 0, 0, 0
+	c.DisableClusterDiscovery = false
+	c.TLSCAFile, c.TLSCertFile, c.TLSKeyFile, c.TLSServerName = "", "", "", ""
+	c.TLSInsecureSkipVerify = false
+}
+
+// parseURL parses the first URL of a connection string.
+func (c *Config) parseURL(connURL string) error {
+	// do some sanity checks.  You know users.
+
+	if len(connURL) < 7 {
+		return errors.New("url specified is impossibly short")
+	}
+
+	if !strings.HasPrefix(connURL, "http") {
+		return errors.New("url does not start with 'http'")
+	}
+
+	u, err := url.Parse(connURL)
+	if err != nil {
+		return err
+	}
+	c.resetDSN()
+
+	c.HTTPS = u.Scheme == "https"
+
+	// specs say Username() is always populated even if empty
+	if u.User != nil {
+		// guaranteed, but could be empty which is ok
+		c.Username = u.User.Username()
+		// not guaranteed, so test if set
+		if pass, isset := u.User.Password(); isset {
+			c.Password = pass
+		}
+	}
+
+	if u.Host != "" {
+		c.Peers = []string{u.Host}
+	}
+
+	if u.RawQuery == "" {
+		return nil
+	}
+	q := u.Query()
+
+	level := q.Get("level")
+	if level != "" {
+		cl, ok := consistencyLevels[level]
+		if !ok {
+			return errors.New("invalid consistency level: " + level)
+		}
+		c.Consistency = cl
+	}
+
+	for name, timeout := range map[string]*time.Duration{
+		"timeout":        &c.Timeout,
+		"connectTimeout": &c.Transport.DialTimeout,
+		"queryTimeout":   &c.QueryTimeout,
+		"writeTimeout":   &c.WriteTimeout,
+		"peerTimeout":    &c.PeerTimeout,
+	} {
+		ts := q.Get(name)
+		if len(ts) > 0 {
+			d, err := parseTimeout(ts)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %s", name, ts)
+			}
+			*timeout = d
+		}
+	}
+
+	dcd := q.Get("disableClusterDiscovery")
+	if dcd != "" {
+		dpd, err := strconv.ParseBool(dcd)
+		if err != nil {
+			return errors.New("invalid disableClusterDiscovery value: " + err.Error())
+		}
+		c.DisableClusterDiscovery = dpd
+	}
+
+	c.TLSCAFile = q.Get("tlsCA")
+	c.TLSCertFile = q.Get("tlsCert")
+	c.TLSKeyFile = q.Get("tlsKey")
+	c.TLSServerName = q.Get("tlsServerName")
+	tsv := q.Get("tlsInsecureSkipVerify")
+	if tsv != "" {
+		skip, err := strconv.ParseBool(tsv)
+		if err != nil {
+			return errors.New("invalid tlsInsecureSkipVerify value: " + err.Error())
+		}
+		c.TLSInsecureSkipVerify = skip
+	}
+
+	return nil
+}
+
+// FormatDSN formats the config into a connection string accepted by Open()
+// and ParseDSN(). Only settings that can be expressed in a connection string
+// are formatted, and only if they differ from the defaults.
+func (c *Config) FormatDSN() string {
+	scheme := "http"
+	if c.HTTPS {
+		scheme = "https"
+	}
+
+	peers := c.Peers
+	if len(peers) == 0 {
+		peers = []string{"localhost:4001"}
+	}
+
+	urls := make([]string, 0, len(peers))
+	for i, p := range peers {
+		u := url.URL{Scheme: scheme, Host: p}
+		if i == 0 {
+			if c.Password != "" {
+				u.User = url.UserPassword(c.Username, c.Password)
+			} else if c.Username != "" {
+				u.User = url.User(c.Username)
+			}
+			u.RawQuery = c.dsnParams().Encode()
+		}
+		urls = append(urls, u.String())
+	}
+	return strings.Join(urls, ",")
+}
+
+func (c *Config) dsnParams() url.Values {
+	params := url.Values{}
+	if c.Consistency != ConsistencyLevelWeak {
+		params.Set("level", consistencyLevelNames[c.Consistency])
+	}
+	if c.Timeout != defaultTimeout {
+		params.Set("timeout", formatTimeout(c.Timeout))
+	}
+	if c.Transport.DialTimeout != 0 {
+		params.Set("connectTimeout", formatTimeout(c.Transport.DialTimeout))
+	}
+	if c.QueryTimeout != 0 {
+		params.Set("queryTimeout", formatTimeout(c.QueryTimeout))
+	}
+	if c.WriteTimeout != 0 {
+		params.Set("writeTimeout", formatTimeout(c.WriteTimeout))
+	}
+	if c.PeerTimeout != 0 {
+		params.Set("peerTimeout", formatTimeout(c.PeerTimeout))
+	}
+	if c.DisableClusterDiscovery {
+		params.Set("disableClusterDiscovery", "true")
+	}
+	if c.TLSCAFile != "" {
+		params.Set("tlsCA", c.TLSCAFile)
+	}
+	if c.TLSCertFile != "" {
+		params.Set("tlsCert", c.TLSCertFile)
+	}
+	if c.TLSKeyFile != "" {
+		params.Set("tlsKey", c.TLSKeyFile)
+	}
+	if c.TLSServerName != "" {
+		params.Set("tlsServerName", c.TLSServerName)
+	}
+	if c.TLSInsecureSkipVerify {
+		params.Set("tlsInsecureSkipVerify", "true")
+	}
+	return params
+}
+
+// parseTimeout parses a timeout given either as a number of seconds or as a
+// Go duration string.
+func parseTimeout(s string) (time.Duration, error) {
+	var d time.Duration
+	if secs, err := strconv.Atoi(s); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if d, err = time.ParseDuration(s); err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative timeout: %s", s)
+	}
+	return d, nil
+}
+
+// formatTimeout formats a timeout as a number of seconds if it is a whole
+// number of seconds, as a Go duration string otherwise.
+func formatTimeout(d time.Duration) string {
+	if d%time.Second == 0 {
+		return strconv.Itoa(int(d / time.Second))
+	}
+	return d.String()
+}
+
+// validate checks the config for settings that would make a bad Connection.
+func (c *Config) validate() error {
+	if c.Consistency < ConsistencyLevelNone || c.Consistency > ConsistencyLevelStrong {
+		return fmt.Errorf("unknown consistency level: %d", c.Consistency)
+	}
+	if c.Timeout < 0 || c.QueryTimeout < 0 || c.WriteTimeout < 0 || c.PeerTimeout < 0 {
+		return errors.New("invalid timeout: negative value")
+	}
+	if c.Retry.Attempts < 0 || c.Retry.Backoff < 0 || c.Retry.MaxBackoff < 0 || c.Retry.BusyRetries < 0 || c.Retry.MaxRetryAfter < 0 {
+		return fmt.Errorf("invalid retry policy: %+v", c.Retry)
+	}
+	if err := c.Breaker.validate(); err != nil {
+		return err
+	}
+	if err := c.Hedge.validate(); err != nil {
+		return err
+	}
+	if !c.HTTPS && (c.hasTLSFiles() || c.TLSServerName != "" || c.TLSInsecureSkipVerify) {
+		return errors.New("tls settings require an https url")
+	}
+	return nil
+}
+
+// Option modifies a Config. Options are passed to NewConnection().
+type Option func(*Config)
+
+// WithPeers sets the host:port of the seed nodes of the cluster.
+func WithPeers(peers ...string) Option {
+	return func(c *Config) {
+		c.Peers = peers
+	}
+}
+
+// WithHTTPS sets whether rqlite is reached with https.
+func WithHTTPS(https bool) Option {
+	return func(c *Config) {
+		c.HTTPS = https
+	}
+}
+
+// WithConsistency sets the default consistency level.
+func WithConsistency(level consistencyLevel) Option {
+	return func(c *Config) {
+		c.Consistency = level
+	}
+}
+
+// WithTimeout sets the timeout of each GET request (status, nodes).
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.Timeout = timeout
+	}
+}
+
+// WithConnectTimeout sets the timeout to establish a TCP connection.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.Transport.DialTimeout = timeout
+	}
+}
+
+// WithQueryTimeout sets the timeout of a whole query call.
+func WithQueryTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.QueryTimeout = timeout
+	}
+}
+
+// WithWriteTimeout sets the timeout of a whole write, queue or request call.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.WriteTimeout = timeout
+	}
+}
+
+// WithPeerTimeout sets the timeout of each attempt to contact a peer.
+func WithPeerTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.PeerTimeout = timeout
+	}
+}
+
+// WithRetryPolicy sets the retry policy.
+func WithRetryPolicy(rp RetryPolicy) Option {
+	return func(c *Config) {
+		c.Retry = rp
+	}
+}
+
+// WithBreakerPolicy sets the policy of the circuit breakers of the peers.
+func WithBreakerPolicy(bp BreakerPolicy) Option {
+	return func(c *Config) {
+		c.Breaker = bp
+	}
+}
+
+// WithHedgePolicy enables hedged reads for queries with no consistency.
+func WithHedgePolicy(hp HedgePolicy) Option {
+	return func(c *Config) {
+		c.Hedge = hp
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used with https.
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(c *Config) {
+		c.TLSConfig = tlsConfig
+	}
+}
+
+// WithTLSCAFile sets the path of the PEM bundle of the CAs that verify the
+// server certificates. The file is reloaded when it changes on disk.
+func WithTLSCAFile(path string) Option {
+	return func(c *Config) {
+		c.TLSCAFile = path
+	}
+}
+
+// WithTLSClientCert sets the paths of the PEM client certificate and key used
+// for mutual TLS. The files are reloaded when they change on disk.
+func WithTLSClientCert(certFile, keyFile string) Option {
+	return func(c *Config) {
+		c.TLSCertFile = certFile
+		c.TLSKeyFile = keyFile
+	}
+}
+
+// WithTLSServerName overrides the name used to verify server certificates.
+func WithTLSServerName(name string) Option {
+	return func(c *Config) {
+		c.TLSServerName = name
+	}
+}
+
+// WithTLSInsecureSkipVerify disables the verification of server
+// certificates. Only use it for development.
+func WithTLSInsecureSkipVerify(skip bool) Option {
+	return func(c *Config) {
+		c.TLSInsecureSkipVerify = skip
+	}
+}
+
+// WithTransportConfig tunes the HTTP transport owned by the connection.
+func WithTransportConfig(tc TransportConfig) Option {
+	return func(c *Config) {
+		c.Transport = tc
+	}
+}
+
+// WithHeader adds a header sent with every request.
+func WithHeader(key, value string) Option {
+	return func(c *Config) {
+		if c.Headers == nil {
+			c.Headers = http.Header{}
+		}
+		c.Headers.Add(key, value)
+	}
+}
+
+// WithBasicAuth sets the username and password used for basic auth.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Config) {
+		c.Username = username
+		c.Password = password
+	}
+}
+
+// WithAuthProvider sets the AuthProvider called to authenticate every request.
+func WithAuthProvider(p AuthProvider) Option {
+	return func(c *Config) {
+		c.AuthProvider = p
+	}
+}
+
+// WithClusterDiscovery enables or disables cluster discovery.
+func WithClusterDiscovery(enabled bool) Option {
+	return func(c *Config) {
+		c.DisableClusterDiscovery = !enabled
+	}
+}
+
+// WithTransactions sets whether multi-statement calls are executed in a
+// transaction.
+func WithTransactions(enabled bool) Option {
+	return func(c *Config) {
+		c.Transactions = enabled
+	}
+}
+
+// WithPropagator sets the Propagator injecting trace headers.
+func WithPropagator(p Propagator) Option {
+	return func(c *Config) {
+		c.Propagator = p
+	}
+}
+
+// WithSpanTracer sets the SpanTracer receiving the client spans.
+func WithSpanTracer(t SpanTracer) Option {
+	return func(c *Config) {
+		c.SpanTracer = t
+	}
+}
+
+// WithHTTPClient sets the http client used for all requests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Config) {
+		c.HTTPClient = client
+	}
+}
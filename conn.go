@@ -15,14 +15,12 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	nurl "net/url"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	defaultTimeout                 = 2
+	defaultTimeout                 = 2 * time.Second
 	defaultDisableClusterDiscovery = false
 )
 
@@ -52,8 +50,7 @@ var (
 // Note that the Connection objection holds info on all peers, gathered
 // at time of Open() from the node specified.
 type Connection struct {
-	cluster      rqliteCluster
-	useStatusApi bool
+	*connShared // shared with the views created by With()
 
 	// name           type                default
 
@@ -63,36 +60,100 @@ type Connection struct {
 	disableClusterDiscovery bool             //   false unless user states otherwise
 	wantsHTTPS              bool             //   false unless connection URL is https
 	wantsTransactions       bool             //   true unless user states otherwise
-	wantsQueueing           bool             //   perform queued writes
+	wantsQueueing           bool             //   false unless the view queues writes
+	idempotencyKey          string           //   "" unless the view records its writes
 
 	// variables below this line need to be initialized in Open()
-	timeout       int          //   2
-	hasBeenClosed bool         //   false
-	ID            string       //   generated in init()
-	client        *http.Client //   user provided or nil
+	timeout      time.Duration //   2s
+	queryTimeout time.Duration //   0: no limit
+	writeTimeout time.Duration //   0: no limit
+	peerTimeout  time.Duration //   0: no limit
+	ID           string        //   generated in init()
+	client       *http.Client  //   user provided or built from the config
+	ownsClient   bool          //   true unless the client is user provided
+
+	retry        RetryPolicy    //   every peer tried once
+	health       *healthTracker //   shared with the views
+	hedge        *hedger        //   shared with the views
+	headers      http.Header    //   nil: no extra headers
+	authProvider AuthProvider   //   static username & password if both set, or nil
+	propagator   Propagator     //   nil: no trace headers
+	spanTracer   SpanTracer     //   nil: no spans
 }
 
-// Close will mark the connection as closed. It is safe to be called
-// multiple times.
+// connShared holds the state that a Connection shares with all the views
+// created from it with With().
+type connShared struct {
+	mu            sync.RWMutex
+	cluster       rqliteCluster
+	hasBeenClosed bool
+	serverVersion Version // recorded on Open
+	versionTried  bool    // true once the version detection ran
+
+	// when PruneUnreachable() first saw each unreachable node
+	unreachableSince map[string]time.Time
+
+	// incremented by each read sent to the read-only nodes
+	nonVoterReads uint64
+}
+
+// isClosed tells whether the connection (or any of its views) was closed.
+func (cs *connShared) isClosed() bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.hasBeenClosed
+}
+
+// clusterInfo returns a copy of the current cluster info.
+func (cs *connShared) clusterInfo() rqliteCluster {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.cluster
+}
+
+// setClusterInfo replaces the current cluster info.
+func (cs *connShared) setClusterInfo(rc rqliteCluster) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.cluster = rc
+}
+
+// With returns a lightweight view of the connection that uses the given call
+// options as defaults for all its calls. The view shares the cluster info
+// with the connection, so it costs no discovery; changing the settings of
+// the view (e.g. with SetConsistencyLevel) does not affect the connection
+// and vice versa. Closing the view closes the connection.
+//
+//	strong := conn.With(gorqlite.CallConsistency(gorqlite.ConsistencyLevelStrong))
+//	rows, err := strong.QueryOneContext(ctx, "SELECT balance FROM accounts WHERE id = 42")
+func (conn *Connection) With(opts ...CallOption) *Connection {
+	view := *conn
+	co := view.defaultCallOptions()
+	for _, opt := range opts {
+		opt(&co)
+	}
+	view.consistencyLevel = co.level
+	view.wantsTransactions = co.transaction
+	view.wantsQueueing = co.queue
+	view.idempotencyKey = co.idempotencyKey
+	return &view
+}
+
+// Close will mark the connection as closed and release the idle connections
+// of its transport. It is safe to be called multiple times.
 func (conn *Connection) Close() {
+	conn.mu.Lock()
 	conn.hasBeenClosed = true
+	conn.mu.Unlock()
 	trace("%s: %s", conn.ID, "closing connection")
-}
-
-func (conn *Connection) apiClient(get bool) *http.Client {
-	ret := conn.client
-	if ret == nil {
-		ret = &http.Client{}
-		if get {
-			ret.Timeout = time.Duration(conn.timeout) * time.Second
-		}
+	if conn.ownsClient && conn.client != nil {
+		conn.client.CloseIdleConnections()
 	}
-	return ret
 }
 
 // ConsistencyLevel tells the current consistency level
 func (conn *Connection) ConsistencyLevel() (string, error) {
-	if conn.hasBeenClosed {
+	if conn.isClosed() {
 		return "", ErrClosed
 	}
 	return consistencyLevelNames[conn.consistencyLevel], nil
@@ -100,11 +161,11 @@ func (conn *Connection) ConsistencyLevel() (string, error) {
 
 // Leader tells the current leader of the cluster
 func (conn *Connection) Leader(ctx context.Context) (string, error) {
-	if conn.hasBeenClosed {
+	if conn.isClosed() {
 		return "", ErrClosed
 	}
 	if conn.disableClusterDiscovery {
-		return string(conn.cluster.leader), nil
+		return string(conn.clusterInfo().leader), nil
 	}
 	trace("%s: Leader(), calling updateClusterInfo()", conn.ID)
 	err := conn.updateClusterInfo(ctx)
@@ -114,19 +175,19 @@ func (conn *Connection) Leader(ctx context.Context) (string, error) {
 	} else {
 		trace("%s: Leader(), updateClusterInfo() OK", conn.ID)
 	}
-	return string(conn.cluster.leader), nil
+	return string(conn.clusterInfo().leader), nil
 }
 
 // Peers tells the current peers of the cluster
 func (conn *Connection) Peers(ctx context.Context) ([]string, error) {
-	if conn.hasBeenClosed {
+	if conn.isClosed() {
 		var ans []string
 		return ans, ErrClosed
 	}
 	plist := make([]string, 0)
 
 	if conn.disableClusterDiscovery {
-		for _, p := range conn.cluster.peerList {
+		for _, p := range conn.clusterInfo().peerList {
 			plist = append(plist, string(p))
 		}
 		return plist, nil
@@ -140,17 +201,18 @@ func (conn *Connection) Peers(ctx context.Context) ([]string, error) {
 	} else {
 		trace("%s: Peers(), updateClusterInfo() OK", conn.ID)
 	}
-	if conn.cluster.leader != "" {
-		plist = append(plist, string(conn.cluster.leader))
+	rc := conn.clusterInfo()
+	if rc.leader != "" {
+		plist = append(plist, string(rc.leader))
 	}
-	for _, p := range conn.cluster.otherPeers {
+	for _, p := range rc.otherPeers {
 		plist = append(plist, string(p))
 	}
 	return plist, nil
 }
 
 func (conn *Connection) SetConsistencyLevel(levelDesired string) error {
-	if conn.hasBeenClosed {
+	if conn.isClosed() {
 		return ErrClosed
 	}
 	_, ok := consistencyLevels[levelDesired]
@@ -162,7 +224,7 @@ func (conn *Connection) SetConsistencyLevel(levelDesired string) error {
 }
 
 func (conn *Connection) SetConsistency(levelDesired consistencyLevel) error {
-	if conn.hasBeenClosed {
+	if conn.isClosed() {
 		return ErrClosed
 	}
 
@@ -175,13 +237,34 @@ func (conn *Connection) SetConsistency(levelDesired consistencyLevel) error {
 }
 
 func (conn *Connection) SetExecutionWithTransaction(state bool) error {
-	if conn.hasBeenClosed {
+	if conn.isClosed() {
 		return ErrClosed
 	}
 	conn.wantsTransactions = state
 	return nil
 }
 
+// SetPropagator sets the Propagator used to inject trace headers (e.g.
+// traceparent/tracestate) into every request sent to rqlite. Pass nil to stop
+// sending trace headers.
+func (conn *Connection) SetPropagator(p Propagator) error {
+	if conn.isClosed() {
+		return ErrClosed
+	}
+	conn.propagator = p
+	return nil
+}
+
+// SetSpanTracer sets the SpanTracer used to emit a client span for every API
+// call, with a child span per peer attempt. Pass nil to stop emitting spans.
+func (conn *Connection) SetSpanTracer(t SpanTracer) error {
+	if conn.isClosed() {
+		return ErrClosed
+	}
+	conn.spanTracer = t
+	return nil
+}
+
 // initConnection takes the initial connection URL specified by
 // the user, and parses it into a peer.  This peer is assumed to
 // be the leader.  The next thing Open() does is updateClusterInfo()
@@ -215,91 +298,75 @@ func (conn *Connection) SetExecutionWithTransaction(state bool) error {
 //	hostname                    "localhost"
 //	port                        "4001"
 //	consistencyLevel            "weak"
-//	timeout                     "2"
+//	timeout                     "2" (seconds, or a duration like "500ms")
 func (conn *Connection) initConnection(url string) error {
-	// do some sanity checks.  You know users.
-
-	if len(url) < 7 {
-		return errors.New("url specified is impossibly short")
-	}
-
-	if !strings.HasPrefix(url, "http") {
-		return errors.New("url does not start with 'http'")
+	cfg := NewConfig()
+	if err := cfg.ParseDSN(url); err != nil {
+		return err
 	}
+	trace("%s: ParseDSN() OK", conn.ID)
+	return conn.applyConfig(cfg)
+}
 
-	u, err := nurl.Parse(url)
-	if err != nil {
+// applyConfig validates the given config and prepares the connection for
+// work with it. Like initConnection(), it does not talk to rqlite.
+func (conn *Connection) applyConfig(cfg *Config) error {
+	if err := cfg.validate(); err != nil {
 		return err
 	}
-	trace("%s: net.url.Parse() OK", conn.ID)
 
-	if u.Scheme == "https" {
-		conn.wantsHTTPS = true
+	conn.wantsHTTPS = cfg.HTTPS
+	conn.username = cfg.Username
+	conn.password = cfg.Password
+	conn.consistencyLevel = cfg.Consistency
+	conn.timeout = cfg.Timeout
+	conn.queryTimeout = cfg.QueryTimeout
+	conn.writeTimeout = cfg.WriteTimeout
+	conn.peerTimeout = cfg.PeerTimeout
+	conn.wantsTransactions = cfg.Transactions
+	conn.disableClusterDiscovery = cfg.DisableClusterDiscovery
+	conn.retry = cfg.Retry
+	if conn.retry.Attempts == 0 {
+		conn.retry.Attempts = 1
 	}
-
-	// specs say Username() is always populated even if empty
-	if u.User == nil {
-		conn.username = ""
-		conn.password = ""
-	} else {
-		// guaranteed, but could be empty which is ok
-		conn.username = u.User.Username()
-
-		// not guaranteed, so test if set
-		pass, isset := u.User.Password()
-		if isset {
-			conn.password = pass
-		} else {
-			conn.password = ""
+	conn.health = newHealthTracker(cfg.Breaker)
+	conn.hedge = newHedger(cfg.Hedge)
+	conn.headers = cfg.Headers.Clone()
+	conn.authProvider = cfg.AuthProvider
+	if conn.authProvider == nil && conn.username != "" && conn.password != "" {
+		conn.authProvider = &StaticAuthProvider{Username: conn.username, Password: conn.password}
+	}
+	conn.propagator = cfg.Propagator
+	conn.spanTracer = cfg.SpanTracer
+	conn.client = cfg.HTTPClient
+	conn.ownsClient = false
+	if conn.client == nil {
+		client, err := newHTTPClient(cfg)
+		if err != nil {
+			return err
 		}
+		conn.client = client
+		conn.ownsClient = true
 	}
 
-	if u.Host == "" {
-		conn.cluster.leader = "localhost:4001"
-	} else {
-		conn.cluster.leader = peer(u.Host)
+	peers := cfg.Peers
+	if len(peers) == 0 {
+		peers = []string{"localhost:4001"}
 	}
-	conn.cluster.peerList = []peer{conn.cluster.leader}
-
-	// defaults
-	conn.consistencyLevel = ConsistencyLevelWeak
-	conn.timeout = defaultTimeout
-	conn.wantsTransactions = true
-	conn.disableClusterDiscovery = defaultDisableClusterDiscovery
-
-	if u.RawQuery != "" {
-
-		q := u.Query()
-		level := q.Get("level")
-		if level != "" {
-			cl, ok := consistencyLevels[level]
-			if !ok {
-				return errors.New("invalid consistency level: " + level)
-			}
-			conn.consistencyLevel = cl
-		}
-
-		// timeout: default is set before initConnection is called
-		ts := q.Get("timeout")
-		if len(ts) > 0 {
-			var ti int
-			if ti, err = strconv.Atoi(ts); err != nil {
-				return errors.New("invalid timeout: " + ts)
-			}
-			conn.timeout = ti
-		}
-
-		dcd := q.Get("disableClusterDiscovery")
-		if dcd != "" {
-			dpd, err := strconv.ParseBool(dcd)
-			if err != nil {
-				return errors.New("invalid disableClusterDiscovery value: " + err.Error())
-			}
-			conn.disableClusterDiscovery = dpd
-		}
+	var rc rqliteCluster
+	rc.conn = conn
+	rc.leader = peer(peers[0])
+	for _, p := range peers[1:] {
+		rc.otherPeers = append(rc.otherPeers, peer(p))
 	}
+	rc.peerList = []peer{rc.leader}
+	rc.peerList = append(rc.peerList, rc.otherPeers...)
+	if conn.connShared == nil {
+		conn.connShared = &connShared{}
+	}
+	conn.setClusterInfo(rc)
 
-	trace("%s: parseDefaultPeer() is done:", conn.ID)
+	trace("%s: applyConfig() is done:", conn.ID)
 	if conn.wantsHTTPS {
 		trace("%s:    %s -> %s", conn.ID, "wants https?", "yes")
 	} else {
@@ -307,13 +374,15 @@ func (conn *Connection) initConnection(url string) error {
 	}
 	trace("%s:    %s -> %s", conn.ID, "username", conn.username)
 	trace("%s:    %s -> %s", conn.ID, "password", conn.password)
-	trace("%s:    %s -> %s", conn.ID, "host", conn.cluster.leader)
+	trace("%s:    %s -> %s", conn.ID, "host", rc.leader)
 	trace("%s:    %s -> %s", conn.ID, "consistencyLevel", consistencyLevelNames[conn.consistencyLevel])
 	trace("%s:    %s -> %v", conn.ID, "wantsTransaction", conn.wantsTransactions)
 	trace("%s:    %s -> %v", conn.ID, "timeout", conn.timeout)
+	trace("%s:    %s -> %v", conn.ID, "queryTimeout", conn.queryTimeout)
+	trace("%s:    %s -> %v", conn.ID, "writeTimeout", conn.writeTimeout)
+	trace("%s:    %s -> %v", conn.ID, "peerTimeout", conn.peerTimeout)
 	trace("%s:    %s -> %v", conn.ID, "clusterDiscovery", !conn.disableClusterDiscovery)
-
-	conn.cluster.conn = conn
+	trace("%s:    %s -> %+v", conn.ID, "retry", conn.retry)
 
 	return nil
 }
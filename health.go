@@ -0,0 +1,246 @@
+package gorqlite
+
+// this file contains the per-peer health tracking:
+//
+//   BreakerPolicy and the circuit breaker of each peer
+//   healthTracker ordering the peers of a call by health and latency
+//   Connection.PeerHealth() exposing the state
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of the circuit breaker of a peer.
+type BreakerState int
+
+const (
+	// BreakerClosed lets requests through: the peer is healthy.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen skips the peer until the open timeout elapses.
+	BreakerOpen
+	// BreakerHalfOpen lets a single probe request through: its outcome
+	// closes or reopens the breaker.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerPolicy configures the circuit breakers of the peers.
+type BreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failures that open the
+	// breaker of a peer. 0 disables the breakers.
+	FailureThreshold int
+	// OpenTimeout is how long an open breaker skips its peer before a probe
+	// request is let through.
+	OpenTimeout time.Duration
+	// LatencyWeight is the weight of the last request in the latency moving
+	// average of a peer, between 0 and 1.
+	LatencyWeight float64
+}
+
+var defaultBreakerPolicy = BreakerPolicy{
+	FailureThreshold: 3,
+	OpenTimeout:      5 * time.Second,
+	LatencyWeight:    0.2,
+}
+
+func (bp BreakerPolicy) validate() error {
+	if bp.FailureThreshold < 0 || bp.OpenTimeout < 0 || bp.LatencyWeight < 0 || bp.LatencyWeight > 1 {
+		return errors.New("invalid breaker policy")
+	}
+	return nil
+}
+
+// PeerState is the health of a peer, as returned by PeerHealth().
+type PeerState struct {
+	Peer                string // host:port
+	State               BreakerState
+	ConsecutiveFailures int
+	Latency             time.Duration // moving average, 0 if never reached
+	LastError           string
+	LastFailure         time.Time
+}
+
+// peerHealth is the tracked health of a peer.
+type peerHealth struct {
+	state     BreakerState
+	failures  int
+	latency   time.Duration
+	lastError string
+	lastFail  time.Time
+	openedAt  time.Time // when the breaker opened, or the probe was sent
+}
+
+// healthTracker tracks the health of the peers of a connection and its
+// views.
+type healthTracker struct {
+	policy BreakerPolicy
+
+	mu    sync.Mutex
+	peers map[peer]*peerHealth
+}
+
+func newHealthTracker(policy BreakerPolicy) *healthTracker {
+	return &healthTracker{
+		policy: policy,
+		peers:  make(map[peer]*peerHealth),
+	}
+}
+
+// get returns the health of the peer; the caller holds the lock.
+func (ht *healthTracker) get(p peer) *peerHealth {
+	ph, ok := ht.peers[p]
+	if !ok {
+		ph = &peerHealth{}
+		ht.peers[p] = ph
+	}
+	return ph
+}
+
+// eligible tells whether a request may be sent to the peer: its breaker is
+// closed, or open or half-open for longer than its timeout, i.e. the probe
+// is due or was never answered. It doesn't change the state of the breaker,
+// see begin. The caller holds the lock.
+func (ht *healthTracker) eligible(ph *peerHealth, now time.Time) bool {
+	if ph.state == BreakerClosed {
+		return true
+	}
+	return now.Sub(ph.openedAt) >= ht.policy.OpenTimeout
+}
+
+// begin records that a request is being sent to the peer: an open breaker
+// whose timeout elapsed turns half-open, the request being its probe.
+func (ht *healthTracker) begin(p peer) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	ph := ht.get(p)
+	now := time.Now()
+	if ph.state == BreakerClosed || !ht.eligible(ph, now) {
+		// a peer tried although its breaker is open, as all of them are
+		return
+	}
+	if ph.state == BreakerOpen {
+		trace("peer %s failing for %v, probing it", p, now.Sub(ph.openedAt))
+	}
+	ph.state = BreakerHalfOpen
+	ph.openedAt = now
+}
+
+// arrange returns the peers to try for a call: the first pinned peers keep
+// their order (e.g. the leader), the others are sorted by latency, and the
+// peers with an open breaker are left out, unless no peer is left.
+func (ht *healthTracker) arrange(peers []peer, pinned int) []peer {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	if pinned > len(peers) {
+		pinned = len(peers)
+	}
+	now := time.Now()
+	arranged := make([]peer, 0, len(peers))
+	for _, p := range peers[:pinned] {
+		if ht.eligible(ht.get(p), now) {
+			arranged = append(arranged, p)
+		}
+	}
+	rest := make([]peer, 0, len(peers)-pinned)
+	for _, p := range peers[pinned:] {
+		if ht.eligible(ht.get(p), now) {
+			rest = append(rest, p)
+		}
+	}
+	sort.SliceStable(rest, func(i, j int) bool {
+		return ht.peers[rest[i]].latency < ht.peers[rest[j]].latency
+	})
+	arranged = append(arranged, rest...)
+
+	if len(arranged) == 0 {
+		// all the breakers are open: better try them than fail right away
+		return peers
+	}
+	return arranged
+}
+
+// success records a request answered by the peer.
+func (ht *healthTracker) success(p peer, latency time.Duration) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	ph := ht.get(p)
+	if ph.state != BreakerClosed {
+		trace("peer %s healthy again, closing its breaker", p)
+	}
+	ph.state = BreakerClosed
+	ph.failures = 0
+	if ph.latency == 0 || ht.policy.LatencyWeight == 0 {
+		ph.latency = latency
+	} else {
+		w := ht.policy.LatencyWeight
+		ph.latency = time.Duration(w*float64(latency) + (1-w)*float64(ph.latency))
+	}
+}
+
+// failure records a request the peer failed to answer.
+func (ht *healthTracker) failure(p peer, err error) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	now := time.Now()
+	ph := ht.get(p)
+	ph.failures++
+	ph.lastError = err.Error()
+	ph.lastFail = now
+	if ht.policy.FailureThreshold == 0 {
+		return
+	}
+	if ph.state == BreakerHalfOpen || ph.failures >= ht.policy.FailureThreshold {
+		if ph.state != BreakerOpen {
+			trace("peer %s failed %d times, opening its breaker", p, ph.failures)
+		}
+		ph.state = BreakerOpen
+		ph.openedAt = now
+	}
+}
+
+// snapshot returns the state of the given peers.
+func (ht *healthTracker) snapshot(peers []peer) []PeerState {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	states := make([]PeerState, 0, len(peers))
+	for _, p := range peers {
+		ph := ht.get(p)
+		states = append(states, PeerState{
+			Peer:                string(p),
+			State:               ph.state,
+			ConsecutiveFailures: ph.failures,
+			Latency:             ph.latency,
+			LastError:           ph.lastError,
+			LastFailure:         ph.lastFail,
+		})
+	}
+	return states
+}
+
+// PeerHealth returns the health of the known peers, read-only nodes included,
+// sorted by host:port.
+func (conn *Connection) PeerHealth() []PeerState {
+	rc := conn.clusterInfo()
+	peers := append(append([]peer{}, rc.PeerList()...), rc.nonVoters...)
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+	return conn.health.snapshot(peers)
+}
@@ -0,0 +1,75 @@
+package gorqlite
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	ht := newHealthTracker(BreakerPolicy{FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond, LatencyWeight: 0.5})
+	peers := []peer{"leader:4001", "a:4001", "b:4001"}
+	failure := errors.New("connection refused")
+
+	ht.failure("a:4001", failure)
+	requireString(t, "[leader:4001 a:4001 b:4001]", fmt.Sprint(ht.arrange(peers, 1)))
+
+	// the second consecutive failure opens the breaker
+	ht.failure("a:4001", failure)
+	requireString(t, "open", ht.snapshot([]peer{"a:4001"})[0].State.String())
+	requireString(t, "[leader:4001 b:4001]", fmt.Sprint(ht.arrange(peers, 1)))
+
+	// a single probe once the open timeout elapsed: arranging the peers
+	// doesn't change the breaker, only sending the probe does
+	time.Sleep(60 * time.Millisecond)
+	requireString(t, "[leader:4001 a:4001 b:4001]", fmt.Sprint(ht.arrange(peers, 1)))
+	requireString(t, "[leader:4001 a:4001 b:4001]", fmt.Sprint(ht.arrange(peers, 1)))
+	requireString(t, "open", ht.snapshot([]peer{"a:4001"})[0].State.String())
+	ht.begin("a:4001")
+	requireString(t, "half-open", ht.snapshot([]peer{"a:4001"})[0].State.String())
+	requireString(t, "[leader:4001 b:4001]", fmt.Sprint(ht.arrange(peers, 1)))
+
+	// a failed probe reopens the breaker, a successful one closes it
+	ht.failure("a:4001", failure)
+	requireString(t, "open", ht.snapshot([]peer{"a:4001"})[0].State.String())
+	time.Sleep(60 * time.Millisecond)
+	ht.begin("a:4001")
+	ht.success("a:4001", time.Millisecond)
+	st := ht.snapshot([]peer{"a:4001"})[0]
+	requireString(t, "closed", st.State.String())
+	requireInt(t, 0, st.ConsecutiveFailures)
+	requireString(t, "connection refused", st.LastError)
+
+	// all breakers open: all the peers are tried anyway
+	for _, p := range peers {
+		ht.failure(p, failure)
+		ht.failure(p, failure)
+	}
+	requireString(t, "[leader:4001 a:4001 b:4001]", fmt.Sprint(ht.arrange(peers, 1)))
+	// and trying them before their timeout elapsed doesn't make them half-open
+	ht.begin("a:4001")
+	requireString(t, "open", ht.snapshot([]peer{"a:4001"})[0].State.String())
+}
+
+func TestBreakerLatency(t *testing.T) {
+	ht := newHealthTracker(BreakerPolicy{LatencyWeight: 0.5})
+	peers := []peer{"leader:4001", "a:4001", "b:4001", "c:4001"}
+
+	ht.success("leader:4001", 50*time.Millisecond)
+	ht.success("a:4001", 30*time.Millisecond)
+	ht.success("b:4001", 10*time.Millisecond)
+	ht.success("c:4001", 20*time.Millisecond)
+	requireString(t, "[leader:4001 b:4001 c:4001 a:4001]", fmt.Sprint(ht.arrange(peers, 1)))
+
+	// moving average
+	ht.success("b:4001", 50*time.Millisecond)
+	requireDuration(t, 30*time.Millisecond, ht.snapshot([]peer{"b:4001"})[0].Latency)
+	requireString(t, "[leader:4001 c:4001 a:4001 b:4001]", fmt.Sprint(ht.arrange(peers, 1)))
+
+	// breakers disabled
+	for i := 0; i < 10; i++ {
+		ht.failure("a:4001", errors.New("boom"))
+	}
+	requireString(t, "closed", ht.snapshot([]peer{"a:4001"})[0].State.String())
+}
@@ -0,0 +1,92 @@
+package gorqlite
+
+// this file contains the hedged reads:
+//
+//   HedgePolicy telling when a read is sent to a second peer
+//   hedger keeping the latency samples of the reads
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HedgePolicy enables hedged reads: a query with no consistency that a peer
+// is slow to answer is also sent to the next peer, and the first answer wins.
+// The hedge delay is the given percentile of the latency of the recent
+// reads, or Delay until enough reads were seen. Hedging is disabled if both
+// Delay and Percentile are 0.
+type HedgePolicy struct {
+	// Delay is the time to wait for an answer before hedging
+	Delay time.Duration
+	// Percentile, between 0 and 1 (e.g. 0.95), derives the delay from the
+	// latency of the recent reads
+	Percentile float64
+}
+
+func (hp HedgePolicy) validate() error {
+	if hp.Delay < 0 || hp.Percentile < 0 || hp.Percentile >= 1 {
+		return errors.New("invalid hedge policy")
+	}
+	return nil
+}
+
+const (
+	hedgeSamples    = 256 // number of recent reads the percentile is computed from
+	hedgeMinSamples = 20  // reads needed before the percentile is used
+)
+
+// hedger keeps the latency of the recent reads of a connection and its
+// views.
+type hedger struct {
+	policy HedgePolicy
+
+	mu      sync.Mutex
+	samples []time.Duration // ring buffer
+	next    int
+}
+
+func newHedger(policy HedgePolicy) *hedger {
+	return &hedger{policy: policy}
+}
+
+// observe records the latency of a successful read.
+func (h *hedger) observe(d time.Duration) {
+	if h.policy.Percentile == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < hedgeSamples {
+		h.samples = append(h.samples, d)
+		return
+	}
+	h.samples[h.next] = d
+	h.next = (h.next + 1) % hedgeSamples
+}
+
+// delay returns the hedge delay, 0 if reads are not hedged.
+func (h *hedger) delay() time.Duration {
+	if h.policy.Percentile == 0 {
+		return h.policy.Delay
+	}
+	h.mu.Lock()
+	if len(h.samples) < hedgeMinSamples {
+		h.mu.Unlock()
+		return h.policy.Delay
+	}
+	sorted := append([]time.Duration(nil), h.samples...)
+	h.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(h.policy.Percentile*float64(len(sorted)-1))]
+}
+
+// hedgeDelay returns the hedge delay of a call, 0 if it is not hedged.
+func (conn *Connection) hedgeDelay(apiOp apiOperation) time.Duration {
+	if apiOp != api_QUERY || conn.consistencyLevel != ConsistencyLevelNone {
+		return 0
+	}
+	return conn.hedge.delay()
+}
@@ -0,0 +1,38 @@
+package gorqlite
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHedgerDelay(t *testing.T) {
+	h := newHedger(HedgePolicy{Delay: 100 * time.Millisecond, Percentile: 0.9})
+
+	// the fixed delay until enough reads were seen
+	for i := 1; i < hedgeMinSamples; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	requireDuration(t, 100*time.Millisecond, h.delay())
+
+	for i := hedgeMinSamples; i <= 100; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	requireDuration(t, 90*time.Millisecond, h.delay())
+
+	// only the recent reads count
+	for i := 0; i < hedgeSamples; i++ {
+		h.observe(5 * time.Millisecond)
+	}
+	requireDuration(t, 5*time.Millisecond, h.delay())
+
+	requireDuration(t, 0, newHedger(HedgePolicy{}).delay())
+}
+
+func TestHedgeDelay(t *testing.T) {
+	conn := Connection{hedge: newHedger(HedgePolicy{Delay: 10 * time.Millisecond})}
+	conn.consistencyLevel = ConsistencyLevelWeak
+	requireDuration(t, 0, conn.hedgeDelay(api_QUERY))
+	conn.consistencyLevel = ConsistencyLevelNone
+	requireDuration(t, 10*time.Millisecond, conn.hedgeDelay(api_QUERY))
+	requireDuration(t, 0, conn.hedgeDelay(api_WRITE))
+}
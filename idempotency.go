@@ -0,0 +1,145 @@
+package gorqlite
+
+// this file contains the safe write retries:
+//
+//   ErrAmbiguousWrite for the writes that may have been applied
+//   the idempotency keys recorded in the IdempotencyTable
+//   NewIdempotencyKey() and Connection.PruneIdempotencyKeys()
+//
+// a write is only replayed on another peer when the failed attempt was never
+// sent, or was rejected by the peer. When the response is lost the write may
+// have been applied: it is then replayed only if it carries an idempotency
+// key, which the transaction records so a second execution fails.
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// IdempotencyTable is the table recording the idempotency keys of the writes,
+// created on the first write with a key.
+const IdempotencyTable = "gorqlite_idempotency"
+
+var (
+	// ErrAmbiguousWrite is returned, wrapped, when a write was sent but its
+	// response was lost: it may or may not have been applied, so it wasn't
+	// replayed. Use CallIdempotencyKey to make such writes safe to replay.
+	ErrAmbiguousWrite = errors.New("gorqlite: write sent but outcome unknown")
+
+	// ErrAlreadyApplied is returned when the idempotency key of a write is
+	// already recorded: the write was applied by a previous attempt or call,
+	// and was not applied again.
+	ErrAlreadyApplied = errors.New("gorqlite: write already applied")
+)
+
+// NewIdempotencyKey returns a random key for CallIdempotencyKey.
+func NewIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
+
+var createIdempotencyTable = Statement{
+	Query: "CREATE TABLE IF NOT EXISTS " + IdempotencyTable + " (key TEXT PRIMARY KEY, created_at INTEGER NOT NULL)",
+}
+
+// idempotencyStatements returns the statements recording the key, executed
+// before the statements of the write.
+func idempotencyStatements(key string, now time.Time) []Statement {
+	return []Statement{
+		createIdempotencyTable,
+		{Query: "INSERT INTO " + IdempotencyTable + " (key, created_at) VALUES (?, ?)", Arguments: []interface{}{key, now.Unix()}},
+	}
+}
+
+// withIdempotencyKey returns the view and statements of a write with the
+// idempotency key of the connection, if any: the key is recorded in the
+// transaction of the write.
+func (conn *Connection) withIdempotencyKey(sqlStatements []Statement) (*Connection, []Statement, error) {
+	if conn.idempotencyKey == "" {
+		return conn, sqlStatements, nil
+	}
+	if conn.wantsQueueing {
+		return nil, nil, errors.New("idempotency keys are not supported by queued writes")
+	}
+	if !conn.wantsTransactions {
+		conn = conn.With(CallTransaction(true))
+	}
+	trace("%s: recording idempotency key %s", conn.ID, conn.idempotencyKey)
+	stmts := idempotencyStatements(conn.idempotencyKey, time.Now())
+	return conn, append(stmts, sqlStatements...), nil
+}
+
+// checkIdempotencyResults strips the results of the statements recording the
+// idempotency key, failing with ErrAlreadyApplied if the key was recorded.
+func (conn *Connection) checkIdempotencyResults(resultsArray []interface{}) ([]interface{}, error) {
+	if conn.idempotencyKey == "" {
+		return resultsArray, nil
+	}
+	if len(resultsArray) < 2 {
+		if len(resultsArray) == 1 {
+			if errMsg, err := resultError(resultsArray[0]); err == nil && errMsg != "" {
+				return nil, fmt.Errorf("could not create the %s table: %s", IdempotencyTable, errMsg)
+			}
+		}
+		return nil, errors.New("missing idempotency key results in response")
+	}
+	errMsg, err := resultError(resultsArray[1])
+	if err != nil {
+		return nil, err
+	}
+	if errMsg != "" {
+		if strings.Contains(errMsg, "UNIQUE constraint failed") {
+			trace("%s: idempotency key %s already recorded", conn.ID, conn.idempotencyKey)
+			return nil, ErrAlreadyApplied
+		}
+		return nil, fmt.Errorf("could not record the idempotency key: %s", errMsg)
+	}
+	return resultsArray[2:], nil
+}
+
+// resultError returns the error message of a statement result, or an error
+// if the result isn't a JSON object.
+func resultError(result interface{}) (string, error) {
+	r, ok := result.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("unexpected idempotency key result in response: %v", result)
+	}
+	errMsg, _ := r["error"].(string)
+	return errMsg, nil
+}
+
+// PruneIdempotencyKeys deletes the idempotency keys recorded more than
+// olderThan ago, returning how many were deleted. A write can't be replayed
+// safely once its key is pruned.
+func (conn *Connection) PruneIdempotencyKeys(ctx context.Context, olderThan time.Duration) (int64, error) {
+	if conn.isClosed() {
+		return 0, ErrClosed
+	}
+	// the table is created if no write recorded a key yet
+	results, err := conn.WriteParameterizedContext(ctx, []Statement{
+		createIdempotencyTable,
+		{
+			Query:     "DELETE FROM " + IdempotencyTable + " WHERE created_at < ?",
+			Arguments: []interface{}{time.Now().Add(-olderThan).Unix()},
+		},
+	}, CallIdempotencyKey(""))
+	if err != nil {
+		for _, wr := range results {
+			if wr.Err != nil {
+				return 0, wr.Err
+			}
+		}
+		return 0, err
+	}
+	if len(results) != 2 {
+		return 0, fmt.Errorf("expected 2 results, got %d", len(results))
+	}
+	return results[1].RowsAffected, nil
+}
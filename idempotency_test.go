@@ -0,0 +1,94 @@
+package gorqlite
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPeerErrorMaybeApplied(t *testing.T) {
+	for _, tc := range []struct {
+		sent   bool
+		status int
+		want   bool
+	}{
+		{false, 0, false},
+		{true, 0, true},
+		{true, http.StatusBadRequest, false},
+		{true, http.StatusTooManyRequests, false},
+		{true, http.StatusInternalServerError, true},
+		{true, http.StatusServiceUnavailable, false},
+		{true, http.StatusGatewayTimeout, true},
+	} {
+		pe := &peerError{sent: tc.sent, status: tc.status, err: errors.New("failed")}
+		requireBool(t, tc.want, pe.maybeApplied())
+	}
+}
+
+func TestAmbiguousWrite(t *testing.T) {
+	conn := &Connection{}
+	lost := &peerError{sent: true, err: errors.New("EOF")}
+
+	if err := conn.ambiguousWrite(api_WRITE, lost); !errors.Is(err, ErrAmbiguousWrite) {
+		t.Errorf("expected ErrAmbiguousWrite, got %v", err)
+	}
+	if err := conn.ambiguousWrite(api_QUERY, lost); err != nil {
+		t.Errorf("queries are safe to replay, got %v", err)
+	}
+	if err := conn.ambiguousWrite(api_WRITE, &peerError{err: errors.New("refused")}); err != nil {
+		t.Errorf("writes never sent are safe to replay, got %v", err)
+	}
+	conn.idempotencyKey = "k"
+	if err := conn.ambiguousWrite(api_WRITE, lost); err != nil {
+		t.Errorf("writes with an idempotency key are safe to replay, got %v", err)
+	}
+}
+
+func TestWithIdempotencyKey(t *testing.T) {
+	conn := &Connection{connShared: &connShared{}}
+	stmts := []Statement{{Query: "INSERT INTO foo VALUES (1)"}}
+
+	view, got, err := conn.withIdempotencyKey(stmts)
+	if err != nil || view != conn || len(got) != 1 {
+		t.Fatalf("expected the statements unchanged without a key, got %v, %v", got, err)
+	}
+
+	view, got, err = conn.With(CallIdempotencyKey("k")).withIdempotencyKey(stmts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requireBool(t, true, view.wantsTransactions)
+	requireInt(t, 3, len(got))
+	requireString(t, "k", got[1].Arguments[0].(string))
+	requireString(t, stmts[0].Query, got[2].Query)
+
+	_, _, err = conn.With(CallIdempotencyKey("k"), CallQueueing()).withIdempotencyKey(stmts)
+	if err == nil {
+		t.Errorf("expected queued writes to reject idempotency keys")
+	}
+}
+
+func TestCheckIdempotencyResults(t *testing.T) {
+	conn := &Connection{idempotencyKey: "k"}
+	ok := map[string]interface{}{"rows_affected": float64(1)}
+
+	results, err := conn.checkIdempotencyResults([]interface{}{ok, ok, ok})
+	if err != nil || len(results) != 1 {
+		t.Errorf("expected the key results to be stripped, got %v, %v", results, err)
+	}
+	dup := map[string]interface{}{"error": "UNIQUE constraint failed: gorqlite_idempotency.key"}
+	if _, err = conn.checkIdempotencyResults([]interface{}{ok, dup}); !errors.Is(err, ErrAlreadyApplied) {
+		t.Errorf("expected ErrAlreadyApplied, got %v", err)
+	}
+	if _, err = conn.checkIdempotencyResults([]interface{}{ok}); err == nil {
+		t.Errorf("expected an error for missing results")
+	}
+	for _, results := range [][]interface{}{{nil}, {ok, nil}, {ok, "ok", ok}, {ok, []interface{}{}}} {
+		if _, err = conn.checkIdempotencyResults(results); err == nil {
+			t.Errorf("expected an error for the results %v", results)
+		}
+	}
+	stmts := idempotencyStatements("k", time.Unix(42, 0))
+	requireInt(t, 42, int(stmts[1].Arguments[1].(int64)))
+}
@@ -0,0 +1,89 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+func TestPruneUnreachable(t *testing.T) {
+	var mu sync.Mutex
+	var removed []string
+	m := &MockServer{
+		Port: "14001",
+		Nodes: []byte(`{
+			"1": {"api_addr": "http://localhost:14001", "addr": "localhost:14002", "reachable": true, "leader": true, "voter": true},
+			"2": {"api_addr": "http://localhost:14003", "addr": "localhost:14004", "reachable": true, "voter": true},
+			"3": {"addr": "localhost:14006", "reachable": false, "voter": true, "error": "connection refused"},
+			"ro": {"addr": "localhost:14008", "reachable": false, "voter": false, "error": "connection refused"}
+		}`),
+		OnRequest: func(r *http.Request) {
+			if r.URL.Path != "/remove" {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			body, _ := io.ReadAll(r.Body)
+			removed = append(removed, r.Method+" "+string(body))
+		},
+	}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	nonVoters, err := conn.NonVoters(ctx)
+	if err != nil || len(nonVoters) != 1 || nonVoters[0].ID != "ro" {
+		t.Errorf("unexpected non-voters: %+v, %v", nonVoters, err)
+	}
+	unreachable, err := conn.UnreachableNodes(ctx)
+	if err != nil || len(unreachable) != 2 {
+		t.Errorf("unexpected unreachable nodes: %+v, %v", unreachable, err)
+	}
+
+	// not unreachable for long enough yet
+	pruned, err := conn.PruneUnreachable(ctx, time.Hour)
+	if err != nil || len(pruned) != 0 {
+		t.Errorf("expected nothing to be pruned, got %+v, %v", pruned, err)
+	}
+
+	pruned, err = conn.PruneUnreachable(ctx, 0)
+	if err != nil || len(pruned) != 2 {
+		t.Errorf("expected 2 nodes to be pruned, got %+v, %v", pruned, err)
+	}
+	mu.Lock()
+	if len(removed) != 2 || removed[0] != `DELETE {"id":"3"}` || removed[1] != `DELETE {"id":"ro"}` {
+		t.Errorf("unexpected removals: %v", removed)
+	}
+	removed = nil
+	mu.Unlock()
+
+	// 2 of 4 voters reachable
+	m.Nodes = []byte(`{
+		"1": {"api_addr": "http://localhost:14001", "addr": "localhost:14002", "reachable": true, "leader": true, "voter": true},
+		"2": {"api_addr": "http://localhost:14003", "addr": "localhost:14004", "reachable": true, "voter": true},
+		"3": {"addr": "localhost:14006", "reachable": false, "voter": true},
+		"4": {"addr": "localhost:14008", "reachable": false, "voter": true}
+	}`)
+	_, err = conn.PruneUnreachable(ctx, 0)
+	if !errors.Is(err, gorqlite.ErrNoQuorum) {
+		t.Errorf("expected ErrNoQuorum, got %v", err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("expected no removal, got %v", removed)
+	}
+}
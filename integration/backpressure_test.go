@@ -0,0 +1,88 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+func TestBackpressure(t *testing.T) {
+	var first, second int64
+	busy := &MockServer{
+		Port:  "14001",
+		Query: []byte(`{"results":[{"columns":["id"],"types":["integer"],"values":[[1]]}]}`),
+	}
+	other := &MockServer{
+		Port:      "14003",
+		Query:     busy.Query,
+		OnRequest: func(*http.Request) { atomic.AddInt64(&second, 1) },
+	}
+	for _, m := range []*MockServer{busy, other} {
+		m.Start()
+		defer m.Stop()
+		if err := m.WaitForReady(); err != nil {
+			t.Fatalf("mock server failed to start: %v", err)
+		}
+	}
+
+	open := func() *gorqlite.Connection {
+		conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true,http://localhost:14003")
+		if err != nil {
+			t.Fatalf("failed to open connection: %v", err)
+		}
+		return conn
+	}
+
+	t.Run("busy peer tried again after Retry-After", func(t *testing.T) {
+		atomic.StoreInt64(&first, 0)
+		atomic.StoreInt64(&second, 0)
+		busy.Respond = func(w http.ResponseWriter, req *http.Request) bool {
+			if atomic.AddInt64(&first, 1) > 1 {
+				return false
+			}
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("leader not found"))
+			return true
+		}
+		conn := open()
+		defer conn.Close()
+
+		start := time.Now()
+		if _, err := conn.QueryOneContext(context.Background(), "SELECT 1"); err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < time.Second {
+			t.Errorf("expected the busy peer to be tried again after 1s, got %v", elapsed)
+		}
+		if n := atomic.LoadInt64(&first); n != 2 {
+			t.Errorf("expected 2 requests to the busy peer, got %d", n)
+		}
+		if n := atomic.LoadInt64(&second); n != 0 {
+			t.Errorf("expected no request to the other peer, got %d", n)
+		}
+	})
+
+	t.Run("unauthorized not tried on other peers", func(t *testing.T) {
+		atomic.StoreInt64(&second, 0)
+		busy.Respond = func(w http.ResponseWriter, req *http.Request) bool {
+			w.WriteHeader(http.StatusUnauthorized)
+			return true
+		}
+		conn := open()
+		defer conn.Close()
+
+		_, err := conn.QueryOneContext(context.Background(), "SELECT 1")
+		if !errors.Is(err, gorqlite.ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+		if n := atomic.LoadInt64(&second); n != 0 {
+			t.Errorf("expected no request to the other peer, got %d", n)
+		}
+	})
+}
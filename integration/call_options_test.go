@@ -0,0 +1,118 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+func TestCallOptions(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	m := &MockServer{
+		Port:    "14001",
+		Query:   []byte(`{"results":[{"columns":["id"],"types":["integer"],"values":[[1]]}]}`),
+		Execute: []byte(`{"results":[{"last_insert_id":1,"rows_affected":1}],"sequence_number":7}`),
+		Request: []byte(`{"results":[{"last_insert_id":1,"rows_affected":1}]}`),
+		OnRequest: func(r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			queries = append(queries, r.URL.Path+"?"+r.URL.RawQuery)
+		},
+	}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true&level=weak")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	strong := conn.With(gorqlite.CallConsistency(gorqlite.ConsistencyLevelStrong))
+
+	calls := []struct {
+		call func() error
+		want string
+	}{
+		{
+			call: func() error {
+				_, err := conn.QueryOneContext(ctx, "SELECT 1", gorqlite.CallConsistency(gorqlite.ConsistencyLevelNone), gorqlite.CallTransaction(false))
+				return err
+			},
+			want: "/db/query?timings&level=none",
+		},
+		{
+			// the options of a call don't stick to the connection
+			call: func() error {
+				_, err := conn.QueryOneContext(ctx, "SELECT 1")
+				return err
+			},
+			want: "/db/query?timings&level=weak&transaction",
+		},
+		{
+			call: func() error {
+				_, err := strong.QueryOneContext(ctx, "SELECT 1")
+				return err
+			},
+			want: "/db/query?timings&level=strong&transaction",
+		},
+		{
+			call: func() error {
+				wr, err := conn.WriteOneContext(ctx, "INSERT INTO foo VALUES (1)", gorqlite.CallQueueing())
+				if err == nil && wr.SequenceNumber != 7 {
+					t.Errorf("expected sequence number 7, got %d", wr.SequenceNumber)
+				}
+				return err
+			},
+			want: "/db/execute?timings&level=weak&transaction&queue",
+		},
+		{
+			call: func() error {
+				_, err := conn.WriteOneContext(ctx, "INSERT INTO foo VALUES (1)")
+				return err
+			},
+			want: "/db/execute?timings&level=weak&transaction",
+		},
+		{
+			call: func() error {
+				_, err := conn.RequestParameterizedContext(ctx, []gorqlite.ParameterizedStatement{{Query: "INSERT INTO foo VALUES (1)"}},
+					gorqlite.CallConsistency(gorqlite.ConsistencyLevelStrong), gorqlite.CallTransaction(false))
+				return err
+			},
+			want: "/db/request?timings&level=strong",
+		},
+		{
+			call: func() error {
+				_, err := conn.QueryStmt(ctx, []*gorqlite.Statement{gorqlite.NewStatement("SELECT 1")}, gorqlite.CallConsistency(gorqlite.ConsistencyLevelNone))
+				return err
+			},
+			want: "/db/query?timings&level=none&transaction",
+		},
+	}
+
+	for i, c := range calls {
+		if err := c.call(); err != nil {
+			t.Fatalf("call %d failed: %v", i, err)
+		}
+		mu.Lock()
+		got := queries[len(queries)-1]
+		mu.Unlock()
+		if got != c.want {
+			t.Errorf("call %d: expected %s, got %s", i, c.want, got)
+		}
+	}
+
+	// a view shares the state of its connection
+	conn.Close()
+	if _, err := strong.QueryOneContext(ctx, "SELECT 1"); err != gorqlite.ErrClosed {
+		t.Errorf("expected the view to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	var writes []string
+	version := 3
+	m := &MockServer{
+		Port: "14001",
+		Respond: func(w http.ResponseWriter, req *http.Request) bool {
+			body, _ := io.ReadAll(req.Body)
+			switch req.URL.Path {
+			case "/db/query":
+				if !strings.Contains(string(body), `"id\" IS ?`) {
+					t.Errorf("unexpected query: %s", body)
+				}
+				w.Write([]byte(`{"results":[{"columns":["id","state","version"],"types":["integer","text","integer"],"values":[[42,"pending",` +
+					strconv.Itoa(version) + `]]}]}`))
+			case "/db/execute":
+				writes = append(writes, string(body))
+				// the first update conflicts with a concurrent one
+				if len(writes) == 1 {
+					version++
+					w.Write([]byte(`{"results":[{"rows_affected":0}]}`))
+					return true
+				}
+				w.Write([]byte(`{"results":[{"rows_affected":1}]}`))
+			default:
+				return false
+			}
+			return true
+		},
+	}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	t.Run("conflict", func(t *testing.T) {
+		writes = nil
+		_, err := conn.CompareAndSwap(ctx, "jobs", gorqlite.Values{"id": 42},
+			gorqlite.Values{"state": "pending"}, gorqlite.Values{"state": "running"})
+		if !errors.Is(err, gorqlite.ErrConflict) {
+			t.Errorf("expected ErrConflict, got %v", err)
+		}
+		wr, err := conn.CompareAndSwap(ctx, "jobs", gorqlite.Values{"id": 42},
+			gorqlite.Values{"state": "pending"}, gorqlite.Values{"state": "running"})
+		if err != nil || wr.RowsAffected != 1 {
+			t.Errorf("expected the row to be updated, got %+v, %v", wr, err)
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		writes = nil
+		version = 3
+		var seen []int64
+		newVersion, err := conn.UpdateWithRetry(ctx, "jobs", gorqlite.Values{"id": 42}, 3,
+			func(row map[string]interface{}) (gorqlite.Values, error) {
+				seen = append(seen, row["version"].(int64))
+				return gorqlite.Values{"state": "done"}, nil
+			})
+		if err != nil {
+			t.Fatalf("UpdateWithRetry failed: %v", err)
+		}
+		if newVersion != 5 || len(seen) != 2 || seen[0] != 3 || seen[1] != 4 {
+			t.Errorf("expected versions 3 then 4 to be read and 5 written, got %v and %d", seen, newVersion)
+		}
+		if len(writes) != 2 || !strings.Contains(writes[1], `"done",5,42,4]`) {
+			t.Errorf("unexpected writes: %q", writes)
+		}
+	})
+
+	t.Run("too many conflicts", func(t *testing.T) {
+		writes = nil
+		_, err := conn.UpdateWithRetry(ctx, "jobs", gorqlite.Values{"id": 42}, 1,
+			func(row map[string]interface{}) (gorqlite.Values, error) {
+				return gorqlite.Values{"state": "done"}, nil
+			})
+		if !errors.Is(err, gorqlite.ErrConflict) {
+			t.Errorf("expected ErrConflict, got %v", err)
+		}
+	})
+}
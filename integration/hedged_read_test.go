@@ -0,0 +1,183 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+func TestHedgedRead(t *testing.T) {
+	query := []byte(`{"results":[{"columns":["id"],"types":["integer"],"values":[[1]]}]}`)
+	var slowHits, fastHits int64
+	slow := &MockServer{Port: "14001", Query: query, Delay: time.Second,
+		OnRequest: func(*http.Request) { atomic.AddInt64(&slowHits, 1) }}
+	fast := &MockServer{Port: "14003", Query: query,
+		OnRequest: func(*http.Request) { atomic.AddInt64(&fastHits, 1) }}
+	for _, m := range []*MockServer{slow, fast} {
+		m.Start()
+		defer m.Stop()
+		if err := m.WaitForReady(); err != nil {
+			t.Fatalf("mock server failed to start: %v", err)
+		}
+	}
+
+	cfg := gorqlite.NewConfig()
+	if err := cfg.ParseDSN("http://localhost:14001?disableClusterDiscovery=true,http://localhost:14003"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := gorqlite.NewConnection(context.Background(), *cfg,
+		gorqlite.WithHedgePolicy(gorqlite.HedgePolicy{Delay: 50 * time.Millisecond}))
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+	atomic.StoreInt64(&slowHits, 0)
+	atomic.StoreInt64(&fastHits, 0)
+
+	start := time.Now()
+	qr, err := conn.QueryOneContext(context.Background(), "SELECT 1", gorqlite.CallConsistency(gorqlite.ConsistencyLevelNone))
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if qr.NumRows() != 1 {
+		t.Errorf("expected 1 row, got %d", qr.NumRows())
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("the hedged read should not wait for the slow peer, took %v", elapsed)
+	}
+	if gotSlow, gotFast := atomic.LoadInt64(&slowHits), atomic.LoadInt64(&fastHits); gotSlow != 1 || gotFast != 1 {
+		t.Errorf("expected both peers to be called once, got %d and %d", gotSlow, gotFast)
+	}
+
+	// reads with a consistency level are never hedged
+	atomic.StoreInt64(&fastHits, 0)
+	if _, err := conn.QueryOneContext(context.Background(), "SELECT 1", gorqlite.CallConsistency(gorqlite.ConsistencyLevelWeak)); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if atomic.LoadInt64(&fastHits) != 0 {
+		t.Errorf("the weak read should not be hedged")
+	}
+}
+
+func TestHedgedReadPolicies(t *testing.T) {
+	query := []byte(`{"results":[{"columns":["id"],"types":["integer"],"values":[[1]]}]}`)
+	var hits [3]int64
+	var servers []*MockServer
+	for i, port := range []string{"14001", "14003", "14005"} {
+		i := i
+		m := &MockServer{Port: port, Query: query,
+			OnRequest: func(*http.Request) { atomic.AddInt64(&hits[i], 1) }}
+		m.Start()
+		defer m.Stop()
+		if err := m.WaitForReady(); err != nil {
+			t.Fatalf("mock server failed to start: %v", err)
+		}
+		servers = append(servers, m)
+	}
+	open := func(delay time.Duration) *gorqlite.Connection {
+		cfg := gorqlite.NewConfig()
+		if err := cfg.ParseDSN("http://localhost:14001?disableClusterDiscovery=true,http://localhost:14003,http://localhost:14005"); err != nil {
+			t.Fatal(err)
+		}
+		conn, err := gorqlite.NewConnection(context.Background(), *cfg,
+			gorqlite.WithHedgePolicy(gorqlite.HedgePolicy{Delay: delay}))
+		if err != nil {
+			t.Fatalf("failed to open connection: %v", err)
+		}
+		for i := range hits {
+			atomic.StoreInt64(&hits[i], 0)
+		}
+		return conn
+	}
+	reset := func() {
+		for _, m := range servers {
+			// the handlers of the previous subtest may still be running
+			m.Update(func(m *MockServer) {
+				m.Delay = 0
+				m.Respond = nil
+			})
+		}
+	}
+	read := func(conn *gorqlite.Connection) error {
+		_, err := conn.QueryOneContext(context.Background(), "SELECT 1", gorqlite.CallConsistency(gorqlite.ConsistencyLevelNone))
+		return err
+	}
+
+	t.Run("next peer called when a peer fails", func(t *testing.T) {
+		defer reset()
+		servers[0].Update(func(m *MockServer) { m.Delay = time.Second })
+		servers[1].Update(func(m *MockServer) {
+			m.Respond = func(w http.ResponseWriter, req *http.Request) bool {
+				w.WriteHeader(http.StatusInternalServerError)
+				return true
+			}
+		})
+		conn := open(200 * time.Millisecond)
+		defer conn.Close()
+
+		start := time.Now()
+		if err := read(conn); err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		// the third peer is called as soon as the second fails, while the
+		// first is still running, rather than after another hedge delay
+		if elapsed := time.Since(start); elapsed > 350*time.Millisecond {
+			t.Errorf("the third peer should be called when the second fails, took %v", elapsed)
+		}
+		if second, third := atomic.LoadInt64(&hits[1]), atomic.LoadInt64(&hits[2]); second != 1 || third != 1 {
+			t.Errorf("expected the second and third peers to be called once, got %d and %d", second, third)
+		}
+	})
+
+	t.Run("unauthorized fails fast", func(t *testing.T) {
+		defer reset()
+		servers[0].Update(func(m *MockServer) {
+			m.Respond = func(w http.ResponseWriter, req *http.Request) bool {
+				w.WriteHeader(http.StatusUnauthorized)
+				return true
+			}
+		})
+		conn := open(time.Second)
+		defer conn.Close()
+
+		if err := read(conn); !errors.Is(err, gorqlite.ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+		if second, third := atomic.LoadInt64(&hits[1]), atomic.LoadInt64(&hits[2]); second != 0 || third != 0 {
+			t.Errorf("expected the other peers not to be called, got %d and %d", second, third)
+		}
+	})
+
+	t.Run("busy peer tried again after Retry-After", func(t *testing.T) {
+		defer reset()
+		var busy int64
+		servers[0].Update(func(m *MockServer) {
+			m.Respond = func(w http.ResponseWriter, req *http.Request) bool {
+				if atomic.AddInt64(&busy, 1) > 1 {
+					return false
+				}
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte("leader not found"))
+				return true
+			}
+		})
+		conn := open(time.Second)
+		defer conn.Close()
+
+		if err := read(conn); err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		if first := atomic.LoadInt64(&hits[0]); first != 2 {
+			t.Errorf("expected the busy peer to be called twice, got %d", first)
+		}
+		if second := atomic.LoadInt64(&hits[1]); second != 0 {
+			t.Errorf("expected the busy peer to be tried again before hedging, got %d calls to the next peer", second)
+		}
+	})
+}
@@ -0,0 +1,457 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+	"github.com/eluv-io/gorqlite/jobqueue"
+)
+
+func TestJobQueue(t *testing.T) {
+	var mu sync.Mutex
+	var writes []string
+	ready := []string{`[1,"ok",0,0,1,null]`, `[2,"fail",0,0,1,null]`, `[4,"again",0,0,2,"boom"]`}
+	m := &MockServer{
+		Port: "14001",
+		Respond: func(w http.ResponseWriter, req *http.Request) bool {
+			body, _ := io.ReadAll(req.Body)
+			mu.Lock()
+			defer mu.Unlock()
+			switch req.URL.Path {
+			case "/db/request":
+				if !strings.HasPrefix(string(body), `[[true,"UPDATE gorqlite_jobs SET claim`) {
+					t.Errorf("unexpected claim: %s", body)
+				}
+				values := ""
+				if len(ready) > 0 {
+					values = `,"values":[` + ready[0] + `]`
+					ready = ready[1:]
+				}
+				w.Write([]byte(`{"results":[{"columns":["id","payload","priority","run_at","attempts","last_error"],"types":["integer","text","integer","integer","integer","text"]` + values + `}]}`))
+			case "/db/execute":
+				writes = append(writes, string(body))
+				switch {
+				case strings.Contains(string(body), "CREATE TABLE"):
+					w.Write([]byte(`{"results":[{},{},{}]}`))
+				case strings.Contains(string(body), "INSERT INTO gorqlite_jobs "):
+					w.Write([]byte(`{"results":[{"last_insert_id":3,"rows_affected":1}]}`))
+				default:
+					w.Write([]byte(`{"results":[{"rows_affected":1}]}`))
+				}
+			default:
+				return false
+			}
+			return true
+		},
+	}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+
+	q, err := jobqueue.New(conn, "emails", jobqueue.WithPoll(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	id, err := q.Enqueue(context.Background(), "hello", jobqueue.WithPriority(3))
+	if err != nil || id != 3 {
+		t.Fatalf("expected job 3 to be enqueued, got %d, %v", id, err)
+	}
+	if len(writes) != 2 || !strings.Contains(writes[0], "CREATE TABLE IF NOT EXISTS gorqlite_jobs_dead") ||
+		!strings.Contains(writes[1], `"emails","hello",3,`) {
+		t.Errorf("unexpected writes: %q", writes)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var handled []string
+	err = q.Work(ctx, 1, func(ctx context.Context, job *jobqueue.Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, job.Payload)
+		if len(handled) == 2 {
+			cancel()
+		}
+		if job.Payload == "fail" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Work to stop with the context, got %v", err)
+	}
+	if len(handled) != 2 {
+		t.Fatalf("expected 2 jobs to be handled, got %q", handled)
+	}
+
+	var acked, released bool
+	for _, w := range writes[2:] {
+		acked = acked || strings.Contains(w, "DELETE FROM gorqlite_jobs WHERE id = ? AND claim = ?\",1,")
+		released = released || strings.Contains(w, "SET attempts = attempts - 1") && strings.Contains(w, `",2,"`)
+	}
+	if !acked || !released {
+		t.Errorf("expected job 1 to be acknowledged and job 2, failing while stopping, released: %q", writes[2:])
+	}
+
+	job, err := q.Claim(context.Background())
+	if err != nil || job.ID != 4 || job.Attempts != 2 || job.LastError != "boom" {
+		t.Fatalf("unexpected claimed job: %+v, %v", job, err)
+	}
+	if err := job.Nack(context.Background(), errors.New("boom again")); err != nil {
+		t.Errorf("Nack failed: %v", err)
+	}
+	if last := writes[len(writes)-1]; !strings.Contains(last, "SET run_at = ?, last_error = ?") || !strings.Contains(last, `"boom again",4,`) {
+		t.Errorf("expected job 4 to be retried later, got %s", last)
+	}
+
+	if _, err := q.Claim(context.Background()); !errors.Is(err, jobqueue.ErrEmpty) {
+		t.Errorf("expected ErrEmpty, got %v", err)
+	}
+}
+
+// jobsBackend answers the calls of the queues, keeping their tables in
+// memory.
+type jobsBackend struct {
+	t *testing.T
+
+	mu     sync.Mutex // guards the fields below
+	jobs   []*jobRow
+	dead   []*jobRow
+	nextID int64
+}
+
+type jobRow struct {
+	id          int64
+	queue       string
+	payload     string
+	priority    int64
+	runAt       int64
+	attempts    int64
+	lastError   interface{} // nil or a string
+	claim       interface{} // nil or a string
+	lockedUntil interface{} // nil or a time in milliseconds
+	failedAt    int64
+}
+
+// find returns the job of the given ID and claim.
+func (b *jobsBackend) find(id, claim interface{}) (int, *jobRow) {
+	for i, j := range b.jobs {
+		if float64(j.id) == id.(float64) && j.claim != nil && j.claim == claim {
+			return i, j
+		}
+	}
+	return -1, nil
+}
+
+func (b *jobsBackend) respond(w http.ResponseWriter, req *http.Request) bool {
+	// the server version is looked up before the first claim
+	if !strings.HasPrefix(req.URL.Path, "/db/") {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	body, _ := io.ReadAll(req.Body)
+	var stmts [][]interface{}
+	if err := json.Unmarshal(body, &stmts); err != nil {
+		b.t.Errorf("unexpected request %s: %v", body, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return true
+	}
+
+	switch req.URL.Path {
+	case "/db/request":
+		values, _ := json.Marshal(b.claim(stmts[0][2:]))
+		w.Write([]byte(`{"results":[{"columns":["id","payload","priority","run_at","attempts","last_error"],"types":["integer","text","integer","integer","integer","text"],"values":` +
+			string(values) + `}]}`))
+	case "/db/query":
+		values, _ := json.Marshal(b.deadLetters(stmts[0][1:]))
+		w.Write([]byte(`{"results":[{"columns":["id","payload","priority","attempts","last_error","failed_at"],"types":["integer","text","integer","integer","text","integer"],"values":` +
+			string(values) + `}]}`))
+	case "/db/execute":
+		if _, ok := req.URL.Query()["transaction"]; !ok {
+			b.t.Errorf("expected a transaction, got %s", req.URL)
+		}
+		var results []string
+		for _, stmt := range stmts {
+			id, n := b.execute(stmt)
+			results = append(results, fmt.Sprintf(`{"last_insert_id":%d,"rows_affected":%d}`, id, n))
+		}
+		w.Write([]byte(`{"results":[` + strings.Join(results, ",") + `]}`))
+	default:
+		return false
+	}
+	return true
+}
+
+// claim claims the ready job of highest priority, given the claim, its
+// visibility timeout, the queue and now.
+func (b *jobsBackend) claim(args []interface{}) [][]interface{} {
+	queue, now := args[2].(string), int64(args[3].(float64))
+	var ready *jobRow
+	for _, j := range b.jobs {
+		if j.queue != queue || j.runAt > now || j.lockedUntil != nil && int64(j.lockedUntil.(float64)) >= now {
+			continue
+		}
+		if ready == nil || j.priority > ready.priority ||
+			j.priority == ready.priority && (j.runAt < ready.runAt || j.runAt == ready.runAt && j.id < ready.id) {
+			ready = j
+		}
+	}
+	if ready == nil {
+		return [][]interface{}{}
+	}
+	ready.claim, ready.lockedUntil = args[0], args[1]
+	ready.attempts++
+	return [][]interface{}{{ready.id, ready.payload, ready.priority, ready.runAt, ready.attempts, ready.lastError}}
+}
+
+// deadLetters returns the dead letters of a queue, latest first.
+func (b *jobsBackend) deadLetters(args []interface{}) [][]interface{} {
+	values := [][]interface{}{}
+	for i := len(b.dead) - 1; i >= 0; i-- {
+		if j := b.dead[i]; j.queue == args[0].(string) {
+			values = append(values, []interface{}{j.id, j.payload, j.priority, j.attempts, j.lastError, j.failedAt})
+		}
+	}
+	return values
+}
+
+// execute applies a write, returning the last insert ID and the number of
+// rows affected.
+func (b *jobsBackend) execute(stmt []interface{}) (int64, int) {
+	query := stmt[0].(string)
+	args := stmt[1:]
+	switch {
+	case strings.HasPrefix(query, "CREATE "):
+		return 0, 0
+	case strings.HasPrefix(query, "INSERT INTO gorqlite_jobs "):
+		b.nextID++
+		b.jobs = append(b.jobs, &jobRow{id: b.nextID, queue: args[0].(string), payload: args[1].(string),
+			priority: int64(args[2].(float64)), runAt: int64(args[3].(float64))})
+		return b.nextID, 1
+	case strings.HasPrefix(query, "INSERT INTO gorqlite_jobs_dead "):
+		_, j := b.find(args[2], args[3])
+		if j == nil {
+			return 0, 0
+		}
+		dead := *j
+		dead.lastError, dead.failedAt = args[0], int64(args[1].(float64))
+		b.dead = append(b.dead, &dead)
+		return j.id, 1
+	case strings.HasPrefix(query, "DELETE FROM gorqlite_jobs WHERE id = ? AND claim = ?"):
+		i, _ := b.find(args[0], args[1])
+		if i < 0 {
+			return 0, 0
+		}
+		b.jobs = append(b.jobs[:i], b.jobs[i+1:]...)
+		return 0, 1
+	case strings.HasPrefix(query, "UPDATE gorqlite_jobs SET run_at = ?, last_error = ?, claim = NULL, locked_until = NULL WHERE id = ? AND claim = ?"):
+		_, j := b.find(args[2], args[3])
+		if j == nil {
+			return 0, 0
+		}
+		j.runAt, j.lastError, j.claim, j.lockedUntil = int64(args[0].(float64)), args[1], nil, nil
+		return 0, 1
+	case strings.HasPrefix(query, "UPDATE gorqlite_jobs SET attempts = attempts - 1, claim = NULL, locked_until = NULL WHERE id = ? AND claim = ?"):
+		_, j := b.find(args[0], args[1])
+		if j == nil {
+			return 0, 0
+		}
+		j.attempts--
+		j.claim, j.lockedUntil = nil, nil
+		return 0, 1
+	case strings.HasPrefix(query, "UPDATE gorqlite_jobs SET locked_until = ? WHERE id = ? AND claim = ?"):
+		_, j := b.find(args[1], args[2])
+		if j == nil {
+			return 0, 0
+		}
+		j.lockedUntil = args[0]
+		return 0, 1
+	}
+	b.t.Errorf("unexpected write %s", query)
+	return 0, 0
+}
+
+// job returns the row of the job.
+func (b *jobsBackend) job(id int64) jobRow {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, j := range b.jobs {
+		if j.id == id {
+			return *j
+		}
+	}
+	return jobRow{}
+}
+
+func TestJobQueueBehavior(t *testing.T) {
+	b := &jobsBackend{t: t}
+	m := &MockServer{Port: "14001", Respond: b.respond}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	t.Run("visibility timeout", func(t *testing.T) {
+		q, err := jobqueue.New(conn, "visibility", jobqueue.WithVisibilityTimeout(100*time.Millisecond))
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		id, err := q.Enqueue(ctx, "job")
+		if err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+		first, err := q.Claim(ctx)
+		if err != nil || first.ID != id || first.Attempts != 1 {
+			t.Fatalf("expected job %d at its first attempt, got %+v, %v", id, first, err)
+		}
+		// the claimed job is hidden until its visibility timeout
+		if _, err := q.Claim(ctx); !errors.Is(err, jobqueue.ErrEmpty) {
+			t.Errorf("expected the claimed job to be hidden, got %v", err)
+		}
+		time.Sleep(150 * time.Millisecond)
+
+		second, err := q.Claim(ctx)
+		if err != nil || second.ID != id || second.Attempts != 2 {
+			t.Fatalf("expected job %d to be claimed again, got %+v, %v", id, second, err)
+		}
+		// the first worker lost its claim
+		if err := first.Ack(ctx); !errors.Is(err, jobqueue.ErrClaimLost) {
+			t.Errorf("expected ErrClaimLost, got %v", err)
+		}
+		if err := second.Extend(ctx); err != nil {
+			t.Fatalf("Extend failed: %v", err)
+		}
+		time.Sleep(60 * time.Millisecond)
+		if _, err := q.Claim(ctx); !errors.Is(err, jobqueue.ErrEmpty) {
+			t.Errorf("expected the extended job to stay hidden, got %v", err)
+		}
+		if err := second.Ack(ctx); err != nil {
+			t.Errorf("Ack failed: %v", err)
+		}
+		time.Sleep(150 * time.Millisecond)
+		if _, err := q.Claim(ctx); !errors.Is(err, jobqueue.ErrEmpty) {
+			t.Errorf("expected the acknowledged job to be gone, got %v", err)
+		}
+	})
+
+	t.Run("nack with backoff", func(t *testing.T) {
+		var attempts []int
+		q, err := jobqueue.New(conn, "backoff", jobqueue.WithBackoff(func(attempt int) time.Duration {
+			attempts = append(attempts, attempt)
+			return time.Duration(attempt) * 100 * time.Millisecond
+		}))
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		id, err := q.Enqueue(ctx, "job")
+		if err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+
+		for attempt := 1; attempt <= 2; attempt++ {
+			job, err := q.Claim(ctx)
+			if err != nil || job.ID != id || job.Attempts != attempt {
+				t.Fatalf("expected job %d at attempt %d, got %+v, %v", id, attempt, job, err)
+			}
+			before := time.Now()
+			if err := job.Nack(ctx, fmt.Errorf("boom %d", attempt)); err != nil {
+				t.Fatalf("Nack failed: %v", err)
+			}
+			backoff := time.Duration(attempt) * 100 * time.Millisecond
+			if runAt := time.UnixMilli(b.job(id).runAt); runAt.Before(before.Add(backoff).Truncate(time.Millisecond)) || runAt.After(time.Now().Add(backoff)) {
+				t.Errorf("expected the job to be retried in %s, got %s", backoff, runAt.Sub(before))
+			}
+			// not before its backoff
+			if _, err := q.Claim(ctx); !errors.Is(err, jobqueue.ErrEmpty) {
+				t.Errorf("expected the job to wait for its backoff, got %v", err)
+			}
+			time.Sleep(backoff + 50*time.Millisecond)
+		}
+
+		job, err := q.Claim(ctx)
+		if err != nil || job.Attempts != 3 || job.LastError != "boom 2" {
+			t.Fatalf("expected the third attempt after boom 2, got %+v, %v", job, err)
+		}
+		if fmt.Sprint(attempts) != "[1 2]" {
+			t.Errorf("expected the backoff of attempts 1 and 2, got %v", attempts)
+		}
+		if err := job.Ack(ctx); err != nil {
+			t.Errorf("Ack failed: %v", err)
+		}
+	})
+
+	t.Run("bury after max attempts", func(t *testing.T) {
+		q, err := jobqueue.New(conn, "bury", jobqueue.WithMaxAttempts(2), jobqueue.WithVisibilityTimeout(50*time.Millisecond),
+			jobqueue.WithBackoff(func(int) time.Duration { return 0 }))
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		failing, err := q.Enqueue(ctx, "failing")
+		if err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+		for attempt := 1; attempt <= 2; attempt++ {
+			job, err := q.Claim(ctx)
+			if err != nil || job.ID != failing || job.Attempts != attempt {
+				t.Fatalf("expected job %d at attempt %d, got %+v, %v", failing, attempt, job, err)
+			}
+			if err := job.Nack(ctx, fmt.Errorf("boom %d", attempt)); err != nil {
+				t.Fatalf("Nack failed: %v", err)
+			}
+		}
+		// the last attempt failed
+		if _, err := q.Claim(ctx); !errors.Is(err, jobqueue.ErrEmpty) {
+			t.Errorf("expected the failed job to be buried, got %v", err)
+		}
+
+		// the workers of this one die
+		abandoned, err := q.Enqueue(ctx, "abandoned")
+		if err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+		for attempt := 1; attempt <= 2; attempt++ {
+			if job, err := q.Claim(ctx); err != nil || job.ID != abandoned {
+				t.Fatalf("expected job %d, got %+v, %v", abandoned, job, err)
+			}
+			time.Sleep(80 * time.Millisecond)
+		}
+		if _, err := q.Claim(ctx); !errors.Is(err, jobqueue.ErrEmpty) {
+			t.Errorf("expected the abandoned job to be buried, got %v", err)
+		}
+
+		letters, err := q.DeadLetters(ctx, 10)
+		if err != nil {
+			t.Fatalf("DeadLetters failed: %v", err)
+		}
+		if len(letters) != 2 ||
+			letters[0].ID != abandoned || letters[0].Attempts != 3 || letters[0].LastError != "too many attempts: " ||
+			letters[1].ID != failing || letters[1].Attempts != 2 || letters[1].LastError != "boom 2" {
+			t.Errorf("unexpected dead letters: %+v", letters)
+		}
+	})
+}
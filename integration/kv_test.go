@@ -0,0 +1,422 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+	"github.com/eluv-io/gorqlite/kv"
+)
+
+func TestKV(t *testing.T) {
+	var writes, queries []string
+	m := &MockServer{
+		Port: "14001",
+		Respond: func(w http.ResponseWriter, req *http.Request) bool {
+			body, _ := io.ReadAll(req.Body)
+			switch req.URL.Path {
+			case "/db/query":
+				queries = append(queries, string(body))
+				values := ""
+				switch {
+				case strings.Contains(string(body), `"config/timeout"`):
+					values = `,"values":[["config/timeout","30",2,null]]`
+				case strings.Contains(string(body), "ORDER BY key"):
+					values = `,"values":[["config/a","{\"on\":true}",1,4102444800000],["config/b","\"x\"",5,null]]`
+				}
+				w.Write([]byte(`{"results":[{"columns":["key","value","version","expires_at"],"types":["text","text","integer","integer"]` + values + `}]}`))
+			case "/db/execute":
+				if _, ok := req.URL.Query()["transaction"]; !ok {
+					t.Errorf("expected a transaction, got %s", req.URL)
+				}
+				writes = append(writes, string(body))
+				switch {
+				case strings.Contains(string(body), "CREATE TABLE"):
+					w.Write([]byte(`{"results":[{}]}`))
+				case strings.Contains(string(body), "WHERE key = ? AND version = ?"):
+					w.Write([]byte(`{"results":[{"rows_affected":0}]}`))
+				default:
+					w.Write([]byte(`{"results":[{"rows_affected":1},{"rows_affected":1}]}`))
+				}
+			default:
+				return false
+			}
+			return true
+		},
+	}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	store, err := kv.New(conn)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if err := store.Put(ctx, "config/a", map[string]bool{"on": true}, kv.WithTTL(time.Hour)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if len(writes) != 2 || !strings.Contains(writes[0], "CREATE TABLE IF NOT EXISTS gorqlite_kv") ||
+		!strings.Contains(writes[1], `"config/a","{\"on\":true}",`) {
+		t.Errorf("unexpected writes: %q", writes)
+	}
+
+	var timeout int
+	version, err := store.Get(ctx, "config/timeout", &timeout)
+	if err != nil || version != 2 || timeout != 30 {
+		t.Errorf("expected 30 at version 2, got %d at version %d, %v", timeout, version, err)
+	}
+	if _, err := store.Get(ctx, "missing", nil); !errors.Is(err, kv.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	entries, err := store.List(ctx, "config/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if !strings.Contains(queries[len(queries)-1], `"config/",`) || !strings.Contains(queries[len(queries)-1], `"config0"]`) {
+		t.Errorf("expected the keys between config/ and config0, got %s", queries[len(queries)-1])
+	}
+	var a struct{ On bool }
+	if len(entries) != 2 || entries[0].Decode(&a) != nil || !a.On || entries[0].Expires.IsZero() ||
+		entries[1].Version != 5 || !entries[1].Expires.IsZero() {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+
+	if err := store.CAS(ctx, "config/timeout", 1, 60); !errors.Is(err, kv.ErrConflict) || !errors.Is(err, gorqlite.ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+
+	n := len(writes)
+	b := store.Batch().Put("config/a", 1).Delete("config/b")
+	if err := store.Apply(ctx, b); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if len(writes) != n+1 || !strings.Contains(writes[n], "INSERT INTO") || !strings.Contains(writes[n], "DELETE FROM") {
+		t.Errorf("expected the batch in a single request, got %q", writes[n:])
+	}
+	if err := store.Apply(ctx, store.Batch().Put("bad", func() {})); err == nil {
+		t.Error("expected a value that can't be encoded to fail the batch")
+	}
+}
+
+// kvBackend answers the calls of a store, keeping its table in memory.
+type kvBackend struct {
+	t *testing.T
+
+	mu      sync.Mutex        // guards the fields below
+	rows    map[string]*kvRow // key -> row
+	failKey string            // the writes of this key fail
+	writes  int               // the write requests
+}
+
+type kvRow struct {
+	value   string
+	version int64
+	expires interface{} // nil or the expiry in milliseconds
+}
+
+// live tells if the row didn't expire at now, in milliseconds.
+func (r *kvRow) live(now float64) bool {
+	return r.expires == nil || r.expires.(float64) > now
+}
+
+func (b *kvBackend) respond(w http.ResponseWriter, req *http.Request) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	body, _ := io.ReadAll(req.Body)
+	var stmts [][]interface{}
+	if err := json.Unmarshal(body, &stmts); err != nil {
+		b.t.Errorf("unexpected request %s: %v", body, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return true
+	}
+
+	switch req.URL.Path {
+	case "/db/query":
+		values, _ := json.Marshal(b.query(stmts[0]))
+		w.Write([]byte(`{"results":[{"columns":["key","value","version","expires_at"],"types":["text","text","integer","integer"],"values":` +
+			string(values) + `}]}`))
+	case "/db/execute":
+		b.writes++
+		if _, ok := req.URL.Query()["transaction"]; !ok {
+			b.t.Errorf("expected a transaction, got %s", req.URL)
+		}
+		// the statements of a transaction are rolled back when one fails
+		saved := make(map[string]*kvRow, len(b.rows))
+		for k, r := range b.rows {
+			saved[k] = &kvRow{value: r.value, version: r.version, expires: r.expires}
+		}
+		var results []string
+		for _, stmt := range stmts {
+			n, err := b.execute(stmt)
+			if err != nil {
+				b.rows = saved
+				results = append(results, fmt.Sprintf(`{"error":%q}`, err.Error()))
+				break
+			}
+			results = append(results, fmt.Sprintf(`{"rows_affected":%d}`, n))
+		}
+		w.Write([]byte(`{"results":[` + strings.Join(results, ",") + `]}`))
+	default:
+		return false
+	}
+	return true
+}
+
+// query returns the live rows of a SELECT, sorted by key.
+func (b *kvBackend) query(stmt []interface{}) [][]interface{} {
+	query := stmt[0].(string)
+	args := stmt[1:]
+	now := args[1].(float64)
+	var keys []string
+	for k, r := range b.rows {
+		switch {
+		case !r.live(now):
+		case strings.Contains(query, "WHERE key = ?"):
+			if k == args[0].(string) {
+				keys = append(keys, k)
+			}
+		case strings.Contains(query, "WHERE key >= ?"):
+			if k >= args[0].(string) && (len(args) < 3 || k < args[2].(string)) {
+				keys = append(keys, k)
+			}
+		default:
+			b.t.Errorf("unexpected query %s", query)
+		}
+	}
+	sort.Strings(keys)
+	values := [][]interface{}{}
+	for _, k := range keys {
+		r := b.rows[k]
+		values = append(values, []interface{}{k, r.value, r.version, r.expires})
+	}
+	return values
+}
+
+// execute applies a write, returning the number of rows affected.
+func (b *kvBackend) execute(stmt []interface{}) (int, error) {
+	query := stmt[0].(string)
+	args := stmt[1:]
+	switch {
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS gorqlite_kv "):
+		return 0, nil
+	case strings.HasPrefix(query, "INSERT INTO gorqlite_kv "):
+		key := args[0].(string)
+		if key == b.failKey {
+			return 0, errors.New("CHECK constraint failed")
+		}
+		r, ok := b.rows[key]
+		if !ok {
+			b.rows[key] = &kvRow{value: args[1].(string), version: 1, expires: args[2]}
+			return 1, nil
+		}
+		// the insert of a CAS only takes over the expired keys
+		if strings.HasSuffix(query, "WHERE expires_at IS NOT NULL AND expires_at <= ?") && r.live(args[3].(float64)) {
+			return 0, nil
+		}
+		r.value, r.expires = args[1].(string), args[2]
+		r.version++
+		return 1, nil
+	case strings.HasPrefix(query, "UPDATE gorqlite_kv SET value = ?, version = version + 1, expires_at = ? WHERE key = ? AND version = ? AND "):
+		r, ok := b.rows[args[2].(string)]
+		if !ok || float64(r.version) != args[3].(float64) || !r.live(args[4].(float64)) {
+			return 0, nil
+		}
+		r.value, r.expires = args[0].(string), args[1]
+		r.version++
+		return 1, nil
+	case strings.HasPrefix(query, "DELETE FROM gorqlite_kv WHERE key = ?"):
+		if _, ok := b.rows[args[0].(string)]; !ok {
+			return 0, nil
+		}
+		delete(b.rows, args[0].(string))
+		return 1, nil
+	case strings.HasPrefix(query, "DELETE FROM gorqlite_kv WHERE expires_at <= ?"):
+		n := 0
+		for k, r := range b.rows {
+			if !r.live(args[0].(float64)) {
+				delete(b.rows, k)
+				n++
+			}
+		}
+		return n, nil
+	}
+	b.t.Errorf("unexpected write %s", query)
+	return 0, nil
+}
+
+func TestKVBehavior(t *testing.T) {
+	b := &kvBackend{t: t, rows: make(map[string]*kvRow)}
+	m := &MockServer{Port: "14001", Respond: b.respond}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	store, err := kv.New(conn)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	get := func(key string) (string, int64, error) {
+		var v string
+		version, err := store.Get(ctx, key, &v)
+		return v, version, err
+	}
+
+	t.Run("versions", func(t *testing.T) {
+		for i := 1; i <= 3; i++ {
+			if err := store.Put(ctx, "versions", fmt.Sprint(i)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			if v, version, err := get("versions"); err != nil || v != fmt.Sprint(i) || version != int64(i) {
+				t.Errorf("expected %d at version %d, got %s at version %d, %v", i, i, v, version, err)
+			}
+		}
+		if err := store.Delete(ctx, "versions"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		// a deleted key starts over
+		if err := store.Put(ctx, "versions", "again"); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if _, version, err := get("versions"); err != nil || version != 1 {
+			t.Errorf("expected version 1, got %d, %v", version, err)
+		}
+	})
+
+	t.Run("cas", func(t *testing.T) {
+		// version 0: the key must not exist
+		if err := store.CAS(ctx, "cas", 0, "a"); err != nil {
+			t.Fatalf("CAS of a new key failed: %v", err)
+		}
+		if err := store.CAS(ctx, "cas", 0, "b"); !errors.Is(err, kv.ErrConflict) {
+			t.Errorf("expected ErrConflict creating an existing key, got %v", err)
+		}
+
+		_, version, err := get("cas")
+		if err != nil || version != 1 {
+			t.Fatalf("expected version 1, got %d, %v", version, err)
+		}
+		if err := store.CAS(ctx, "cas", version, "c"); err != nil {
+			t.Fatalf("CAS at the current version failed: %v", err)
+		}
+		// the version read before the CAS is stale
+		if err := store.CAS(ctx, "cas", version, "d"); !errors.Is(err, kv.ErrConflict) {
+			t.Errorf("expected ErrConflict at a stale version, got %v", err)
+		}
+		if v, version, err := get("cas"); err != nil || v != "c" || version != 2 {
+			t.Errorf("expected c at version 2, got %s at version %d, %v", v, version, err)
+		}
+		if err := store.CAS(ctx, "missing", 1, "e"); !errors.Is(err, kv.ErrConflict) {
+			t.Errorf("expected ErrConflict updating a missing key, got %v", err)
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		if err := store.Put(ctx, "ttl/short", "a", kv.WithTTL(100*time.Millisecond)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := store.Put(ctx, "ttl/long", "b", kv.WithTTL(time.Hour)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if _, _, err := get("ttl/short"); err != nil {
+			t.Fatalf("expected the key before it expires, got %v", err)
+		}
+		time.Sleep(150 * time.Millisecond)
+
+		if _, _, err := get("ttl/short"); !errors.Is(err, kv.ErrNotFound) {
+			t.Errorf("expected ErrNotFound once expired, got %v", err)
+		}
+		entries, err := store.List(ctx, "ttl/")
+		if err != nil || len(entries) != 1 || entries[0].Key != "ttl/long" {
+			t.Errorf("expected only ttl/long to be listed, got %+v, %v", entries, err)
+		}
+		// an expired key can't be updated, only created again
+		if err := store.CAS(ctx, "ttl/short", 1, "c"); !errors.Is(err, kv.ErrConflict) {
+			t.Errorf("expected ErrConflict updating an expired key, got %v", err)
+		}
+		if err := store.CAS(ctx, "ttl/short", 0, "c", kv.WithTTL(100*time.Millisecond)); err != nil {
+			t.Fatalf("CAS of an expired key failed: %v", err)
+		}
+		if v, _, err := get("ttl/short"); err != nil || v != "c" {
+			t.Errorf("expected c, got %s, %v", v, err)
+		}
+
+		time.Sleep(150 * time.Millisecond)
+		if n, err := store.Purge(ctx); err != nil || n != 1 {
+			t.Errorf("expected 1 key purged, got %d, %v", n, err)
+		}
+		b.mu.Lock()
+		_, short := b.rows["ttl/short"]
+		_, long := b.rows["ttl/long"]
+		b.mu.Unlock()
+		if short || !long {
+			t.Errorf("expected only ttl/short to be purged")
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		if err := store.Put(ctx, "batch/a", "a"); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		b.mu.Lock()
+		b.failKey = "batch/bad"
+		writes := b.writes
+		b.mu.Unlock()
+
+		// a failed write rolls the whole batch back
+		err := store.Apply(ctx, store.Batch().Put("batch/a", "b").Delete("batch/a").Put("batch/c", "c").Put("batch/bad", "x"))
+		if err == nil || !strings.Contains(err.Error(), "CHECK constraint failed") {
+			t.Errorf("expected the batch to fail, got %v", err)
+		}
+		if v, version, err := get("batch/a"); err != nil || v != "a" || version != 1 {
+			t.Errorf("expected batch/a untouched, got %s at version %d, %v", v, version, err)
+		}
+		if _, _, err := get("batch/c"); !errors.Is(err, kv.ErrNotFound) {
+			t.Errorf("expected batch/c not to be written, got %v", err)
+		}
+
+		if err := store.Apply(ctx, store.Batch().Put("batch/a", "b").Put("batch/c", "c").Delete("batch/missing")); err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+		if v, version, err := get("batch/a"); err != nil || v != "b" || version != 2 {
+			t.Errorf("expected b at version 2, got %s at version %d, %v", v, version, err)
+		}
+		if v, _, err := get("batch/c"); err != nil || v != "c" {
+			t.Errorf("expected c, got %s, %v", v, err)
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.writes != writes+2 {
+			t.Errorf("expected each batch in a single request, got %d requests", b.writes-writes)
+		}
+	})
+}
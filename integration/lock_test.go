@@ -0,0 +1,299 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+	"github.com/eluv-io/gorqlite/lock"
+)
+
+func TestLock(t *testing.T) {
+	var mu sync.Mutex // guards requests and held
+	var requests []string
+	held := false
+	m := &MockServer{
+		Port: "14001",
+		Respond: func(w http.ResponseWriter, req *http.Request) bool {
+			body, _ := io.ReadAll(req.Body)
+			mu.Lock()
+			defer mu.Unlock()
+			switch req.URL.Path {
+			case "/db/request":
+				requests = append(requests, string(body))
+				if req.URL.Query().Get("level") != "strong" {
+					t.Errorf("expected a strong request, got %s", req.URL)
+				}
+				create := ""
+				if strings.Contains(string(body), "CREATE TABLE") {
+					create = `{},`
+				}
+				if held {
+					w.Write([]byte(`{"results":[` + create + `{"rows_affected":0},
+						{"columns":["owner","token","expires_at"],"types":["text","integer","integer"],"values":[["other",7,4102444800000]]}]}`))
+					return true
+				}
+				held = true
+				w.Write([]byte(`{"results":[` + create + `{"rows_affected":1},
+					{"columns":["owner","token","expires_at"],"types":["text","integer","integer"],"values":[["worker-1",8,4102444800000]]}]}`))
+			case "/db/execute":
+				if !strings.Contains(string(body), `"worker-1",8]`) {
+					t.Errorf("expected the owner and token of the lease, got %s", body)
+				}
+				if held {
+					w.Write([]byte(`{"results":[{"rows_affected":1}]}`))
+					return true
+				}
+				w.Write([]byte(`{"results":[{"rows_affected":0}]}`))
+			default:
+				return false
+			}
+			return true
+		},
+	}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	locks, err := lock.New(conn, lock.WithOwner("worker-1"), lock.WithPoll(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	lease, err := locks.TryAcquire(ctx, "leader", time.Minute)
+	if err != nil {
+		t.Fatalf("TryAcquire failed: %v", err)
+	}
+	if lease.Token != 8 || lease.Name != "leader" || time.Until(lease.Expires) <= 0 {
+		t.Errorf("unexpected lease: %+v", lease)
+	}
+	mu.Lock()
+	if len(requests) != 1 || !strings.Contains(requests[0], "CREATE TABLE IF NOT EXISTS gorqlite_locks") {
+		t.Errorf("expected the table to be created with the first lock, got %q", requests)
+	}
+	mu.Unlock()
+
+	if _, err := locks.TryAcquire(ctx, "leader", time.Minute); !errors.Is(err, lock.ErrLocked) {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+	mu.Lock()
+	if strings.Contains(requests[1], "CREATE TABLE") {
+		t.Errorf("expected the table to be created once, got %s", requests[1])
+	}
+	mu.Unlock()
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	// the deadline may cut a request short: any error will do
+	_, err = locks.Acquire(waitCtx, "leader", time.Minute)
+	mu.Lock()
+	polls := len(requests)
+	mu.Unlock()
+	if err == nil || waitCtx.Err() == nil || polls < 4 {
+		t.Errorf("expected Acquire to poll until the deadline, got %v after %d requests", err, polls)
+	}
+
+	if err := lease.Renew(ctx); err != nil {
+		t.Errorf("Renew failed: %v", err)
+	}
+	mu.Lock()
+	held = false
+	mu.Unlock()
+	if err := lease.Renew(ctx); !errors.Is(err, lock.ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost, got %v", err)
+	}
+	if err := lease.Release(ctx); !errors.Is(err, lock.ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost, got %v", err)
+	}
+}
+
+// locksBackend answers the calls of lock managers, keeping the locks table
+// in memory.
+type locksBackend struct {
+	t *testing.T
+
+	mu    sync.Mutex // guards the locks
+	locks map[string]*lockRow
+}
+
+type lockRow struct {
+	owner   string
+	token   int64
+	expires float64 // in milliseconds
+}
+
+func (b *locksBackend) respond(w http.ResponseWriter, req *http.Request) bool {
+	if !strings.HasPrefix(req.URL.Path, "/db/") {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	body, _ := io.ReadAll(req.Body)
+	var stmts [][]interface{}
+	if err := json.Unmarshal(body, &stmts); err != nil {
+		b.t.Errorf("unexpected request %s: %v", body, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return true
+	}
+	if req.URL.Query().Get("level") != "strong" {
+		b.t.Errorf("expected a strong request, got %s", req.URL)
+	}
+
+	var results []string
+	for _, stmt := range stmts {
+		results = append(results, b.execute(stmt[0].(string), stmt[1:]))
+	}
+	w.Write([]byte(`{"results":[` + strings.Join(results, ",") + `]}`))
+	return true
+}
+
+// execute runs a statement, returning its result.
+func (b *locksBackend) execute(query string, args []interface{}) string {
+	switch {
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS gorqlite_locks "):
+		return `{}`
+	case strings.HasPrefix(query, "INSERT INTO gorqlite_locks "):
+		name, owner, expires, now := args[0].(string), args[1].(string), args[2].(float64), args[3].(float64)
+		l, ok := b.locks[name]
+		switch {
+		case !ok:
+			b.locks[name] = &lockRow{owner: owner, token: 1, expires: expires}
+		case l.expires < now:
+			l.owner, l.expires = owner, expires
+			l.token++
+		default:
+			return `{"rows_affected":0}`
+		}
+		return `{"rows_affected":1}`
+	case strings.HasPrefix(query, "SELECT owner, token, expires_at FROM gorqlite_locks WHERE name = ?"):
+		values := ""
+		if l, ok := b.locks[args[0].(string)]; ok {
+			values = fmt.Sprintf(`,"values":[[%q,%d,%d]]`, l.owner, l.token, int64(l.expires))
+		}
+		return `{"columns":["owner","token","expires_at"],"types":["text","integer","integer"]` + values + `}`
+	case strings.HasPrefix(query, "UPDATE gorqlite_locks SET expires_at = "):
+		expires := float64(0)
+		if strings.HasPrefix(query, "UPDATE gorqlite_locks SET expires_at = ?") {
+			expires, args = args[0].(float64), args[1:]
+		}
+		l, ok := b.locks[args[0].(string)]
+		if !ok || l.owner != args[1].(string) || float64(l.token) != args[2].(float64) {
+			return `{"rows_affected":0}`
+		}
+		l.expires = expires
+		return `{"rows_affected":1}`
+	}
+	b.t.Errorf("unexpected statement %s", query)
+	return `{}`
+}
+
+func TestLockBehavior(t *testing.T) {
+	b := &locksBackend{t: t, locks: make(map[string]*lockRow)}
+	m := &MockServer{Port: "14001", Respond: b.respond}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	workers := make([]*lock.Manager, 2)
+	for i := range workers {
+		workers[i], err = lock.New(conn, lock.WithOwner(fmt.Sprintf("worker-%d", i+1)), lock.WithPoll(10*time.Millisecond))
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+	}
+	w1, w2 := workers[0], workers[1]
+	const ttl = 100 * time.Millisecond
+
+	// acquire
+	lease, err := w1.TryAcquire(ctx, "leader", ttl)
+	if err != nil {
+		t.Fatalf("TryAcquire failed: %v", err)
+	}
+	if lease.Token != 1 || time.Until(lease.Expires) <= 0 || time.Until(lease.Expires) > ttl {
+		t.Errorf("unexpected lease: %+v", lease)
+	}
+	if _, err := w2.TryAcquire(ctx, "leader", ttl); !errors.Is(err, lock.ErrLocked) || !strings.Contains(err.Error(), "held by worker-1") {
+		t.Errorf("expected the lock to be held by worker-1, got %v", err)
+	}
+	if _, err := w1.TryAcquire(ctx, "leader", ttl); !errors.Is(err, lock.ErrLocked) {
+		t.Errorf("expected the lock not to be reentrant, got %v", err)
+	}
+	other, err := w2.TryAcquire(ctx, "other", ttl)
+	if err != nil || other.Token != 1 {
+		t.Fatalf("expected another lock to be free, got %+v, %v", other, err)
+	}
+
+	// renew: the lease outlives its first TTL
+	for i := 0; i < 3; i++ {
+		time.Sleep(ttl / 2)
+		expires := lease.Expires
+		if err := lease.Renew(ctx); err != nil {
+			t.Fatalf("Renew failed: %v", err)
+		}
+		if !lease.Expires.After(expires) {
+			t.Errorf("expected the lease to be extended past %s, got %s", expires, lease.Expires)
+		}
+		if _, err := w2.TryAcquire(ctx, "leader", ttl); !errors.Is(err, lock.ErrLocked) {
+			t.Errorf("expected the renewed lock to be held, got %v", err)
+		}
+	}
+
+	// takeover after expiry: the waiting worker gets the lock with a newer
+	// fencing token, and the previous holder lost it
+	start := time.Now()
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	taken, err := w2.Acquire(waitCtx, "leader", ttl)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if taken.Token != 2 || time.Since(start) < ttl/2 {
+		t.Errorf("expected the lock to be taken over with token 2 once expired, got %+v after %s", taken, time.Since(start))
+	}
+	if err := lease.Renew(ctx); !errors.Is(err, lock.ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost renewing the expired lease, got %v", err)
+	}
+	if err := lease.Release(ctx); !errors.Is(err, lock.ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost releasing the expired lease, got %v", err)
+	}
+
+	// a lease that expired but wasn't taken over can still be renewed
+	time.Sleep(ttl + ttl/2)
+	if err := taken.Renew(ctx); err != nil {
+		t.Errorf("Renew of an expired lease no one took failed: %v", err)
+	}
+
+	// a released lock is free at once, and keeps its fencing token
+	if err := taken.Release(ctx); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	lease, err = w1.TryAcquire(ctx, "leader", ttl)
+	if err != nil || lease.Token != 3 {
+		t.Errorf("expected the released lock to be taken with token 3, got %+v, %v", lease, err)
+	}
+}
@@ -0,0 +1,261 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+	"github.com/eluv-io/gorqlite/migrate"
+)
+
+// migrationsBackend answers the calls of a migrator, keeping the applied
+// migrations and the lock in memory.
+type migrationsBackend struct {
+	t *testing.T
+
+	mu       sync.Mutex              // guards the fields below, changed by the test
+	applied  map[int64][]interface{} // version -> version, name, checksum, applied_at
+	ddl      []string                // the statements of the migrations
+	lockedBy string                  // another owner holding the lock
+	loseLock bool                    // renewals fail
+	locking  int                     // attempts to take the lock
+	released int
+	writes   int
+}
+
+// update changes the state of the backend, without racing with the requests
+// still being answered.
+func (b *migrationsBackend) update(f func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f()
+}
+
+func (b *migrationsBackend) respond(w http.ResponseWriter, req *http.Request) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	body, _ := io.ReadAll(req.Body)
+	var stmts [][]interface{}
+	if err := json.Unmarshal(body, &stmts); err != nil {
+		b.t.Errorf("unexpected request %s: %v", body, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return true
+	}
+
+	switch req.URL.Path {
+	case "/db/query":
+		var rows []string
+		versions := make([]int64, 0, len(b.applied))
+		for v := range b.applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+		for _, v := range versions {
+			row, _ := json.Marshal(b.applied[v])
+			rows = append(rows, string(row))
+		}
+		w.Write([]byte(`{"results":[{"columns":["version","name","checksum","applied_at"],"types":["integer","text","text","integer"],"values":[` +
+			strings.Join(rows, ",") + `]}]}`))
+	case "/db/execute":
+		b.writes++
+		if _, ok := req.URL.Query()["transaction"]; !ok {
+			b.t.Errorf("expected a transaction, got %s", req.URL)
+		}
+		var results []string
+		for _, stmt := range stmts {
+			results = append(results, fmt.Sprintf(`{"rows_affected":%d}`, b.execute(stmt)))
+		}
+		w.Write([]byte(`{"results":[` + strings.Join(results, ",") + `]}`))
+	default:
+		return false
+	}
+	return true
+}
+
+// execute applies a statement, returning the number of rows affected.
+func (b *migrationsBackend) execute(stmt []interface{}) int {
+	query := stmt[0].(string)
+	args := stmt[1:]
+	switch {
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS schema_migrations"):
+		return 0
+	case strings.HasPrefix(query, "INSERT INTO schema_migrations_lock"):
+		b.locking++
+		if b.lockedBy != "" {
+			return 0
+		}
+		return 1
+	case strings.HasPrefix(query, "UPDATE schema_migrations_lock"):
+		if b.loseLock {
+			return 0
+		}
+		return 1
+	case strings.HasPrefix(query, "DELETE FROM schema_migrations_lock"):
+		b.released++
+		return 1
+	case strings.HasPrefix(query, "INSERT INTO schema_migrations "):
+		b.applied[int64(args[0].(float64))] = args
+		return 1
+	case strings.HasPrefix(query, "DELETE FROM schema_migrations "):
+		delete(b.applied, int64(args[0].(float64)))
+		return 1
+	}
+	b.ddl = append(b.ddl, query)
+	return 0
+}
+
+var migrationFiles = fstest.MapFS{
+	"1_users.up.sql":   {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);")},
+	"1_users.down.sql": {Data: []byte("DROP TABLE users;")},
+	"2_posts.up.sql":   {Data: []byte("CREATE TABLE posts (id INTEGER PRIMARY KEY);\nCREATE INDEX posts_id ON posts (id);")},
+	"2_posts.down.sql": {Data: []byte("DROP TABLE posts;")},
+}
+
+func versions(migrations []migrate.Migration) []uint64 {
+	var vs []uint64
+	for _, mig := range migrations {
+		vs = append(vs, mig.Version)
+	}
+	return vs
+}
+
+func TestMigrate(t *testing.T) {
+	b := &migrationsBackend{t: t, applied: make(map[int64][]interface{})}
+	m := &MockServer{Port: "14001", Respond: b.respond}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	migrator, err := migrate.New(conn, migrationFiles, migrate.WithOwner("test"), migrate.WithLockPoll(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		dry, err := migrate.New(conn, migrationFiles, migrate.WithDryRun())
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		pending, err := dry.Up(ctx)
+		if err != nil || fmt.Sprint(versions(pending)) != "[1 2]" {
+			t.Errorf("expected migrations 1 and 2 to be pending, got %v, %v", versions(pending), err)
+		}
+		if b.writes != 0 {
+			t.Errorf("expected a dry run not to write, got %d writes", b.writes)
+		}
+	})
+
+	t.Run("up", func(t *testing.T) {
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			t.Fatalf("Up failed: %v", err)
+		}
+		if fmt.Sprint(versions(applied)) != "[1 2]" {
+			t.Errorf("expected migrations 1 and 2 to be applied, got %v", versions(applied))
+		}
+		expected := []string{
+			"CREATE TABLE users (id INTEGER PRIMARY KEY)",
+			"CREATE TABLE posts (id INTEGER PRIMARY KEY)",
+			"CREATE INDEX posts_id ON posts (id)",
+		}
+		if fmt.Sprint(b.ddl) != fmt.Sprint(expected) {
+			t.Errorf("expected the statements %q, got %q", expected, b.ddl)
+		}
+		if b.released != 1 {
+			t.Errorf("expected the lock to be released once, got %d", b.released)
+		}
+
+		pending, err := migrator.Pending(ctx)
+		if err != nil || len(pending) != 0 {
+			t.Errorf("expected no pending migration, got %v, %v", versions(pending), err)
+		}
+		records, err := migrator.Applied(ctx)
+		if err != nil || len(records) != 2 || records[1].Name != "posts" || records[1].Checksum != migrator.Migrations()[1].Checksum {
+			t.Errorf("unexpected records: %+v, %v", records, err)
+		}
+	})
+
+	t.Run("down", func(t *testing.T) {
+		b.ddl = nil
+		if _, err := migrator.Down(ctx, -1); err == nil {
+			t.Error("expected an error for a negative number of steps")
+		}
+		writes := b.writes
+		if rolledBack, err := migrator.Down(ctx, 0); err != nil || len(rolledBack) != 0 || b.writes != writes {
+			t.Errorf("expected zero steps to do nothing, got %v, %v after %d writes", versions(rolledBack), err, b.writes-writes)
+		}
+
+		rolledBack, err := migrator.Down(ctx, 1)
+		if err != nil || fmt.Sprint(versions(rolledBack)) != "[2]" {
+			t.Fatalf("expected migration 2 to be rolled back, got %v, %v", versions(rolledBack), err)
+		}
+		if fmt.Sprint(b.ddl) != "[DROP TABLE posts]" || len(b.applied) != 1 {
+			t.Errorf("unexpected rollback: %q, applied %v", b.ddl, b.applied)
+		}
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		b.update(func() { b.applied[1][2] = "modified" })
+		defer b.update(func() { b.applied[1][2] = migrator.Migrations()[0].Checksum })
+		if _, err := migrator.Pending(ctx); !errors.Is(err, migrate.ErrChecksumMismatch) {
+			t.Errorf("expected ErrChecksumMismatch, got %v", err)
+		}
+	})
+
+	t.Run("lock held", func(t *testing.T) {
+		b.update(func() {
+			b.lockedBy = "other"
+			b.locking = 0
+		})
+		defer b.update(func() { b.lockedBy = "" })
+		waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		// the deadline may expire while the lock is being taken, or between
+		// two attempts
+		if _, err := migrator.Up(waitCtx); err == nil || waitCtx.Err() == nil {
+			t.Errorf("expected Up to wait for the lock until the deadline, got %v", err)
+		}
+		b.update(func() {
+			if b.locking < 2 {
+				t.Errorf("expected Up to try the lock again, got %d attempts", b.locking)
+			}
+			if len(b.applied) != 1 {
+				t.Errorf("expected no migration without the lock, got %v", b.applied)
+			}
+		})
+	})
+
+	t.Run("lock lost", func(t *testing.T) {
+		b.update(func() {
+			b.applied = make(map[int64][]interface{})
+			b.loseLock = true
+		})
+		defer b.update(func() { b.loseLock = false })
+		applied, err := migrator.Up(ctx)
+		if !errors.Is(err, migrate.ErrLockLost) {
+			t.Errorf("expected ErrLockLost, got %v", err)
+		}
+		if fmt.Sprint(versions(applied)) != "[1]" || len(b.applied) != 1 {
+			t.Errorf("expected Up to stop after migration 1, got %v and %v", versions(applied), b.applied)
+		}
+	})
+}
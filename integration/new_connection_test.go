@@ -0,0 +1,78 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+func TestNewConnection(t *testing.T) {
+	clusterNodes, err := os.ReadFile("assets/three_node_cluster_nodes.json")
+	if err != nil {
+		t.Errorf("failed to read cluster nodes json files: %v", err)
+		return
+	}
+
+	var mu sync.Mutex
+	var headers []http.Header
+	mockServer := &MockServer{
+		Nodes: clusterNodes,
+		OnRequest: func(req *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			headers = append(headers, req.Header.Clone())
+		},
+	}
+	mockServer.Start()
+	defer mockServer.Stop()
+
+	if err := mockServer.WaitForReady(); err != nil {
+		t.Errorf("mock server failed to start: %v", err)
+		return
+	}
+
+	cfg := gorqlite.NewConfig()
+	if err := cfg.ParseDSN("http://localhost:14001"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := gorqlite.NewConnection(context.Background(), *cfg,
+		gorqlite.WithHeader("X-Service", "integration"),
+		gorqlite.WithConsistency(gorqlite.ConsistencyLevelStrong))
+	if err != nil {
+		t.Errorf("failed to open connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	level, err := conn.ConsistencyLevel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != "strong" {
+		t.Errorf("consistency level should be strong, but is %s", level)
+	}
+
+	peers, err := conn.Peers(context.Background())
+	if err != nil {
+		t.Errorf("failed to get peers: %v", err)
+		return
+	}
+	if len(peers) != 3 {
+		t.Errorf("expected 3 peers, but got %d", len(peers))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(headers) == 0 {
+		t.Fatalf("expected requests")
+	}
+	for _, h := range headers {
+		if h.Get("X-Service") != "integration" {
+			t.Errorf("expected X-Service header, got %v", h)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package integration
+
+import (
+	"context"
+	"testing"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+func TestPeerHealth(t *testing.T) {
+	m := &MockServer{Port: "14003", Query: []byte(`{"results":[{"columns":["id"],"types":["integer"],"values":[[1]]}]}`)}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	// nothing listens on 14001
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true,http://localhost:14003")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 4; i++ {
+		if _, err := conn.QueryOneContext(context.Background(), "SELECT 1"); err != nil {
+			t.Fatalf("query %d failed: %v", i, err)
+		}
+	}
+
+	health := conn.PeerHealth()
+	if len(health) != 2 {
+		t.Fatalf("expected the health of 2 peers, got %+v", health)
+	}
+	dead, alive := health[0], health[1]
+	if dead.Peer != "localhost:14001" || dead.State != gorqlite.BreakerOpen || dead.ConsecutiveFailures != 3 || dead.LastError == "" {
+		t.Errorf("the breaker of the dead peer should be open after 3 failures, got %+v", dead)
+	}
+	if alive.Peer != "localhost:14003" || alive.State != gorqlite.BreakerClosed || alive.Latency == 0 {
+		t.Errorf("unexpected health of the live peer: %+v", alive)
+	}
+}
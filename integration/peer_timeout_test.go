@@ -0,0 +1,58 @@
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+func TestPeerTimeout(t *testing.T) {
+	query := []byte(`{"results":[{"columns":["id"],"types":["integer"],"values":[[1]]}]}`)
+	slow := &MockServer{Port: "14001", Query: query, Delay: 2 * time.Second}
+	fast := &MockServer{Port: "14003", Query: query}
+	for _, m := range []*MockServer{slow, fast} {
+		m.Start()
+		defer m.Stop()
+		if err := m.WaitForReady(); err != nil {
+			t.Errorf("mock server failed to start: %v", err)
+			return
+		}
+	}
+
+	// the hung leader only gets 100ms before the next peer is tried
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true&peerTimeout=100ms,http://localhost:14003")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	qr, err := conn.QueryOneContext(context.Background(), "SELECT 1")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if qr.NumRows() != 1 {
+		t.Errorf("expected 1 row, got %d", qr.NumRows())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("query should have failed over after the peer timeout, took %v", elapsed)
+	}
+
+	// the query timeout limits the whole call
+	conn, err = gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true&queryTimeout=100ms,http://localhost:14003")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+
+	start = time.Now()
+	_, err = conn.QueryOneContext(context.Background(), "SELECT 1")
+	if err == nil {
+		t.Errorf("expected the query to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("query should have timed out after 100ms, took %v", elapsed)
+	}
+}
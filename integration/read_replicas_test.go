@@ -0,0 +1,72 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+func TestReadReplicas(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string][]string{}
+	record := func(port string) func(*http.Request) {
+		return func(r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			hits[port] = append(hits[port], r.URL.Path)
+		}
+	}
+	query := []byte(`{"results":[{"columns":["id"],"types":["integer"],"values":[[1]]}]}`)
+	execute := []byte(`{"results":[{"last_insert_id":1,"rows_affected":1}]}`)
+	nodes := []byte(`{
+		"1": {"api_addr": "http://localhost:14001", "addr": "localhost:14002", "reachable": true, "leader": true, "voter": true},
+		"ro": {"api_addr": "http://localhost:14003", "addr": "localhost:14004", "reachable": true, "leader": false, "voter": false}
+	}`)
+	leader := &MockServer{Port: "14001", Nodes: nodes, Query: query, Execute: execute, OnRequest: record("14001")}
+	replica := &MockServer{Port: "14003", Nodes: nodes, Query: query, Execute: execute, OnRequest: record("14003")}
+	for _, m := range []*MockServer{leader, replica} {
+		m.Start()
+		defer m.Stop()
+		if err := m.WaitForReady(); err != nil {
+			t.Fatalf("mock server failed to start: %v", err)
+		}
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+	peers, err := conn.Peers(context.Background())
+	if err != nil || len(peers) != 1 {
+		t.Errorf("read-only nodes should not be peers, got %v, %v", peers, err)
+	}
+
+	mu.Lock()
+	hits = map[string][]string{}
+	mu.Unlock()
+
+	ctx := context.Background()
+	none := gorqlite.CallConsistency(gorqlite.ConsistencyLevelNone)
+	if _, err := conn.QueryOneContext(ctx, "SELECT 1", none); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if _, err := conn.QueryOneContext(ctx, "SELECT 1", gorqlite.CallConsistency(gorqlite.ConsistencyLevelWeak)); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if _, err := conn.WriteOneContext(ctx, "INSERT INTO foo VALUES (1)", none); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := hits["14003"]; len(got) != 1 || got[0] != "/db/query" {
+		t.Errorf("the replica should only get the none-level query, got %v", got)
+	}
+	if got := hits["14001"]; len(got) != 2 || got[0] != "/db/query" || got[1] != "/db/execute" {
+		t.Errorf("the leader should get the weak query and the write, got %v", got)
+	}
+}
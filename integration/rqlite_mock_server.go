@@ -1,29 +1,68 @@
 package integration
 
 import (
+	"bytes"
 	"fmt"
+	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type MockServer struct {
 	srv *http.Server
 
-	Port   string
-	Status []byte
-	Nodes  []byte
+	Port    string
+	Status  []byte
+	Nodes   []byte
+	Query   []byte
+	Execute []byte
+	Request []byte
+	Ready   []byte        // /readyz answers 503 with this body if it has a failed check
+	Delay   time.Duration // added to the response time of every request
+
+	// OnRequest, if set, is called with every request received
+	OnRequest func(req *http.Request)
+	// Respond, if set, may answer a request in place of the mock: it returns
+	// false to let the mock answer
+	Respond func(w http.ResponseWriter, req *http.Request) bool
+
+	mu       sync.RWMutex // guards the fields changed by Update
+	newConns int64
+}
+
+// Update changes the fields of the running mock, e.g. its Delay or Respond,
+// without racing with the requests being handled.
+func (m *MockServer) Update(f func(m *MockServer)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f(m)
 }
 
-func (m *MockServer) getStatus(w http.ResponseWriter, req *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(m.Status)
+// NewConnections returns the number of TCP connections accepted so far.
+func (m *MockServer) NewConnections() int64 {
+	return atomic.LoadInt64(&m.newConns)
 }
 
-func (m *MockServer) getNodes(w http.ResponseWriter, req *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(m.Nodes)
+func (m *MockServer) handle(body func() []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		m.mu.RLock()
+		onRequest, delay, respond, b := m.OnRequest, m.Delay, m.Respond, body()
+		m.mu.RUnlock()
+		if onRequest != nil {
+			onRequest(req)
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		if respond != nil && respond(w, req) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	}
 }
 
 func (m *MockServer) Start() error {
@@ -35,10 +74,25 @@ func (m *MockServer) Start() error {
 	m.srv = &http.Server{
 		Addr:    fmt.Sprintf(":%s", m.Port),
 		Handler: mux,
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt64(&m.newConns, 1)
+			}
+		},
 	}
 
-	mux.HandleFunc("/status", m.getStatus)
-	mux.HandleFunc("/nodes", m.getNodes)
+	mux.HandleFunc("/status", m.handle(func() []byte { return m.Status }))
+	mux.HandleFunc("/nodes", m.handle(func() []byte { return m.Nodes }))
+	mux.HandleFunc("/db/query", m.handle(func() []byte { return m.Query }))
+	mux.HandleFunc("/db/execute", m.handle(func() []byte { return m.Execute }))
+	mux.HandleFunc("/db/request", m.handle(func() []byte { return m.Request }))
+	mux.HandleFunc("/remove", m.handle(func() []byte { return nil }))
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		if bytes.Contains(m.Ready, []byte("[-]")) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(m.Ready)
+	})
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
@@ -0,0 +1,146 @@
+package integration
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+// hangUp accepts connections on the port, reads a request and closes the
+// connection without answering: the response is lost.
+func hangUp(t *testing.T, port string) net.Listener {
+	l, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %v", port, err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if req, err := http.ReadRequest(bufio.NewReader(c)); err == nil {
+				io.Copy(io.Discard, req.Body)
+			}
+			c.Close()
+		}
+	}()
+	return l
+}
+
+func TestSafeWriteRetries(t *testing.T) {
+	var executes int64
+	var lastBody atomic.Value
+	m := &MockServer{
+		Port:    "14003",
+		Execute: []byte(`{"results":[{},{"last_insert_id":1,"rows_affected":1},{"last_insert_id":7,"rows_affected":1}]}`),
+		OnRequest: func(req *http.Request) {
+			if req.URL.Path == "/db/execute" {
+				atomic.AddInt64(&executes, 1)
+				b, _ := io.ReadAll(req.Body)
+				lastBody.Store(string(b))
+			}
+		},
+	}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	open := func() *gorqlite.Connection {
+		conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true,http://localhost:14003")
+		if err != nil {
+			t.Fatalf("failed to open connection: %v", err)
+		}
+		return conn
+	}
+	stmt := "INSERT INTO foo (name) VALUES ('bar')"
+
+	t.Run("never sent", func(t *testing.T) {
+		// nothing listens on 14001: the write is replayed on 14003
+		conn := open()
+		defer conn.Close()
+		atomic.StoreInt64(&executes, 0)
+		if _, err := conn.WriteOneContext(context.Background(), stmt); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		if n := atomic.LoadInt64(&executes); n != 1 {
+			t.Errorf("expected the write to be replayed once on the second peer, got %d", n)
+		}
+	})
+
+	l := hangUp(t, "14001")
+	defer l.Close()
+
+	t.Run("response lost", func(t *testing.T) {
+		conn := open()
+		defer conn.Close()
+		atomic.StoreInt64(&executes, 0)
+		_, err := conn.WriteOneContext(context.Background(), stmt)
+		if !errors.Is(err, gorqlite.ErrAmbiguousWrite) {
+			t.Fatalf("expected ErrAmbiguousWrite, got %v", err)
+		}
+		if n := atomic.LoadInt64(&executes); n != 0 {
+			t.Errorf("the ambiguous write must not be replayed, got %d replays", n)
+		}
+	})
+
+	t.Run("response lost with idempotency key", func(t *testing.T) {
+		conn := open()
+		defer conn.Close()
+		atomic.StoreInt64(&executes, 0)
+		wr, err := conn.WriteOneContext(context.Background(), stmt, gorqlite.CallIdempotencyKey("key-1"))
+		if err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		if n := atomic.LoadInt64(&executes); n != 1 {
+			t.Errorf("expected the write to be replayed once on the second peer, got %d", n)
+		}
+		if wr.LastInsertID != 7 {
+			t.Errorf("expected the result of the statement, got %+v", wr)
+		}
+		body, _ := lastBody.Load().(string)
+		if !strings.Contains(body, gorqlite.IdempotencyTable) || !strings.Contains(body, "key-1") {
+			t.Errorf("expected the key to be recorded by the write, got %s", body)
+		}
+	})
+
+	t.Run("key already recorded", func(t *testing.T) {
+		m.Execute = []byte(`{"results":[{},{"error":"UNIQUE constraint failed: gorqlite_idempotency.key"}]}`)
+		conn := open()
+		defer conn.Close()
+		_, err := conn.WriteOneContext(context.Background(), stmt, gorqlite.CallIdempotencyKey("key-1"))
+		if !errors.Is(err, gorqlite.ErrAlreadyApplied) {
+			t.Fatalf("expected ErrAlreadyApplied, got %v", err)
+		}
+	})
+
+	t.Run("prune creates the table", func(t *testing.T) {
+		m.Update(func(m *MockServer) {
+			m.Execute = []byte(`{"results":[{},{"rows_affected":3}]}`)
+		})
+		conn, err := gorqlite.Open("http://localhost:14003?disableClusterDiscovery=true")
+		if err != nil {
+			t.Fatalf("failed to open connection: %v", err)
+		}
+		defer conn.Close()
+		pruned, err := conn.PruneIdempotencyKeys(context.Background(), time.Hour)
+		if err != nil || pruned != 3 {
+			t.Fatalf("expected 3 keys to be pruned, got %d, %v", pruned, err)
+		}
+		body, _ := lastBody.Load().(string)
+		if !strings.Contains(body, "CREATE TABLE IF NOT EXISTS "+gorqlite.IdempotencyTable) || !strings.Contains(body, "DELETE FROM "+gorqlite.IdempotencyTable) {
+			t.Errorf("expected the table to be created before the keys are pruned, got %s", body)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+// schemaResponses are the answers of the mock to the introspection queries,
+// by the start of the first query of the request.
+var schemaResponses = []struct {
+	query    string
+	response string
+}{
+	{"SELECT name, sql FROM sqlite_master", `{"results":[{"columns":["name","sql"],"types":["text","text"],"values":[
+		["orders","CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id) ON DELETE CASCADE)"],
+		["users","CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL UNIQUE, status TEXT DEFAULT 'new')"]]}]}`},
+	{`PRAGMA table_info(\"missing\")`, `{"results":[{"columns":["cid","name","type","notnull","dflt_value","pk"],"types":["","","","","",""]}]}`},
+	{`PRAGMA table_info(\"users\")`, `{"results":[{"columns":["cid","name","type","notnull","dflt_value","pk"],"types":["","","","","",""],"values":[
+		[0,"id","INTEGER",0,null,1],
+		[1,"email","TEXT",1,null,0],
+		[2,"status","TEXT",0,"'new'",0]]}]}`},
+	{`PRAGMA index_list(\"users\")`, `{"results":[{"columns":["seq","name","unique","origin","partial"],"types":["","","","",""],"values":[
+		[0,"users_status",0,"c",1],
+		[1,"sqlite_autoindex_users_1",1,"u",0]]}]}`},
+	{`PRAGMA index_info(`, `{"results":[
+		{"columns":["seqno","cid","name"],"types":["","",""],"values":[[0,2,"status"]]},
+		{"columns":["seqno","cid","name"],"types":["","",""],"values":[[0,1,"email"]]}]}`},
+	{`PRAGMA foreign_key_list(\"orders\")`, `{"results":[{"columns":["id","seq","table","from","to","on_update","on_delete","match"],"types":["","","","","","","",""],"values":[
+		[0,0,"users","user_id","id","NO ACTION","CASCADE","NONE"]]}]}`},
+}
+
+func TestSchemaIntrospection(t *testing.T) {
+	m := &MockServer{
+		Port: "14001",
+		Respond: func(w http.ResponseWriter, req *http.Request) bool {
+			body, _ := io.ReadAll(req.Body)
+			for _, r := range schemaResponses {
+				if strings.HasPrefix(string(body), `[["`+r.query) {
+					w.Write([]byte(r.response))
+					return true
+				}
+			}
+			t.Errorf("unexpected request: %s", body)
+			w.WriteHeader(http.StatusBadRequest)
+			return true
+		},
+	}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	tables, err := conn.Tables(ctx)
+	if err != nil {
+		t.Fatalf("Tables failed: %v", err)
+	}
+	if len(tables) != 2 || tables[0].Name != "orders" || tables[1].Name != "users" || !strings.HasPrefix(tables[1].SQL, "CREATE TABLE users") {
+		t.Errorf("unexpected tables: %+v", tables)
+	}
+
+	columns, err := conn.Columns(ctx, "users")
+	if err != nil {
+		t.Fatalf("Columns failed: %v", err)
+	}
+	expectedColumns := []gorqlite.Column{
+		{Name: "id", Type: "INTEGER", PrimaryKey: 1},
+		{Name: "email", Type: "TEXT", NotNull: true},
+		{Name: "status", Type: "TEXT", Default: gorqlite.Null[string]{V: "'new'", Valid: true}},
+	}
+	if !reflect.DeepEqual(columns, expectedColumns) {
+		t.Errorf("expected columns %+v, got %+v", expectedColumns, columns)
+	}
+	if _, err := conn.Columns(ctx, "missing"); !errors.Is(err, gorqlite.ErrNoSuchTable) {
+		t.Errorf("expected ErrNoSuchTable, got %v", err)
+	}
+
+	indexes, err := conn.Indexes(ctx, "users")
+	if err != nil {
+		t.Fatalf("Indexes failed: %v", err)
+	}
+	expectedIndexes := []gorqlite.Index{
+		{Name: "sqlite_autoindex_users_1", Unique: true, Origin: "u", Columns: []string{"email"}},
+		{Name: "users_status", Origin: "c", Partial: true, Columns: []string{"status"}},
+	}
+	if !reflect.DeepEqual(indexes, expectedIndexes) {
+		t.Errorf("expected indexes %+v, got %+v", expectedIndexes, indexes)
+	}
+
+	fks, err := conn.ForeignKeys(ctx, "orders")
+	if err != nil {
+		t.Fatalf("ForeignKeys failed: %v", err)
+	}
+	expectedFKs := []gorqlite.ForeignKey{
+		{Table: "users", From: []string{"user_id"}, To: []string{"id"}, OnUpdate: "NO ACTION", OnDelete: "CASCADE", Match: "NONE"},
+	}
+	if !reflect.DeepEqual(fks, expectedFKs) {
+		t.Errorf("expected foreign keys %+v, got %+v", expectedFKs, fks)
+	}
+}
@@ -0,0 +1,117 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+func TestServerVersion(t *testing.T) {
+	status, err := os.ReadFile("assets/three_node_cluster_status.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := os.ReadFile("assets/three_node_cluster_nodes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requests int64
+	m := &MockServer{
+		Port:    "14001",
+		Status:  status, // v7.9.2
+		Nodes:   nodes,
+		Request: []byte(`{"results":[{"columns":["1"],"types":["integer"],"values":[[1]]}]}`),
+		OnRequest: func(r *http.Request) {
+			if r.URL.Path == "/db/request" {
+				atomic.AddInt64(&requests, 1)
+			}
+		},
+	}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+	returning := []gorqlite.ParameterizedStatement{{Query: "INSERT INTO foo (name) VALUES ('a') RETURNING id", Returning: true}}
+
+	if v := conn.ServerVersion(); v.String() != "7.9.2" {
+		t.Errorf("expected version 7.9.2, got %s", v)
+	}
+
+	if _, err := conn.RequestContext(context.Background(), []string{"SELECT 1"}); err != nil {
+		t.Errorf("expected /db/request to be supported, got %v", err)
+	}
+	atomic.StoreInt64(&requests, 0)
+	_, err = conn.RequestParameterizedContext(context.Background(), returning)
+	if !errors.Is(err, gorqlite.ErrUnsupportedByServer) {
+		t.Errorf("expected ErrUnsupportedByServer, got %v", err)
+	}
+	if n := atomic.LoadInt64(&requests); n != 0 {
+		t.Errorf("expected no request to be sent, got %d", n)
+	}
+
+	// without discovery, the version is detected by the first call needing it
+	conn, err = gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.RequestParameterizedContext(context.Background(), returning)
+	if !errors.Is(err, gorqlite.ErrUnsupportedByServer) {
+		t.Errorf("expected ErrUnsupportedByServer, got %v", err)
+	}
+	if n := atomic.LoadInt64(&requests); n != 0 {
+		t.Errorf("expected no request to be sent, got %d", n)
+	}
+}
+
+func TestServerVersion5(t *testing.T) {
+	// 5.x servers have no /nodes api: the leader comes from the metadata
+	m := &MockServer{
+		Port: "14001",
+		Status: []byte(`{
+			"build": {"version": "5.12.1"},
+			"store": {
+				"leader": "localhost:14002",
+				"metadata": {"localhost:14002": {"api_addr": "localhost:14001", "api_proto": "http"}}
+			}
+		}`),
+		OnRequest: func(r *http.Request) {
+			if r.URL.Path == "/nodes" {
+				t.Errorf("unexpected /nodes request")
+			}
+		},
+	}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+
+	leader, err := conn.Leader(context.Background())
+	if err != nil || leader != "localhost:14001" {
+		t.Errorf("expected leader localhost:14001, got %s, %v", leader, err)
+	}
+	if v := conn.ServerVersion(); v.Major != 5 {
+		t.Errorf("expected version 5, got %s", v)
+	}
+}
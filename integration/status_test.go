@@ -0,0 +1,73 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+func TestStatusNodesReady(t *testing.T) {
+	status, err := os.ReadFile("assets/three_node_cluster_status.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := os.ReadFile("assets/three_node_cluster_nodes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nodesQuery string
+	m := &MockServer{
+		Port:   "14001",
+		Status: status,
+		Nodes:  nodes,
+		Ready:  []byte("[+]node ok\n[-]leader not ok: no leader\n[+]store ok"),
+		OnRequest: func(r *http.Request) {
+			if r.URL.Path == "/nodes" {
+				nodesQuery = r.URL.RawQuery
+			}
+		},
+	}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	st, err := conn.Status(ctx)
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if st.Build.Version != "v7.9.2" || st.Raft.State != "Follower" || st.Raft.AppliedIndex != 13 || st.Leader.NodeID != "rqlite-2" {
+		t.Errorf("unexpected status: %+v", st)
+	}
+
+	ns, err := conn.Nodes(ctx, true)
+	if err != nil {
+		t.Fatalf("nodes failed: %v", err)
+	}
+	if nodesQuery != "nonvoters" {
+		t.Errorf("expected the non-voters to be requested, got query %q", nodesQuery)
+	}
+	if len(ns) != 3 || !ns[2].Leader || ns[2].APIAddr != "http://localhost:14001" {
+		t.Errorf("unexpected nodes: %+v", ns)
+	}
+
+	r, err := conn.Ready(ctx)
+	if err != nil {
+		t.Fatalf("ready failed: %v", err)
+	}
+	if r.Ready || r.Checks["leader"] || !r.Checks["node"] {
+		t.Errorf("unexpected readiness: %+v", r)
+	}
+}
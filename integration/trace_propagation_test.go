@@ -0,0 +1,130 @@
+package integration
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+type recordedSpan struct {
+	name   string
+	parent [8]byte
+	sc     gorqlite.SpanContext
+	attrs  map[string]interface{}
+	ended  bool
+}
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (r *recordingTracer) StartSpan(ctx context.Context, name string) (context.Context, gorqlite.Span) {
+	parent, _ := gorqlite.SpanContextFromContext(ctx)
+	sc := parent
+	_, _ = rand.Read(sc.SpanID[:])
+
+	span := &recordedSpan{name: name, parent: parent.SpanID, sc: sc, attrs: map[string]interface{}{}}
+	r.mu.Lock()
+	r.spans = append(r.spans, span)
+	r.mu.Unlock()
+	return gorqlite.ContextWithSpanContext(ctx, sc), span
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.attrs[key] = value
+}
+
+func (s *recordedSpan) End(error) {
+	s.ended = true
+}
+
+func TestTracePropagation(t *testing.T) {
+	clusterStatus, err := os.ReadFile("assets/three_node_cluster_status.json")
+	if err != nil {
+		t.Errorf("failed to read cluster status json files: %v", err)
+		return
+	}
+
+	var mu sync.Mutex
+	var headers []http.Header
+	mockServer := &MockServer{
+		Status: clusterStatus,
+		Query:  []byte(`{"results":[{"columns":["id"],"types":["integer"],"values":[[1]]}]}`),
+		OnRequest: func(req *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			headers = append(headers, req.Header.Clone())
+		},
+	}
+	mockServer.Start()
+	defer mockServer.Stop()
+
+	if err := mockServer.WaitForReady(); err != nil {
+		t.Errorf("mock server failed to start: %v", err)
+		return
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true")
+	if err != nil {
+		t.Errorf("failed to open connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	tracer := &recordingTracer{}
+	if err := conn.SetPropagator(gorqlite.TraceContextPropagator{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetSpanTracer(tracer); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := gorqlite.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "vendor=value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := gorqlite.ContextWithSpanContext(context.Background(), root)
+
+	_, err = conn.QueryOneContext(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(tracer.spans))
+	}
+	call, attempt := tracer.spans[0], tracer.spans[1]
+	if call.name != "gorqlite.query" || !call.ended {
+		t.Errorf("unexpected call span %s (ended: %v)", call.name, call.ended)
+	}
+	if call.parent != root.SpanID {
+		t.Errorf("call span should be a child of the root span")
+	}
+	if attempt.name != "gorqlite.attempt" || !attempt.ended {
+		t.Errorf("unexpected attempt span %s (ended: %v)", attempt.name, attempt.ended)
+	}
+	if attempt.parent != call.sc.SpanID {
+		t.Errorf("attempt span should be a child of the call span")
+	}
+	if attempt.attrs["net.peer.name"] != "localhost:14001" {
+		t.Errorf("attempt span peer should be localhost:14001, got %v", attempt.attrs["net.peer.name"])
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(headers) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(headers))
+	}
+	if got, want := headers[0].Get("traceparent"), attempt.sc.Traceparent(); got != want {
+		t.Errorf("traceparent should be %s, got %s", want, got)
+	}
+	if got := headers[0].Get("tracestate"); got != "vendor=value" {
+		t.Errorf("tracestate should be vendor=value, got %s", got)
+	}
+}
@@ -0,0 +1,62 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+// BenchmarkQueryParallel compares the transport owned by a Connection with
+// a bare http.Client on the shared default transport, which only keeps 2
+// idle connections per peer and thus reconnects under concurrent load. The
+// mock server answers after 1ms so that requests overlap; conns/op reports
+// the TCP connections opened per query.
+//
+//	go test -bench QueryParallel -benchtime 5s ./integration
+func BenchmarkQueryParallel(b *testing.B) {
+	mockServer := &MockServer{
+		Delay: time.Millisecond,
+		Query: []byte(`{"results":[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"],[2,"sinead"]]}]}`),
+	}
+	mockServer.Start()
+	defer mockServer.Stop()
+
+	if err := mockServer.WaitForReady(); err != nil {
+		b.Fatalf("mock server failed to start: %v", err)
+	}
+
+	run := func(b *testing.B, opts ...gorqlite.Option) {
+		cfg := gorqlite.NewConfig()
+		if err := cfg.ParseDSN("http://localhost:14001?disableClusterDiscovery=true"); err != nil {
+			b.Fatal(err)
+		}
+		conn, err := gorqlite.NewConnection(context.Background(), *cfg, opts...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer conn.Close()
+
+		b.SetParallelism(64)
+		conns := mockServer.NewConnections()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := conn.QueryOneContext(context.Background(), "SELECT id, name FROM foo"); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+		b.ReportMetric(float64(mockServer.NewConnections()-conns)/float64(b.N), "conns/op")
+	}
+
+	b.Run("owned transport", func(b *testing.B) {
+		run(b)
+	})
+	b.Run("default client", func(b *testing.B) {
+		run(b, gorqlite.WithHTTPClient(&http.Client{}))
+	})
+}
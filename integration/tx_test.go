@@ -0,0 +1,83 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+func TestTx(t *testing.T) {
+	var body string
+	m := &MockServer{
+		Port: "14001",
+		OnRequest: func(req *http.Request) {
+			if req.URL.Path == "/db/request" {
+				b, _ := io.ReadAll(req.Body)
+				body = string(b)
+				if _, ok := req.URL.Query()["transaction"]; !ok {
+					t.Errorf("expected a transaction, got %s", req.URL)
+				}
+			}
+		},
+	}
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForReady(); err != nil {
+		t.Fatalf("mock server failed to start: %v", err)
+	}
+
+	conn, err := gorqlite.Open("http://localhost:14001?disableClusterDiscovery=true")
+	if err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	defer conn.Close()
+
+	t.Run("commit", func(t *testing.T) {
+		m.Request = []byte(`{"results":[
+			{"columns":["n"],"types":["integer"],"values":[[1]]},
+			{"last_insert_id":0,"rows_affected":1},
+			{"columns":["balance"],"types":["integer"],"values":[[99]]}]}`)
+		tx := conn.Begin()
+		tx.Guard(1, "SELECT 1 FROM accounts WHERE id = ? AND balance >= ?", 1, 1)
+		debit := tx.Exec("UPDATE accounts SET balance = balance - 1 WHERE id = ?", 1)
+		balance := tx.Query("SELECT balance FROM accounts WHERE id = ?", 1)
+		if err := tx.Commit(context.Background()); err != nil {
+			t.Fatalf("commit failed: %v", err)
+		}
+		if strings.Count(body, "accounts") != 3 {
+			t.Errorf("expected the 3 statements in a single request, got %s", body)
+		}
+
+		wr, err := debit.Result()
+		if err != nil || wr.RowsAffected != 1 {
+			t.Errorf("unexpected result of the update: %+v, %v", wr, err)
+		}
+		qr, err := balance.Result()
+		if err != nil {
+			t.Fatalf("unexpected error of the query: %v", err)
+		}
+		var b int64
+		if !qr.Next() || qr.Scan(&b) != nil || b != 99 {
+			t.Errorf("expected a balance of 99, got %d", b)
+		}
+	})
+
+	t.Run("guard failed", func(t *testing.T) {
+		m.Request = []byte(`{"results":[{"error":"malformed JSON"}]}`)
+		tx := conn.Begin()
+		tx.Guard(1, "SELECT 1 FROM accounts WHERE id = ? AND balance >= ?", 1, 1000)
+		debit := tx.Exec("UPDATE accounts SET balance = balance - 1000 WHERE id = ?", 1)
+		err := tx.Commit(context.Background())
+		if !errors.Is(err, gorqlite.ErrGuardFailed) {
+			t.Fatalf("expected ErrGuardFailed, got %v", err)
+		}
+		if _, err := debit.Result(); !errors.Is(err, gorqlite.ErrTxNotCommitted) {
+			t.Errorf("expected ErrTxNotCommitted for the rolled back statement, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,491 @@
+// Package jobqueue implements a durable job queue stored in rqlite tables.
+//
+//	q, err := jobqueue.New(conn, "emails")
+//	...
+//	id, err := q.Enqueue(ctx, payload, jobqueue.WithPriority(10), jobqueue.WithDelay(time.Minute))
+//	...
+//	err = q.Work(ctx, 4, func(ctx context.Context, job *jobqueue.Job) error {
+//		return send(ctx, job.Payload)
+//	})
+//
+// A claimed job is hidden from the other workers until its visibility
+// timeout: if its worker dies without acknowledging it, the job is claimed
+// again once the timeout expires. A job that failed is retried after a
+// backoff, and moved to the dead-letter table after its last attempt. The
+// jobs are thus processed at least once: their handlers should be
+// idempotent.
+//
+// The run times and visibility timeouts are in the clocks of the processes
+// using the queue, which must be reasonably synchronized.
+package jobqueue
+
+// this file contains the queue:
+//
+//   Queue, New() and its options
+//   Queue.Enqueue() and its options
+//   Queue.Claim() returning a Job
+//   Job.Ack(), Nack(), Release() and Extend()
+//   Queue.DeadLetters()
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+var (
+	// ErrEmpty is returned by Claim when no job is ready.
+	ErrEmpty = errors.New("jobqueue: no job ready")
+
+	// ErrClaimLost is returned, wrapped, when acknowledging a job whose
+	// visibility timeout expired, and which was claimed again since.
+	ErrClaimLost = errors.New("jobqueue: claim lost")
+)
+
+// Queue is a named queue of jobs. Several queues can share the same tables.
+type Queue struct {
+	conn        *gorqlite.Connection
+	name        string
+	table       string
+	visibility  time.Duration
+	maxAttempts int
+	backoff     func(attempt int) time.Duration
+	poll        time.Duration
+	onError     func(error)
+
+	mu      sync.Mutex
+	created bool
+}
+
+// Option configures a Queue.
+type Option func(*Queue)
+
+// WithTable sets the table of the jobs, gorqlite_jobs by default. The dead
+// letters are kept in the table of the same name suffixed with _dead.
+func WithTable(name string) Option {
+	return func(q *Queue) {
+		q.table = name
+	}
+}
+
+// WithVisibilityTimeout sets how long a claimed job is hidden from the other
+// workers, 30 seconds by default.
+func WithVisibilityTimeout(d time.Duration) Option {
+	return func(q *Queue) {
+		q.visibility = d
+	}
+}
+
+// WithMaxAttempts sets the number of attempts at a job before it is moved to
+// the dead-letter table, 5 by default.
+func WithMaxAttempts(n int) Option {
+	return func(q *Queue) {
+		q.maxAttempts = n
+	}
+}
+
+// WithBackoff sets the delay before retrying a job after its given failed
+// attempt, starting at 1. By default the delay starts at 1 second and doubles
+// with each attempt, up to 1 hour.
+func WithBackoff(backoff func(attempt int) time.Duration) Option {
+	return func(q *Queue) {
+		q.backoff = backoff
+	}
+}
+
+// WithPoll sets how often the idle workers of Work look for a job, 1 second
+// by default.
+func WithPoll(poll time.Duration) Option {
+	return func(q *Queue) {
+		q.poll = poll
+	}
+}
+
+// WithErrorHandler sets the function receiving the errors of the workers of
+// Work, which keep going. They are ignored by default.
+func WithErrorHandler(onError func(error)) Option {
+	return func(q *Queue) {
+		q.onError = onError
+	}
+}
+
+// defaultBackoff doubles the delay with each attempt, from 1 second to 1
+// hour.
+func defaultBackoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt > 12 {
+		return time.Hour
+	}
+	d := time.Second << (attempt - 1)
+	if d > time.Hour {
+		return time.Hour
+	}
+	return d
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// New returns the queue of the given name. The queue reads and writes with
+// strong consistency, whatever the settings of the connection. Its tables
+// are created when first used.
+func New(conn *gorqlite.Connection, name string, opts ...Option) (*Queue, error) {
+	q := &Queue{
+		conn:        conn.With(gorqlite.CallConsistency(gorqlite.ConsistencyLevelStrong), gorqlite.CallTransaction(true)),
+		name:        name,
+		table:       "gorqlite_jobs",
+		visibility:  30 * time.Second,
+		maxAttempts: 5,
+		backoff:     defaultBackoff,
+		poll:        time.Second,
+		onError:     func(error) {},
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	if !identifier.MatchString(q.table) {
+		return nil, fmt.Errorf("invalid jobs table name: %q", q.table)
+	}
+	if q.visibility <= 0 || q.poll <= 0 {
+		return nil, errors.New("the visibility timeout and poll interval must be positive")
+	}
+	if q.maxAttempts < 1 {
+		return nil, errors.New("the maximum number of attempts must be positive")
+	}
+	if q.backoff == nil || q.onError == nil {
+		return nil, errors.New("the backoff and error handler can't be nil")
+	}
+	return q, nil
+}
+
+func (q *Queue) deadTable() string {
+	return q.table + "_dead"
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// createTables creates the tables of the queue, once.
+func (q *Queue) createTables(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.created {
+		return nil
+	}
+	err := q.write(ctx, []gorqlite.Statement{
+		{Query: "CREATE TABLE IF NOT EXISTS " + q.table + " (id INTEGER PRIMARY KEY AUTOINCREMENT, queue TEXT NOT NULL, payload TEXT NOT NULL, " +
+			"priority INTEGER NOT NULL, run_at INTEGER NOT NULL, attempts INTEGER NOT NULL DEFAULT 0, last_error TEXT, claim TEXT, locked_until INTEGER)"},
+		{Query: "CREATE INDEX IF NOT EXISTS " + q.table + "_ready ON " + q.table + " (queue, priority, run_at)"},
+		{Query: "CREATE TABLE IF NOT EXISTS " + q.deadTable() + " (id INTEGER PRIMARY KEY, queue TEXT NOT NULL, payload TEXT NOT NULL, " +
+			"priority INTEGER NOT NULL, attempts INTEGER NOT NULL, last_error TEXT, failed_at INTEGER NOT NULL)"},
+	})
+	if err != nil {
+		return fmt.Errorf("could not create the tables of queue %s: %w", q.name, err)
+	}
+	q.created = true
+	return nil
+}
+
+// write executes the statements in a transaction, returning the error of
+// the first failed statement.
+func (q *Queue) write(ctx context.Context, stmts []gorqlite.Statement) error {
+	_, err := q.writeResults(ctx, stmts)
+	return err
+}
+
+func (q *Queue) writeResults(ctx context.Context, stmts []gorqlite.Statement) ([]gorqlite.WriteResult, error) {
+	results, err := q.conn.WriteParameterizedContext(ctx, stmts)
+	if err == nil {
+		return results, nil
+	}
+	if len(results) != len(stmts) {
+		// the call itself failed
+		return nil, err
+	}
+	for i, wr := range results {
+		if wr.Err != nil {
+			return nil, fmt.Errorf("statement %q: %w", stmts[i].Query, wr.Err)
+		}
+	}
+	return nil, err
+}
+
+// enqueueOptions are the settings of a job, see EnqueueOption.
+type enqueueOptions struct {
+	priority int
+	runAt    time.Time
+}
+
+// EnqueueOption configures a job.
+type EnqueueOption func(*enqueueOptions)
+
+// WithPriority sets the priority of the job, 0 by default. The ready jobs of
+// higher priority are claimed first.
+func WithPriority(priority int) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.priority = priority
+	}
+}
+
+// WithRunAt sets when the job becomes ready, now by default.
+func WithRunAt(t time.Time) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.runAt = t
+	}
+}
+
+// WithDelay makes the job ready after the delay.
+func WithDelay(d time.Duration) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.runAt = time.Now().Add(d)
+	}
+}
+
+// Enqueue adds a job with the given payload to the queue, and returns its
+// ID.
+func (q *Queue) Enqueue(ctx context.Context, payload string, opts ...EnqueueOption) (int64, error) {
+	o := enqueueOptions{runAt: time.Now()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := q.createTables(ctx); err != nil {
+		return 0, err
+	}
+	results, err := q.writeResults(ctx, []gorqlite.Statement{{
+		Query:     "INSERT INTO " + q.table + " (queue, payload, priority, run_at) VALUES (?, ?, ?, ?)",
+		Arguments: []interface{}{q.name, payload, o.priority, millis(o.runAt)},
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("could not enqueue to %s: %w", q.name, err)
+	}
+	return results[0].LastInsertID, nil
+}
+
+// Job is a job claimed from a queue. A Job is not safe for concurrent use.
+type Job struct {
+	q     *Queue
+	claim string
+
+	ID          int64
+	Payload     string
+	Priority    int
+	RunAt       time.Time
+	Attempts    int       // the number of claims of the job, this one included
+	LastError   string    // the error of the last failed attempt, if any
+	LockedUntil time.Time // when the job becomes visible to the other workers
+}
+
+// Claim claims the ready job of highest priority, hiding it from the other
+// workers until its visibility timeout. It fails with ErrEmpty if no job is
+// ready. The jobs claimed more than the maximum number of attempts, because
+// their workers died, are moved to the dead-letter table on the way.
+func (q *Queue) Claim(ctx context.Context) (*Job, error) {
+	if err := q.createTables(ctx); err != nil {
+		return nil, err
+	}
+	for {
+		job, err := q.claimOne(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if job.Attempts <= q.maxAttempts {
+			return job, nil
+		}
+		if err := job.bury(ctx, "too many attempts: "+job.LastError); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (q *Queue) claimOne(ctx context.Context) (*Job, error) {
+	claim, err := gorqlite.NewIdempotencyKey()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	lockedUntil := now.Add(q.visibility)
+	stmt := gorqlite.Statement{
+		Query: "UPDATE " + q.table + " SET claim = ?, locked_until = ?, attempts = attempts + 1 WHERE id = (" +
+			"SELECT id FROM " + q.table + " WHERE queue = ? AND run_at <= ? AND (locked_until IS NULL OR locked_until < ?) " +
+			"ORDER BY priority DESC, run_at, id LIMIT 1) " +
+			"RETURNING id, payload, priority, run_at, attempts, last_error",
+		Arguments: []interface{}{claim, millis(lockedUntil), q.name, millis(now), millis(now)},
+		Returning: true,
+	}
+	results, err := q.conn.RequestParameterizedContext(ctx, []gorqlite.Statement{stmt})
+	if err != nil {
+		if len(results) == 1 && results[0].Err != nil {
+			err = results[0].Err
+		}
+		return nil, fmt.Errorf("could not claim from %s: %w", q.name, err)
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("could not claim from %s: expected 1 result, got %d", q.name, len(results))
+	}
+
+	qr := results[0].Query
+	if !qr.Next() {
+		return nil, ErrEmpty
+	}
+	job := &Job{q: q, claim: claim, LockedUntil: lockedUntil}
+	var priority, runAt, attempts int64
+	var lastError gorqlite.Null[string]
+	if err := qr.Scan(&job.ID, &job.Payload, &priority, &runAt, &attempts, &lastError); err != nil {
+		return nil, fmt.Errorf("could not claim from %s: %w", q.name, err)
+	}
+	job.Priority = int(priority)
+	job.RunAt = fromMillis(runAt)
+	job.Attempts = int(attempts)
+	job.LastError = lastError.V
+	return job, nil
+}
+
+// owned checks that a statement on the claimed job affected it.
+func (j *Job) owned(wr gorqlite.WriteResult) error {
+	if wr.RowsAffected != 1 {
+		return fmt.Errorf("%w: job %d of %s", ErrClaimLost, j.ID, j.q.name)
+	}
+	return nil
+}
+
+// Ack deletes the job, which succeeded. It fails with ErrClaimLost if the
+// job was claimed again since its visibility timeout expired.
+func (j *Job) Ack(ctx context.Context) error {
+	results, err := j.q.writeResults(ctx, []gorqlite.Statement{{
+		Query:     "DELETE FROM " + j.q.table + " WHERE id = ? AND claim = ?",
+		Arguments: []interface{}{j.ID, j.claim},
+	}})
+	if err != nil {
+		return fmt.Errorf("could not acknowledge job %d of %s: %w", j.ID, j.q.name, err)
+	}
+	return j.owned(results[0])
+}
+
+// Nack records the failure of the job. The job is retried after the backoff
+// of the queue, or moved to the dead-letter table after its last attempt. It
+// fails with ErrClaimLost if the job was claimed again since its visibility
+// timeout expired.
+func (j *Job) Nack(ctx context.Context, cause error) error {
+	msg := ""
+	if cause != nil {
+		msg = cause.Error()
+	}
+	if j.Attempts >= j.q.maxAttempts {
+		return j.bury(ctx, msg)
+	}
+	results, err := j.q.writeResults(ctx, []gorqlite.Statement{{
+		Query:     "UPDATE " + j.q.table + " SET run_at = ?, last_error = ?, claim = NULL, locked_until = NULL WHERE id = ? AND claim = ?",
+		Arguments: []interface{}{millis(time.Now().Add(j.q.backoff(j.Attempts))), msg, j.ID, j.claim},
+	}})
+	if err != nil {
+		return fmt.Errorf("could not retry job %d of %s: %w", j.ID, j.q.name, err)
+	}
+	return j.owned(results[0])
+}
+
+// bury moves the job to the dead-letter table.
+func (j *Job) bury(ctx context.Context, msg string) error {
+	results, err := j.q.writeResults(ctx, []gorqlite.Statement{
+		{
+			Query: "INSERT INTO " + j.q.deadTable() + " (id, queue, payload, priority, attempts, last_error, failed_at) " +
+				"SELECT id, queue, payload, priority, attempts, ?, ? FROM " + j.q.table + " WHERE id = ? AND claim = ?",
+			Arguments: []interface{}{msg, millis(time.Now()), j.ID, j.claim},
+		},
+		{
+			Query:     "DELETE FROM " + j.q.table + " WHERE id = ? AND claim = ?",
+			Arguments: []interface{}{j.ID, j.claim},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not move job %d of %s to the dead letters: %w", j.ID, j.q.name, err)
+	}
+	return j.owned(results[1])
+}
+
+// Release makes the job visible to the other workers again, without counting
+// the attempt, e.g. when shutting down. It fails with ErrClaimLost if the job
+// was claimed again since its visibility timeout expired.
+func (j *Job) Release(ctx context.Context) error {
+	results, err := j.q.writeResults(ctx, []gorqlite.Statement{{
+		Query:     "UPDATE " + j.q.table + " SET attempts = attempts - 1, claim = NULL, locked_until = NULL WHERE id = ? AND claim = ?",
+		Arguments: []interface{}{j.ID, j.claim},
+	}})
+	if err != nil {
+		return fmt.Errorf("could not release job %d of %s: %w", j.ID, j.q.name, err)
+	}
+	return j.owned(results[0])
+}
+
+// Extend hides the job from the other workers for another visibility
+// timeout, for the jobs that take longer than expected. It fails with
+// ErrClaimLost if the job was claimed again since its visibility timeout
+// expired.
+func (j *Job) Extend(ctx context.Context) error {
+	lockedUntil := time.Now().Add(j.q.visibility)
+	results, err := j.q.writeResults(ctx, []gorqlite.Statement{{
+		Query:     "UPDATE " + j.q.table + " SET locked_until = ? WHERE id = ? AND claim = ?",
+		Arguments: []interface{}{millis(lockedUntil), j.ID, j.claim},
+	}})
+	if err != nil {
+		return fmt.Errorf("could not extend job %d of %s: %w", j.ID, j.q.name, err)
+	}
+	if err := j.owned(results[0]); err != nil {
+		return err
+	}
+	j.LockedUntil = lockedUntil
+	return nil
+}
+
+// DeadLetter is a job moved to the dead-letter table.
+type DeadLetter struct {
+	ID        int64
+	Payload   string
+	Priority  int
+	Attempts  int
+	LastError string
+	FailedAt  time.Time
+}
+
+// DeadLetters returns the dead letters of the queue, latest first, at most
+// limit of them.
+func (q *Queue) DeadLetters(ctx context.Context, limit int) ([]DeadLetter, error) {
+	if err := q.createTables(ctx); err != nil {
+		return nil, err
+	}
+	qr, err := q.conn.QueryOneParameterizedContext(ctx, gorqlite.Statement{
+		Query:     "SELECT id, payload, priority, attempts, last_error, failed_at FROM " + q.deadTable() + " WHERE queue = ? ORDER BY failed_at DESC, id DESC LIMIT ?",
+		Arguments: []interface{}{q.name, limit},
+	})
+	if err != nil {
+		if qr.Err != nil {
+			err = qr.Err
+		}
+		return nil, fmt.Errorf("could not read the dead letters of %s: %w", q.name, err)
+	}
+	var letters []DeadLetter
+	for qr.Next() {
+		var d DeadLetter
+		var priority, attempts, failedAt int64
+		var lastError gorqlite.Null[string]
+		if err := qr.Scan(&d.ID, &d.Payload, &priority, &attempts, &lastError, &failedAt); err != nil {
+			return nil, fmt.Errorf("could not read the dead letters of %s: %w", q.name, err)
+		}
+		d.Priority = int(priority)
+		d.Attempts = int(attempts)
+		d.LastError = lastError.V
+		d.FailedAt = fromMillis(failedAt)
+		letters = append(letters, d)
+	}
+	return letters, nil
+}
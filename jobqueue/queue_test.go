@@ -0,0 +1,25 @@
+package jobqueue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDefaultBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{5, 16 * time.Second},
+		{12, 2048 * time.Second},
+		{13, time.Hour},
+		{100, time.Hour},
+	} {
+		if got := defaultBackoff(tc.attempt); got != tc.want {
+			t.Errorf("defaultBackoff(%d): expected %s, got %s", tc.attempt, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package jobqueue
+
+// this file contains the worker pool:
+//
+//   Handler
+//   Queue.Work() running the handler on the claimed jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Handler processes a job. The job is acknowledged if it returns nil, and
+// retried later otherwise. The context is done when Work is stopping.
+type Handler func(ctx context.Context, job *Job) error
+
+// Work runs the handler on the jobs of the queue with the given number of
+// workers, until the context is done. It then waits for the running handlers
+// to return and returns the error of the context.
+//
+// A job whose handler fails once the context is done is released rather than
+// retried, without counting the attempt. The errors of the queue itself are
+// passed to the error handler of the queue, and the worker that got one
+// waits for the poll interval before going on.
+func (q *Queue) Work(ctx context.Context, workers int, handler Handler) error {
+	if workers < 1 {
+		return errors.New("the number of workers must be positive")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, handler)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// work is the loop of a worker.
+func (q *Queue) work(ctx context.Context, handler Handler) {
+	for ctx.Err() == nil {
+		job, err := q.Claim(ctx)
+		if err != nil {
+			if !errors.Is(err, ErrEmpty) && ctx.Err() == nil {
+				q.onError(err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(q.poll):
+			}
+			continue
+		}
+		q.run(ctx, job, handler)
+	}
+}
+
+// run runs the handler on the job, and acknowledges or retries it.
+func (q *Queue) run(ctx context.Context, job *Job, handler Handler) {
+	err := safeHandle(ctx, job, handler)
+
+	// the job is settled even when stopping
+	settleCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	switch {
+	case err == nil:
+		err = job.Ack(settleCtx)
+	case ctx.Err() != nil:
+		err = job.Release(settleCtx)
+	default:
+		err = job.Nack(settleCtx, err)
+	}
+	if err != nil {
+		q.onError(err)
+	}
+}
+
+// safeHandle runs the handler, turning its panics into errors.
+func safeHandle(ctx context.Context, job *Job, handler Handler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job %d panicked: %v", job.ID, r)
+		}
+	}()
+	return handler(ctx, job)
+}
@@ -0,0 +1,77 @@
+package kv
+
+// this file contains the batches:
+//
+//   Batch, its Put() and Delete()
+//   Store.Apply() writing a batch in a single transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+// Batch is a list of writes applied atomically by Store.Apply.
+//
+//	b := store.Batch()
+//	b.Put("config/a", 1)
+//	b.Delete("config/b")
+//	err := store.Apply(ctx, b)
+type Batch struct {
+	s     *Store
+	stmts []gorqlite.Statement
+	err   error
+}
+
+// Batch returns an empty batch of writes to the store.
+func (s *Store) Batch() *Batch {
+	return &Batch{s: s}
+}
+
+// Put adds the write of the key to the batch. A value that can't be encoded
+// makes Apply fail.
+func (b *Batch) Put(key string, value interface{}, opts ...PutOption) *Batch {
+	stmt, err := b.s.putStatement(key, value, opts)
+	if err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return b
+	}
+	b.stmts = append(b.stmts, stmt)
+	return b
+}
+
+// Delete adds the deletion of the key to the batch.
+func (b *Batch) Delete(key string) *Batch {
+	b.stmts = append(b.stmts, b.s.deleteStatement(key))
+	return b
+}
+
+// Len returns the number of writes of the batch.
+func (b *Batch) Len() int {
+	return len(b.stmts)
+}
+
+// Apply applies the writes of the batch in a single transaction: either all
+// of them are applied, or none.
+func (s *Store) Apply(ctx context.Context, b *Batch) error {
+	if b.s != s {
+		return errors.New("the batch belongs to another store")
+	}
+	if b.err != nil {
+		return b.err
+	}
+	if len(b.stmts) == 0 {
+		return nil
+	}
+	if err := s.createTable(ctx); err != nil {
+		return err
+	}
+	if _, err := s.write(ctx, b.stmts); err != nil {
+		return fmt.Errorf("could not apply the batch of %d writes: %w", len(b.stmts), err)
+	}
+	return nil
+}
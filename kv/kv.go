@@ -0,0 +1,371 @@
+// Package kv implements a replicated key-value store on a rqlite table, for
+// the programs that only need a map and would rather not write SQL.
+//
+//	store, err := kv.New(conn)
+//	...
+//	err = store.Put(ctx, "config/timeout", 30, kv.WithTTL(time.Hour))
+//	var timeout int
+//	version, err := store.Get(ctx, "config/timeout", &timeout)
+//	err = store.CAS(ctx, "config/timeout", version, 60)
+//
+// The values are stored as JSON, and decoded into the types given to Get and
+// Entry.Decode. Each write of a key increments its version, which CAS uses to
+// update it only if it didn't change since it was read.
+//
+// The keys expire according to the clocks of the processes writing and
+// reading them, which must be reasonably synchronized.
+package kv
+
+// this file contains the store:
+//
+//   Store, New() and its options
+//   Store.Get(), List(), Put(), CAS() and Delete()
+//   Store.Purge() deleting the expired keys
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+var (
+	// ErrNotFound is returned, wrapped, when getting a key that doesn't
+	// exist or expired.
+	ErrNotFound = errors.New("kv: key not found")
+
+	// ErrConflict is returned, wrapped, when the key of a CAS changed since it
+	// was read. It is the same error as gorqlite.ErrConflict.
+	ErrConflict = gorqlite.ErrConflict
+)
+
+// Store is a key-value store on a table. It is safe for concurrent use.
+type Store struct {
+	conn  *gorqlite.Connection
+	table string
+
+	mu      sync.Mutex
+	created bool
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithTable sets the table of the store, gorqlite_kv by default.
+func WithTable(name string) Option {
+	return func(s *Store) {
+		s.table = name
+	}
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// New returns the store of the table, which is created when first used. The
+// store reads with the consistency level of the connection: use a view with
+// ConsistencyLevelStrong to read the latest writes.
+func New(conn *gorqlite.Connection, opts ...Option) (*Store, error) {
+	s := &Store{
+		conn:  conn.With(gorqlite.CallTransaction(true)),
+		table: "gorqlite_kv",
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if !identifier.MatchString(s.table) {
+		return nil, fmt.Errorf("invalid kv table name: %q", s.table)
+	}
+	return s, nil
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// createTable creates the table of the store, once.
+func (s *Store) createTable(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.created {
+		return nil
+	}
+	_, err := s.write(ctx, []gorqlite.Statement{{
+		Query: "CREATE TABLE IF NOT EXISTS " + s.table + " (key TEXT PRIMARY KEY, value TEXT NOT NULL, version INTEGER NOT NULL, expires_at INTEGER)",
+	}})
+	if err != nil {
+		return fmt.Errorf("could not create the kv table %s: %w", s.table, err)
+	}
+	s.created = true
+	return nil
+}
+
+// write executes the statements in a transaction, returning the error of
+// the first failed statement.
+func (s *Store) write(ctx context.Context, stmts []gorqlite.Statement) ([]gorqlite.WriteResult, error) {
+	results, err := s.conn.WriteParameterizedContext(ctx, stmts)
+	if err == nil {
+		return results, nil
+	}
+	if len(results) != len(stmts) {
+		// the call itself failed
+		return nil, err
+	}
+	for i, wr := range results {
+		if wr.Err != nil {
+			return nil, fmt.Errorf("statement %q: %w", stmts[i].Query, wr.Err)
+		}
+	}
+	return nil, err
+}
+
+// query runs a single query, returning the error of the statement if it
+// failed.
+func (s *Store) query(ctx context.Context, stmt gorqlite.Statement) (gorqlite.QueryResult, error) {
+	if err := s.createTable(ctx); err != nil {
+		return gorqlite.QueryResult{}, err
+	}
+	qr, err := s.conn.QueryOneParameterizedContext(ctx, stmt)
+	if err != nil && qr.Err != nil {
+		return qr, qr.Err
+	}
+	return qr, err
+}
+
+// Entry is a key of the store with its value.
+type Entry struct {
+	Key     string
+	Value   json.RawMessage // the JSON value
+	Version int64           // incremented by each write of the key
+	Expires time.Time       // when the key expires, zero if never
+}
+
+// Decode decodes the value of the entry into v.
+func (e Entry) Decode(v interface{}) error {
+	if err := json.Unmarshal(e.Value, v); err != nil {
+		return fmt.Errorf("could not decode the value of %s: %w", e.Key, err)
+	}
+	return nil
+}
+
+// scanEntries reads the entries of a query on the key, value, version and
+// expires_at columns.
+func scanEntries(qr gorqlite.QueryResult) ([]Entry, error) {
+	entries := make([]Entry, 0, qr.NumRows())
+	for qr.Next() {
+		var e Entry
+		var value string
+		var expires gorqlite.Null[int64]
+		if err := qr.Scan(&e.Key, &value, &e.Version, &expires); err != nil {
+			return nil, err
+		}
+		e.Value = json.RawMessage(value)
+		if expires.Valid {
+			e.Expires = fromMillis(expires.V)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+const live = "(expires_at IS NULL OR expires_at > ?)"
+
+// Get decodes the value of the key into v, unless v is nil, and returns its
+// version. It fails with ErrNotFound if the key doesn't exist or expired.
+func (s *Store) Get(ctx context.Context, key string, v interface{}) (int64, error) {
+	qr, err := s.query(ctx, gorqlite.Statement{
+		Query:     "SELECT key, value, version, expires_at FROM " + s.table + " WHERE key = ? AND " + live,
+		Arguments: []interface{}{key, millis(time.Now())},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not get %s: %w", key, err)
+	}
+	entries, err := scanEntries(qr)
+	if err != nil {
+		return 0, fmt.Errorf("could not get %s: %w", key, err)
+	}
+	if len(entries) == 0 {
+		return 0, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if v != nil {
+		if err := entries[0].Decode(v); err != nil {
+			return 0, err
+		}
+	}
+	return entries[0].Version, nil
+}
+
+// prefixEnd returns the smallest key greater than all the keys starting with
+// the prefix, or "" if there is none.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// List returns the entries of the keys starting with the prefix, sorted by
+// key.
+func (s *Store) List(ctx context.Context, prefix string) ([]Entry, error) {
+	query := "SELECT key, value, version, expires_at FROM " + s.table + " WHERE key >= ? AND " + live
+	args := []interface{}{prefix, millis(time.Now())}
+	if end := prefixEnd(prefix); end != "" {
+		query += " AND key < ?"
+		args = append(args, end)
+	}
+	qr, err := s.query(ctx, gorqlite.Statement{Query: query + " ORDER BY key", Arguments: args})
+	if err != nil {
+		return nil, fmt.Errorf("could not list %s: %w", prefix, err)
+	}
+	entries, err := scanEntries(qr)
+	if err != nil {
+		return nil, fmt.Errorf("could not list %s: %w", prefix, err)
+	}
+	return entries, nil
+}
+
+// putOptions are the settings of a write, see PutOption.
+type putOptions struct {
+	ttl time.Duration
+}
+
+// PutOption configures a write.
+type PutOption func(*putOptions)
+
+// WithTTL makes the key expire after the given time. By default the keys
+// never expire.
+func WithTTL(ttl time.Duration) PutOption {
+	return func(o *putOptions) {
+		o.ttl = ttl
+	}
+}
+
+// expiry returns the expires_at value of a write.
+func expiry(opts []PutOption) interface{} {
+	var o putOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.ttl <= 0 {
+		return nil
+	}
+	return millis(time.Now().Add(o.ttl))
+}
+
+func encode(key string, value interface{}) (string, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("could not encode the value of %s: %w", key, err)
+	}
+	return string(b), nil
+}
+
+// putStatement returns the upsert of the key.
+func (s *Store) putStatement(key string, value interface{}, opts []PutOption) (gorqlite.Statement, error) {
+	encoded, err := encode(key, value)
+	if err != nil {
+		return gorqlite.Statement{}, err
+	}
+	return gorqlite.Statement{
+		Query: "INSERT INTO " + s.table + " (key, value, version, expires_at) VALUES (?, ?, 1, ?) " +
+			"ON CONFLICT (key) DO UPDATE SET value = excluded.value, version = version + 1, expires_at = excluded.expires_at",
+		Arguments: []interface{}{key, encoded, expiry(opts)},
+	}, nil
+}
+
+// deleteStatement returns the deletion of the key.
+func (s *Store) deleteStatement(key string) gorqlite.Statement {
+	return gorqlite.Statement{
+		Query:     "DELETE FROM " + s.table + " WHERE key = ?",
+		Arguments: []interface{}{key},
+	}
+}
+
+// Put sets the value of the key, encoded as JSON.
+func (s *Store) Put(ctx context.Context, key string, value interface{}, opts ...PutOption) error {
+	stmt, err := s.putStatement(key, value, opts)
+	if err != nil {
+		return err
+	}
+	if err := s.createTable(ctx); err != nil {
+		return err
+	}
+	if _, err := s.write(ctx, []gorqlite.Statement{stmt}); err != nil {
+		return fmt.Errorf("could not put %s: %w", key, err)
+	}
+	return nil
+}
+
+// CAS sets the value of the key, provided its version is still the given
+// one, as returned by Get or List. Version 0 stands for a key that doesn't
+// exist or expired. It fails with ErrConflict if the key changed.
+func (s *Store) CAS(ctx context.Context, key string, version int64, value interface{}, opts ...PutOption) error {
+	encoded, err := encode(key, value)
+	if err != nil {
+		return err
+	}
+	now := millis(time.Now())
+	var stmt gorqlite.Statement
+	if version == 0 {
+		stmt = gorqlite.Statement{
+			Query: "INSERT INTO " + s.table + " (key, value, version, expires_at) VALUES (?, ?, 1, ?) " +
+				"ON CONFLICT (key) DO UPDATE SET value = excluded.value, version = version + 1, expires_at = excluded.expires_at " +
+				"WHERE expires_at IS NOT NULL AND expires_at <= ?",
+			Arguments: []interface{}{key, encoded, expiry(opts), now},
+		}
+	} else {
+		stmt = gorqlite.Statement{
+			Query:     "UPDATE " + s.table + " SET value = ?, version = version + 1, expires_at = ? WHERE key = ? AND version = ? AND " + live,
+			Arguments: []interface{}{encoded, expiry(opts), key, version, now},
+		}
+	}
+	if err := s.createTable(ctx); err != nil {
+		return err
+	}
+	results, err := s.write(ctx, []gorqlite.Statement{stmt})
+	if err != nil {
+		return fmt.Errorf("could not put %s: %w", key, err)
+	}
+	if results[0].RowsAffected == 0 {
+		return fmt.Errorf("%w: %s is no longer at version %d", ErrConflict, key, version)
+	}
+	return nil
+}
+
+// Delete deletes the key. Deleting a key that doesn't exist isn't an error.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	if err := s.createTable(ctx); err != nil {
+		return err
+	}
+	if _, err := s.write(ctx, []gorqlite.Statement{s.deleteStatement(key)}); err != nil {
+		return fmt.Errorf("could not delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// Purge deletes the expired keys, which are otherwise only hidden, and
+// returns how many it deleted.
+func (s *Store) Purge(ctx context.Context) (int64, error) {
+	if err := s.createTable(ctx); err != nil {
+		return 0, err
+	}
+	results, err := s.write(ctx, []gorqlite.Statement{{
+		Query:     "DELETE FROM " + s.table + " WHERE expires_at <= ?",
+		Arguments: []interface{}{millis(time.Now())},
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("could not purge the expired keys: %w", err)
+	}
+	return results[0].RowsAffected, nil
+}
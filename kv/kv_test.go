@@ -0,0 +1,19 @@
+package kv
+
+import "testing"
+
+func TestPrefixEnd(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, want string
+	}{
+		{"", ""},
+		{"config/", "config0"},
+		{"a", "b"},
+		{"a\xff", "b"},
+		{"\xff\xff", ""},
+	} {
+		if got := prefixEnd(tc.prefix); got != tc.want {
+			t.Errorf("prefixEnd(%q): expected %q, got %q", tc.prefix, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+// Package lock implements leases stored in a rqlite table, e.g. for the
+// leader election of workers that only need rqlite to coordinate.
+//
+//	locks, err := lock.New(conn)
+//	...
+//	lease, err := locks.TryAcquire(ctx, "nightly-report", time.Minute)
+//	if errors.Is(err, lock.ErrLocked) {
+//		return // another worker is the leader
+//	}
+//	...
+//	defer lease.Release(ctx)
+//	for ... {
+//		if err := lease.Renew(ctx); err != nil {
+//			return err // no longer the leader
+//		}
+//		store.Write(lease.Token, ...)
+//	}
+//
+// Each acquisition of a lock increments its fencing token: the resources
+// protected by the lock can reject the writes made with an older token than
+// the last one they saw, e.g. from a holder that paused past the expiry of
+// its lease.
+//
+// The leases expire according to the clocks of the processes taking them,
+// which must be reasonably synchronized.
+package lock
+
+// this file contains the leases:
+//
+//   Manager, New() and its options
+//   Manager.Acquire() and TryAcquire() returning a Lease
+//   Lease.Renew() and Release()
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+var (
+	// ErrLocked is returned, wrapped, when the lock is held by another owner.
+	ErrLocked = errors.New("lock: held by another owner")
+
+	// ErrLeaseLost is returned, wrapped, when renewing or releasing a lease
+	// that expired and was taken by another owner.
+	ErrLeaseLost = errors.New("lock: lease lost")
+)
+
+// Manager takes the leases of the locks of a table.
+type Manager struct {
+	conn  *gorqlite.Connection
+	table string
+	owner string
+	poll  time.Duration
+
+	mu      sync.Mutex
+	created bool
+}
+
+// Option configures a Manager.
+type Option func(*Manager)
+
+// WithTable sets the table holding the locks, gorqlite_locks by default. It
+// is created when the first lock is taken.
+func WithTable(name string) Option {
+	return func(m *Manager) {
+		m.table = name
+	}
+}
+
+// WithOwner sets the owner recorded in the locks table, the host name and a
+// random suffix by default. Managers must not share their owner.
+func WithOwner(owner string) Option {
+	return func(m *Manager) {
+		m.owner = owner
+	}
+}
+
+// WithPoll sets how often Acquire tries a held lock again, 1 second by
+// default.
+func WithPoll(poll time.Duration) Option {
+	return func(m *Manager) {
+		m.poll = poll
+	}
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// New returns a Manager of the locks of the table. The manager reads and
+// writes with strong consistency, whatever the settings of the connection.
+func New(conn *gorqlite.Connection, opts ...Option) (*Manager, error) {
+	m := &Manager{
+		conn:  conn.With(gorqlite.CallConsistency(gorqlite.ConsistencyLevelStrong), gorqlite.CallTransaction(true)),
+		table: "gorqlite_locks",
+		poll:  time.Second,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if !identifier.MatchString(m.table) {
+		return nil, fmt.Errorf("invalid locks table name: %q", m.table)
+	}
+	if m.poll <= 0 {
+		return nil, errors.New("the poll interval must be positive")
+	}
+	if m.owner == "" {
+		var err error
+		m.owner, err = defaultOwner()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func defaultOwner() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%x", host, b), nil
+}
+
+// Owner returns the owner recorded in the locks taken by the manager.
+func (m *Manager) Owner() string {
+	return m.owner
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// Lease is a lock held by a Manager until it expires. A Lease is not safe for
+// concurrent use.
+type Lease struct {
+	m   *Manager
+	ttl time.Duration
+
+	Name    string
+	Token   int64     // the fencing token, incremented by each acquisition of the lock
+	Expires time.Time // when the lease expires unless renewed
+}
+
+// createStatement returns the creation of the locks table.
+func (m *Manager) createStatement() gorqlite.Statement {
+	return gorqlite.Statement{
+		Query: "CREATE TABLE IF NOT EXISTS " + m.table + " (name TEXT PRIMARY KEY, owner TEXT NOT NULL, token INTEGER NOT NULL, expires_at INTEGER NOT NULL)",
+	}
+}
+
+// acquireStatements returns the statements taking the lock until expires if
+// it is free or expired at now, then reading it back.
+func (m *Manager) acquireStatements(name string, now, expires time.Time) []gorqlite.Statement {
+	return []gorqlite.Statement{
+		{
+			Query: "INSERT INTO " + m.table + " (name, owner, token, expires_at) VALUES (?, ?, 1, ?) " +
+				"ON CONFLICT (name) DO UPDATE SET owner = excluded.owner, token = token + 1, expires_at = excluded.expires_at " +
+				"WHERE expires_at < ?",
+			Arguments: []interface{}{name, m.owner, millis(expires), millis(now)},
+		},
+		{
+			Query:     "SELECT owner, token, expires_at FROM " + m.table + " WHERE name = ?",
+			Arguments: []interface{}{name},
+		},
+	}
+}
+
+// Acquire takes the lock for ttl, waiting for it until the context is done.
+func (m *Manager) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	for {
+		lease, err := m.TryAcquire(ctx, name, ttl)
+		if !errors.Is(err, ErrLocked) {
+			return lease, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %v", err, ctx.Err())
+		case <-time.After(m.poll):
+		}
+	}
+}
+
+// TryAcquire takes the lock for ttl if it is free or expired, or fails with
+// ErrLocked. A lock isn't reentrant: the manager holding it can't take it
+// again before releasing it.
+func (m *Manager) TryAcquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	if ttl <= 0 {
+		return nil, errors.New("the lease TTL must be positive")
+	}
+
+	var stmts []gorqlite.Statement
+	m.mu.Lock()
+	create := !m.created
+	m.mu.Unlock()
+	if create {
+		stmts = append(stmts, m.createStatement())
+	}
+	now := time.Now()
+	expires := now.Add(ttl)
+	stmts = append(stmts, m.acquireStatements(name, now, expires)...)
+
+	results, err := m.conn.RequestParameterizedContext(ctx, stmts)
+	if err != nil {
+		if len(results) == len(stmts) {
+			for i, rr := range results {
+				if rr.Err != nil {
+					return nil, fmt.Errorf("could not take lock %s: statement %q: %w", name, stmts[i].Query, rr.Err)
+				}
+			}
+		}
+		return nil, fmt.Errorf("could not take lock %s: %w", name, err)
+	}
+	if len(results) != len(stmts) {
+		return nil, fmt.Errorf("could not take lock %s: expected %d results, got %d", name, len(stmts), len(results))
+	}
+	if create {
+		m.mu.Lock()
+		m.created = true
+		m.mu.Unlock()
+	}
+
+	acquired := results[len(results)-2].Write.RowsAffected == 1
+	qr := results[len(results)-1].Query
+	var owner string
+	var token, expiresAt int64
+	if !qr.Next() {
+		return nil, fmt.Errorf("could not take lock %s: the lock is missing", name)
+	}
+	if err := qr.Scan(&owner, &token, &expiresAt); err != nil {
+		return nil, fmt.Errorf("could not take lock %s: %w", name, err)
+	}
+	if !acquired {
+		return nil, fmt.Errorf("%w: %s held by %s until %s", ErrLocked, name, owner, fromMillis(expiresAt).Format(time.RFC3339))
+	}
+	return &Lease{
+		m:       m,
+		ttl:     ttl,
+		Name:    name,
+		Token:   token,
+		Expires: expires,
+	}, nil
+}
+
+// renewStatement returns the update extending the lease until expires,
+// provided the lock is still held with its token.
+func (l *Lease) renewStatement(expires time.Time) gorqlite.Statement {
+	return gorqlite.Statement{
+		Query:     "UPDATE " + l.m.table + " SET expires_at = ? WHERE name = ? AND owner = ? AND token = ?",
+		Arguments: []interface{}{millis(expires), l.Name, l.m.owner, l.Token},
+	}
+}
+
+// releaseStatement returns the update expiring the lease, provided the lock
+// is still held with its token.
+func (l *Lease) releaseStatement() gorqlite.Statement {
+	return gorqlite.Statement{
+		Query:     "UPDATE " + l.m.table + " SET expires_at = 0 WHERE name = ? AND owner = ? AND token = ?",
+		Arguments: []interface{}{l.Name, l.m.owner, l.Token},
+	}
+}
+
+// Renew extends the lease by its TTL. It fails with ErrLeaseLost if the lock
+// was taken by another owner since; the lease remains valid if it expired
+// but no one took the lock.
+func (l *Lease) Renew(ctx context.Context) error {
+	expires := time.Now().Add(l.ttl)
+	wr, err := l.m.conn.WriteOneParameterizedContext(ctx, l.renewStatement(expires))
+	if err != nil {
+		return fmt.Errorf("could not renew lock %s: %w", l.Name, err)
+	}
+	if wr.RowsAffected != 1 {
+		return fmt.Errorf("%w: %s with token %d", ErrLeaseLost, l.Name, l.Token)
+	}
+	l.Expires = expires
+	return nil
+}
+
+// Release releases the lease, so that others can take the lock without
+// waiting for its expiry. The lock is expired rather than deleted, to keep
+// its fencing token. It fails with ErrLeaseLost if the lock was taken by
+// another owner since.
+func (l *Lease) Release(ctx context.Context) error {
+	wr, err := l.m.conn.WriteOneParameterizedContext(ctx, l.releaseStatement())
+	if err != nil {
+		return fmt.Errorf("could not release lock %s: %w", l.Name, err)
+	}
+	if wr.RowsAffected != 1 {
+		return fmt.Errorf("%w: %s with token %d", ErrLeaseLost, l.Name, l.Token)
+	}
+	l.Expires = time.Time{}
+	return nil
+}
@@ -0,0 +1,72 @@
+package lock
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+func TestNew(t *testing.T) {
+	conn := &gorqlite.Connection{}
+
+	m, err := New(conn)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if m.table != "gorqlite_locks" || m.poll != time.Second {
+		t.Errorf("unexpected defaults: table %q, poll %s", m.table, m.poll)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	if !regexp.MustCompile(`^` + regexp.QuoteMeta(host) + `-[0-9a-f]{8}$`).MatchString(m.Owner()) {
+		t.Errorf("expected the default owner to be the host name and a random suffix, got %q", m.Owner())
+	}
+	other, err := New(conn)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if other.Owner() == m.Owner() {
+		t.Errorf("expected the default owners to differ, got %q twice", m.Owner())
+	}
+
+	m, err = New(conn, WithTable("locks"), WithOwner("worker-1"), WithPoll(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if m.table != "locks" || m.Owner() != "worker-1" || m.poll != 10*time.Millisecond {
+		t.Errorf("options not applied: table %q, owner %q, poll %s", m.table, m.Owner(), m.poll)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"empty table name", []Option{WithTable("")}},
+		{"invalid table name", []Option{WithTable("locks; DROP TABLE users")}},
+		{"table name starting with a digit", []Option{WithTable("1locks")}},
+		{"zero poll", []Option{WithPoll(0)}},
+		{"negative poll", []Option{WithPoll(-time.Second)}},
+	} {
+		if _, err := New(conn, tc.opts...); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestTryAcquireInvalidTTL(t *testing.T) {
+	m, err := New(&gorqlite.Connection{}, WithOwner("worker-1"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		if _, err := m.TryAcquire(context.Background(), "job", ttl); err == nil {
+			t.Errorf("TryAcquire with ttl %s: expected an error", ttl)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package migrate
+
+// this file contains the cluster-wide advisory lock of the migrator, a single
+// row of the lock table that expires unless renewed.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+// ErrLockLost is returned when the lock expired and was taken by another
+// owner while migrating.
+var ErrLockLost = errors.New("migrate: lock lost")
+
+func (m *Migrator) lockTable() string {
+	return m.table + "_lock"
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// lock takes the lock, waiting for it until the context is done, and returns
+// the function releasing it.
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	for {
+		now := time.Now()
+		wr, err := m.conn.WriteOneParameterizedContext(ctx, gorqlite.Statement{
+			Query: "INSERT INTO " + m.lockTable() + " (id, owner, expires_at) VALUES (1, ?, ?) " +
+				"ON CONFLICT (id) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at " +
+				"WHERE expires_at < ? OR owner = excluded.owner",
+			Arguments: []interface{}{m.owner, millis(now.Add(m.lockTTL)), millis(now)},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not take the migration lock: %w", err)
+		}
+		if wr.RowsAffected == 1 {
+			return m.release, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("could not take the migration lock, held by another process: %w", ctx.Err())
+		case <-time.After(m.lockPoll):
+		}
+	}
+}
+
+// renew extends the lock by its TTL.
+func (m *Migrator) renew(ctx context.Context) error {
+	wr, err := m.conn.WriteOneParameterizedContext(ctx, gorqlite.Statement{
+		Query:     "UPDATE " + m.lockTable() + " SET expires_at = ? WHERE id = 1 AND owner = ?",
+		Arguments: []interface{}{millis(time.Now().Add(m.lockTTL)), m.owner},
+	})
+	if err != nil {
+		return fmt.Errorf("could not renew the migration lock: %w", err)
+	}
+	if wr.RowsAffected != 1 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// release releases the lock. Failures are ignored: the lock then expires
+// after its TTL.
+func (m *Migrator) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _ = m.conn.WriteOneParameterizedContext(ctx, gorqlite.Statement{
+		Query:     "DELETE FROM " + m.lockTable() + " WHERE id = 1 AND owner = ?",
+		Arguments: []interface{}{m.owner},
+	})
+}
@@ -0,0 +1,365 @@
+// Package migrate applies versioned SQL migrations to a rqlite cluster.
+//
+// The migrations are read from an fs.FS, typically embedded in the binary:
+//
+//	//go:embed migrations/*.sql
+//	var files embed.FS
+//
+//	dir, _ := fs.Sub(files, "migrations")
+//	m, err := migrate.New(conn, dir)
+//	...
+//	applied, err := m.Up(ctx)
+//
+// Each migration runs in its own transaction, together with the insertion of
+// its record in the schema_migrations table. A cluster-wide advisory lock,
+// held in the schema_migrations_lock table, makes sure that only one process
+// migrates at a time, e.g. when all the replicas of a service start at once.
+package migrate
+
+// this file contains the migrator:
+//
+//   Migrator, New() and its options
+//   Migrator.Up(), Down(), Pending() and Applied()
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eluv-io/gorqlite"
+)
+
+var (
+	// ErrChecksumMismatch is returned, wrapped, when an applied migration was
+	// modified since it was applied.
+	ErrChecksumMismatch = errors.New("migrate: checksum mismatch")
+
+	// ErrIrreversible is returned, wrapped, when rolling back a migration
+	// without down file, or unknown to the migrator.
+	ErrIrreversible = errors.New("migrate: irreversible migration")
+)
+
+// Record is the record of an applied migration.
+type Record struct {
+	Version   uint64
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Migrator applies the migrations to a cluster.
+type Migrator struct {
+	conn       *gorqlite.Connection
+	migrations []Migration
+
+	table    string
+	lockTTL  time.Duration
+	lockPoll time.Duration
+	owner    string
+	dryRun   bool
+}
+
+// Option configures a Migrator.
+type Option func(*Migrator)
+
+// WithTable sets the table recording the applied migrations, schema_migrations
+// by default. The lock is held in the table of the same name suffixed with
+// _lock.
+func WithTable(name string) Option {
+	return func(m *Migrator) {
+		m.table = name
+	}
+}
+
+// WithLockTTL sets how long the lock is held without being renewed, 5 minutes
+// by default. The lock is renewed after each migration: the TTL must exceed
+// the time taken by the longest migration.
+func WithLockTTL(ttl time.Duration) Option {
+	return func(m *Migrator) {
+		m.lockTTL = ttl
+	}
+}
+
+// WithLockPoll sets how often a held lock is tried again, 1 second by
+// default.
+func WithLockPoll(poll time.Duration) Option {
+	return func(m *Migrator) {
+		m.lockPoll = poll
+	}
+}
+
+// WithOwner sets the owner recorded in the lock table, the host name and a
+// random suffix by default.
+func WithOwner(owner string) Option {
+	return func(m *Migrator) {
+		m.owner = owner
+	}
+}
+
+// WithDryRun makes Up and Down only return the migrations they would apply
+// or roll back, without changing anything.
+func WithDryRun() Option {
+	return func(m *Migrator) {
+		m.dryRun = true
+	}
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// New returns a Migrator for the migrations of the root directory of fsys,
+// see Load(). The migrator reads and writes with strong consistency, whatever
+// the settings of the connection.
+func New(conn *gorqlite.Connection, fsys fs.FS, opts ...Option) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Migrator{
+		conn:       conn.With(gorqlite.CallConsistency(gorqlite.ConsistencyLevelStrong), gorqlite.CallTransaction(true)),
+		migrations: migrations,
+		table:      "schema_migrations",
+		lockTTL:    5 * time.Minute,
+		lockPoll:   time.Second,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if !identifier.MatchString(m.table) {
+		return nil, fmt.Errorf("invalid migrations table name: %q", m.table)
+	}
+	if m.lockTTL <= 0 || m.lockPoll <= 0 {
+		return nil, errors.New("the lock TTL and poll interval must be positive")
+	}
+	if m.owner == "" {
+		m.owner, err = defaultOwner()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func defaultOwner() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%x", host, b), nil
+}
+
+// Migrations returns the migrations read by the migrator, sorted by version.
+func (m *Migrator) Migrations() []Migration {
+	return append([]Migration{}, m.migrations...)
+}
+
+// Applied returns the records of the applied migrations, sorted by version.
+func (m *Migrator) Applied(ctx context.Context) ([]Record, error) {
+	qr, err := m.conn.QueryOneContext(ctx,
+		"SELECT version, name, checksum, applied_at FROM "+m.table+" ORDER BY version")
+	if err != nil {
+		if noSuchTable(err, qr.Err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not read the applied migrations: %w", err)
+	}
+
+	var records []Record
+	for qr.Next() {
+		var r Record
+		var version, appliedAt int64
+		if err := qr.Scan(&version, &r.Name, &r.Checksum, &appliedAt); err != nil {
+			return nil, fmt.Errorf("could not read the applied migrations: %w", err)
+		}
+		r.Version = uint64(version)
+		r.AppliedAt = time.Unix(0, appliedAt*int64(time.Millisecond))
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// Pending returns the migrations that are not applied yet, sorted by
+// version. It fails with ErrChecksumMismatch if an applied migration was
+// modified.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	records, err := m.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.pending(records)
+}
+
+func (m *Migrator) pending(records []Record) ([]Migration, error) {
+	applied := make(map[uint64]Record, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	var pending []Migration
+	for _, mig := range m.migrations {
+		r, ok := applied[mig.Version]
+		if !ok {
+			pending = append(pending, mig)
+			continue
+		}
+		if r.Checksum != mig.Checksum {
+			return nil, fmt.Errorf("%w: migration %d %s was modified after it was applied", ErrChecksumMismatch, mig.Version, mig.Name)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies the pending migrations in order, holding the lock, and returns
+// them. Pending migrations older than the last applied one are applied too.
+// It stops at the first migration that fails, which is rolled back.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	if m.dryRun {
+		return m.Pending(ctx)
+	}
+	if err := m.createTables(ctx); err != nil {
+		return nil, err
+	}
+	release, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, mig := range pending {
+		stmts := make([]gorqlite.Statement, 0, len(mig.Up)+1)
+		for _, q := range mig.Up {
+			stmts = append(stmts, gorqlite.Statement{Query: q})
+		}
+		stmts = append(stmts, gorqlite.Statement{
+			Query:     "INSERT INTO " + m.table + " (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+			Arguments: []interface{}{mig.Version, mig.Name, mig.Checksum, millis(time.Now())},
+		})
+		if err := m.write(ctx, stmts); err != nil {
+			return applied, fmt.Errorf("migration %d %s failed: %w", mig.Version, mig.Name, err)
+		}
+		applied = append(applied, mig)
+		if err := m.renew(ctx); err != nil {
+			return applied, err
+		}
+	}
+	return applied, nil
+}
+
+// Down rolls back the last steps applied migrations, latest first, holding
+// the lock, and returns them. It fails with ErrIrreversible if one of them
+// has no down file. Zero steps roll back nothing, and a negative number of
+// steps is an error.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps < 0 {
+		return nil, fmt.Errorf("invalid number of steps to roll back: %d", steps)
+	}
+	if steps == 0 {
+		return nil, nil
+	}
+	if !m.dryRun {
+		if err := m.createTables(ctx); err != nil {
+			return nil, err
+		}
+		release, err := m.lock(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	records, err := m.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Version > records[j].Version })
+	if steps < len(records) {
+		records = records[:steps]
+	}
+	known := make(map[uint64]Migration, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = mig
+	}
+	var todo []Migration
+	for _, r := range records {
+		mig, ok := known[r.Version]
+		if !ok || mig.Down == nil {
+			return nil, fmt.Errorf("%w: migration %d %s has no down file", ErrIrreversible, r.Version, r.Name)
+		}
+		todo = append(todo, mig)
+	}
+	if m.dryRun {
+		return todo, nil
+	}
+
+	var rolledBack []Migration
+	for _, mig := range todo {
+		stmts := make([]gorqlite.Statement, 0, len(mig.Down)+1)
+		for _, q := range mig.Down {
+			stmts = append(stmts, gorqlite.Statement{Query: q})
+		}
+		stmts = append(stmts, gorqlite.Statement{
+			Query:     "DELETE FROM " + m.table + " WHERE version = ?",
+			Arguments: []interface{}{mig.Version},
+		})
+		if err := m.write(ctx, stmts); err != nil {
+			return rolledBack, fmt.Errorf("rollback of migration %d %s failed: %w", mig.Version, mig.Name, err)
+		}
+		rolledBack = append(rolledBack, mig)
+		if err := m.renew(ctx); err != nil {
+			return rolledBack, err
+		}
+	}
+	return rolledBack, nil
+}
+
+// createTables creates the migrations and lock tables if needed.
+func (m *Migrator) createTables(ctx context.Context) error {
+	return m.write(ctx, []gorqlite.Statement{
+		{Query: "CREATE TABLE IF NOT EXISTS " + m.table + " (version INTEGER PRIMARY KEY, name TEXT NOT NULL, checksum TEXT NOT NULL, applied_at INTEGER NOT NULL)"},
+		{Query: "CREATE TABLE IF NOT EXISTS " + m.lockTable() + " (id INTEGER PRIMARY KEY CHECK (id = 1), owner TEXT NOT NULL, expires_at INTEGER NOT NULL)"},
+	})
+}
+
+// write executes the statements in a transaction, returning the error of
+// the first failed statement.
+func (m *Migrator) write(ctx context.Context, stmts []gorqlite.Statement) error {
+	results, err := m.conn.WriteParameterizedContext(ctx, stmts)
+	if err == nil {
+		return nil
+	}
+	if len(results) != len(stmts) {
+		// the call itself failed
+		return err
+	}
+	for i, wr := range results {
+		if wr.Err != nil {
+			return fmt.Errorf("statement %q: %w", stmts[i].Query, wr.Err)
+		}
+	}
+	return err
+}
+
+// noSuchTable tells whether a query failed because its table doesn't exist.
+func noSuchTable(errs ...error) bool {
+	for _, err := range errs {
+		if err != nil && strings.Contains(err.Error(), "no such table") {
+			return true
+		}
+	}
+	return false
+}
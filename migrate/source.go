@@ -0,0 +1,219 @@
+package migrate
+
+// this file contains the loading of the migrations:
+//
+//   Migration and Load() reading them from an fs.FS
+//   SplitStatements() splitting a SQL file into statements
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Migration is a versioned schema change, read from a pair of files named
+// <version>_<name>.up.sql and <version>_<name>.down.sql, e.g.
+// 0001_create_users.up.sql. The down file is optional.
+type Migration struct {
+	Version  uint64
+	Name     string
+	Up       []string // the statements of the up file
+	Down     []string // the statements of the down file, nil if there is none
+	Checksum string   // hex SHA-256 of the up file
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load reads the migrations from the root directory of fsys, sorted by
+// version. Files that don't end in .sql are ignored; .sql files that don't
+// follow the naming scheme are an error.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint64]*Migration)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		parts := fileName.FindStringSubmatch(e.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("invalid migration file name %q, expected <version>_<name>.up.sql or .down.sql", e.Name())
+		}
+		version, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q: %v", e.Name(), err)
+		}
+		b, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = mig
+		} else if mig.Name != parts[2] {
+			return nil, fmt.Errorf("migration %d is named both %q and %q", version, mig.Name, parts[2])
+		}
+
+		stmts := SplitStatements(string(b))
+		if parts[3] == "up" {
+			mig.Up = stmts
+			mig.Checksum = fmt.Sprintf("%x", sha256.Sum256(b))
+		} else {
+			mig.Down = append([]string{}, stmts...) // not nil, even if empty
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Checksum == "" {
+			return nil, fmt.Errorf("migration %d %s has no up file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// SplitStatements splits SQL text into statements on the semicolons that are
+// not part of a string, an identifier, a comment or the body of a trigger.
+// Empty statements are dropped.
+func SplitStatements(sql string) []string {
+	var stmts []string
+	start := 0
+	depth := 0 // BEGIN ... END nesting, within CREATE TRIGGER
+	trigger := false
+
+	flush := func(end int) {
+		stmt := strings.TrimSpace(sql[start:end])
+		if stmt != "" && !onlyComments(stmt) {
+			stmts = append(stmts, stmt)
+		}
+		start = end + 1
+		depth = 0
+		trigger = false
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			// quoted string or identifier, doubled quotes escape themselves
+			for i++; i < len(sql); i++ {
+				if sql[i] == c {
+					if i+1 < len(sql) && sql[i+1] == c {
+						i++
+						continue
+					}
+					break
+				}
+			}
+		case c == '[':
+			if j := strings.IndexByte(sql[i:], ']'); j >= 0 {
+				i += j
+			}
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			if j := strings.IndexByte(sql[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				i = len(sql)
+			}
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			if j := strings.Index(sql[i+2:], "*/"); j >= 0 {
+				i += j + 3
+			} else {
+				i = len(sql)
+			}
+		case isWordStart(sql, i):
+			j := i
+			for j < len(sql) && isWordChar(sql[j]) {
+				j++
+			}
+			switch strings.ToUpper(sql[i:j]) {
+			case "TRIGGER":
+				if isCreate(sql[start:i]) {
+					trigger = true
+				}
+			case "BEGIN", "CASE":
+				if trigger {
+					depth++
+				}
+			case "END":
+				if trigger && depth > 0 {
+					depth--
+				}
+			}
+			i = j - 1
+		case c == ';':
+			if !trigger || depth == 0 {
+				flush(i)
+			}
+		}
+	}
+	if start < len(sql) {
+		flush(len(sql))
+	}
+	return stmts
+}
+
+// isCreate tells whether the text preceding TRIGGER starts a CREATE TRIGGER,
+// e.g. "CREATE TEMP " or "create ".
+func isCreate(prefix string) bool {
+	fields := strings.Fields(strings.ToUpper(stripComments(prefix)))
+	if len(fields) == 0 || fields[0] != "CREATE" {
+		return false
+	}
+	for _, f := range fields[1:] {
+		if f != "TEMP" && f != "TEMPORARY" {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c < 0x80 && (unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)))
+}
+
+func isWordStart(s string, i int) bool {
+	return isWordChar(s[i]) && (i == 0 || !isWordChar(s[i-1]))
+}
+
+// stripComments removes the comments of SQL text without strings.
+func stripComments(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '-' && i+1 < len(s) && s[i+1] == '-':
+			j := strings.IndexByte(s[i:], '\n')
+			if j < 0 {
+				return b.String()
+			}
+			i += j
+			b.WriteByte('\n')
+		case s[i] == '/' && i+1 < len(s) && s[i+1] == '*':
+			j := strings.Index(s[i+2:], "*/")
+			if j < 0 {
+				return b.String()
+			}
+			i += j + 3
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func onlyComments(stmt string) bool {
+	return strings.TrimSpace(stripComments(stmt)) == ""
+}
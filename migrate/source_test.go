@@ -0,0 +1,84 @@
+package migrate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSplitStatements(t *testing.T) {
+	for _, tc := range []struct {
+		sql  string
+		want []string
+	}{
+		{"", nil},
+		{"CREATE TABLE a (id INTEGER);", []string{"CREATE TABLE a (id INTEGER)"}},
+		{"INSERT INTO a VALUES ('x;y'); INSERT INTO a VALUES ('it''s')",
+			[]string{"INSERT INTO a VALUES ('x;y')", "INSERT INTO a VALUES ('it''s')"}},
+		{"-- a comment; still a comment\nSELECT 1; /* ; */ SELECT 2;\n-- trailing",
+			[]string{"-- a comment; still a comment\nSELECT 1", "/* ; */ SELECT 2"}},
+		{`CREATE TABLE "a;b" ([c;d] TEXT);`, []string{`CREATE TABLE "a;b" ([c;d] TEXT)`}},
+		{"CREATE TRIGGER t AFTER INSERT ON a BEGIN UPDATE b SET n = CASE WHEN n > 0 THEN n END; DELETE FROM c; END; SELECT 1",
+			[]string{"CREATE TRIGGER t AFTER INSERT ON a BEGIN UPDATE b SET n = CASE WHEN n > 0 THEN n END; DELETE FROM c; END", "SELECT 1"}},
+		{"CREATE TABLE triggers (begin_at INTEGER); SELECT 1", []string{"CREATE TABLE triggers (begin_at INTEGER)", "SELECT 1"}},
+	} {
+		if got := SplitStatements(tc.sql); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("SplitStatements(%q):\n got  %q\n want %q", tc.sql, got, tc.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD COLUMN email TEXT;")},
+		"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);")},
+		"0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"README.md":                  {Data: []byte("not a migration")},
+	}
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("expected 2 migrations, got %+v", migrations)
+	}
+	first, second := migrations[0], migrations[1]
+	if first.Version != 1 || first.Name != "create_users" || len(first.Up) != 1 || len(first.Down) != 1 || first.Checksum == "" {
+		t.Errorf("unexpected first migration: %+v", first)
+	}
+	if second.Version != 2 || second.Down != nil {
+		t.Errorf("unexpected second migration: %+v", second)
+	}
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"bad name":     {"create_users.sql": {Data: []byte("SELECT 1")}},
+		"no up file":   {"0001_a.down.sql": {Data: []byte("SELECT 1")}},
+		"name clashes": {"0001_a.up.sql": {Data: []byte("SELECT 1")}, "0001_b.down.sql": {Data: []byte("SELECT 1")}},
+	} {
+		if _, err := Load(fsys); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPending(t *testing.T) {
+	m := &Migrator{migrations: []Migration{
+		{Version: 1, Name: "a", Checksum: "c1"},
+		{Version: 2, Name: "b", Checksum: "c2"},
+		{Version: 3, Name: "c", Checksum: "c3"},
+	}}
+
+	pending, err := m.pending([]Record{{Version: 2, Checksum: "c2"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pending) != 2 || pending[0].Version != 1 || pending[1].Version != 3 {
+		t.Errorf("expected migrations 1 and 3 to be pending, got %+v", pending)
+	}
+
+	_, err = m.pending([]Record{{Version: 1, Checksum: "modified"}})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
@@ -3,6 +3,8 @@ package gorqlite
 import (
 	"bytes"
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,43 +13,92 @@ import (
 	"time"
 )
 
+// Null represents a value of type T that may be null, e.g. Null[string] or
+// Null[time.Time]. T can be any type that Scan supports.
+type Null[T any] struct {
+	V     T
+	Valid bool // Valid is true if V is not NULL
+}
+
+// nullable is implemented by the pointers to Null[T], for Scan.
+type nullable interface {
+	setNull()
+	valuePtr() interface{}
+	setValid()
+}
+
+func (n *Null[T]) setNull() {
+	*n = Null[T]{}
+}
+
+func (n *Null[T]) valuePtr() interface{} {
+	return &n.V
+}
+
+func (n *Null[T]) setValid() {
+	n.Valid = true
+}
+
+// Value implements driver.Valuer, so that a Null[T] argument is sent as
+// NULL when not valid.
+func (n Null[T]) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.V, nil
+}
+
 // NullString represents a string that may be null.
+//
+// Deprecated: use Null[string].
 type NullString struct {
 	String string
 	Valid  bool // Valid is true if String is not NULL
 }
 
 // NullInt64 represents an int64 that may be null.
+//
+// Deprecated: use Null[int64].
 type NullInt64 struct {
 	Int64 int64
 	Valid bool // Valid is true if Int64 is not NULL
 }
 
 // NullInt32 represents an int32 that may be null.
+//
+// Deprecated: use Null[int32].
 type NullInt32 struct {
 	Int32 int32
 	Valid bool // Valid is true if Int32 is not NULL
 }
 
 // NullInt16 represents an int16 that may be null.
+//
+// Deprecated: use Null[int16].
 type NullInt16 struct {
 	Int16 int16
 	Valid bool // Valid is true if Int16 is not NULL
 }
 
 // NullFloat64 represents a float64 that may be null.
+//
+// Deprecated: use Null[float64].
 type NullFloat64 struct {
 	Float64 float64
 	Valid   bool // Valid is true if Float64 is not NULL
 }
 
 // NullBool represents a bool that may be null.
+//
+// Deprecated: use Null[bool].
 type NullBool struct {
 	Bool  bool
 	Valid bool // Valid is true if Bool is not NULL
 }
 
 // NullTime represents a time.Time that may be null.
+//
+// Deprecated: use Null[time.Time].
 type NullTime struct {
 	Time  time.Time
 	Valid bool // Valid is true if Time is not NULL
@@ -135,8 +186,11 @@ type NullTime struct {
 
  * *****************************************************************/
 
-func (conn *Connection) QueryStmt(ctx context.Context, sqlStatements ...*Statement) (results []QueryResult, err error) {
-	return conn.QueryParameterizedContext(ctx, makeParameterizedStatements(sqlStatements))
+// QueryStmt is used to perform SELECT operations with the given statements.
+//
+// The call options override the settings of the connection for this call only.
+func (conn *Connection) QueryStmt(ctx context.Context, sqlStatements []*Statement, opts ...CallOption) (results []QueryResult, err error) {
+	return conn.QueryParameterizedContext(ctx, makeParameterizedStatements(sqlStatements), opts...)
 }
 
 // QueryOne wraps Query into a single-statement method.
@@ -151,11 +205,11 @@ func (conn *Connection) QueryOne(sqlStatement string) (qr QueryResult, err error
 }
 
 // QueryOneContext wraps Query into a single-statement method.
-func (conn *Connection) QueryOneContext(ctx context.Context, sqlStatement string) (qr QueryResult, err error) {
+func (conn *Connection) QueryOneContext(ctx context.Context, sqlStatement string, opts ...CallOption) (qr QueryResult, err error) {
 	sqlStatements := make([]string, 0)
 	sqlStatements = append(sqlStatements, sqlStatement)
 
-	qra, err := conn.QueryContext(ctx, sqlStatements)
+	qra, err := conn.QueryContext(ctx, sqlStatements, opts...)
 	return qra[0], err
 }
 
@@ -169,8 +223,8 @@ func (conn *Connection) QueryOneParameterized(statement ParameterizedStatement)
 }
 
 // QueryOneParameterizedContext wraps QueryParameterizedContext into a single-statement method.
-func (conn *Connection) QueryOneParameterizedContext(ctx context.Context, statement ParameterizedStatement) (qr QueryResult, err error) {
-	qra, err := conn.QueryParameterizedContext(ctx, []ParameterizedStatement{statement})
+func (conn *Connection) QueryOneParameterizedContext(ctx context.Context, statement ParameterizedStatement, opts ...CallOption) (qr QueryResult, err error) {
+	qra, err := conn.QueryParameterizedContext(ctx, []ParameterizedStatement{statement}, opts...)
 	return qra[0], err
 }
 
@@ -184,7 +238,9 @@ func (conn *Connection) Query(sqlStatements []string) (results []QueryResult, er
 
 // QueryContext is used to perform SELECT operations in the database. It takes an array of SQL statements and
 // executes them in a single transaction, returning an array of QueryResult.
-func (conn *Connection) QueryContext(ctx context.Context, sqlStatements []string) (results []QueryResult, err error) {
+//
+// The call options override the settings of the connection for this call only.
+func (conn *Connection) QueryContext(ctx context.Context, sqlStatements []string, opts ...CallOption) (results []QueryResult, err error) {
 	parameterizedStatements := make([]ParameterizedStatement, 0, len(sqlStatements))
 	for _, sqlStatement := range sqlStatements {
 		parameterizedStatements = append(parameterizedStatements, ParameterizedStatement{
@@ -192,7 +248,7 @@ func (conn *Connection) QueryContext(ctx context.Context, sqlStatements []string
 		})
 	}
 
-	return conn.QueryParameterizedContext(ctx, parameterizedStatements)
+	return conn.QueryParameterizedContext(ctx, parameterizedStatements, opts...)
 }
 
 // QueryParameterized is used to perform SELECT operations in the database.
@@ -209,14 +265,22 @@ func (conn *Connection) QueryParameterized(sqlStatements []ParameterizedStatemen
 //
 // It takes an array of parameterized SQL statements and executes them in a single transaction,
 // returning an array of QueryResult vars.
-func (conn *Connection) QueryParameterizedContext(ctx context.Context, sqlStatements []ParameterizedStatement) (results []QueryResult, err error) {
+//
+// The call options override the settings of the connection for this call only.
+func (conn *Connection) QueryParameterizedContext(ctx context.Context, sqlStatements []ParameterizedStatement, opts ...CallOption) (results []QueryResult, err error) {
 	results = make([]QueryResult, 0)
 
-	if conn.hasBeenClosed {
+	if conn.isClosed() {
 		results = append(results, QueryResult{Err: ErrClosed})
 		return results, ErrClosed
 	}
 
+	conn, err = conn.withCallOptions(opts)
+	if err != nil {
+		results = append(results, QueryResult{Err: err})
+		return results, err
+	}
+
 	trace("%s: Query() for %d statements", conn.ID, len(sqlStatements))
 
 	// stop we get an error POSTing
@@ -523,8 +587,14 @@ func toTime(src interface{}) (time.Time, error) {
 //	float64: for JSON numbers,
 //	int: as extension of float64 or after conversion from a source string,
 //	int64: as an extension of float64 or after conversion from a source string,
+//	int32, int16: as int64,
 //	string: for JSON strings,
-//	nil: for JSON null
+//	nil: for JSON null, which leaves the variable untouched, including the
+//		deprecated NullString, NullInt64... types
+//	Null[T]: for any of the above that may be null, e.g. Null[string], made
+//		invalid by a JSON null
+//	sql.Scanner: e.g. sql.NullString, sql.NullTime or uuid types, given the
+//		value as is, or as a time.Time if they reject a date or time
 //
 // JSON arrays, and JSON objects are not supported since sqlite does not support them.
 func (qr *QueryResult) Scan(dest ...interface{}) error {
@@ -540,237 +610,241 @@ func (qr *QueryResult) Scan(dest ...interface{}) error {
 
 	thisRowValues := qr.values[qr.rowNumber].([]interface{})
 	for n, d := range dest {
-		src := thisRowValues[n]
+		if err := qr.scanValue(n, thisRowValues[n], d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanAs scans the value of column n into a T.
+func scanAs[T any](qr *QueryResult, n int, src interface{}) (T, error) {
+	var v T
+	err := qr.scanValue(n, src, &v)
+	return v, err
+}
+
+// scanScanner passes the value of column n to a sql.Scanner as is, then as a
+// time.Time if the scanner rejects a string, or the value of a date or time
+// column, that is one.
+func (qr *QueryResult) scanScanner(n int, src interface{}, d sql.Scanner) error {
+	err := d.Scan(src)
+	if err == nil {
+		return nil
+	}
+	_, isString := src.(string)
+	if isString || src != nil && n < len(qr.types) && (strings.Contains(qr.types[n], "date") || strings.Contains(qr.types[n], "time")) {
+		if t, terr := toTime(src); terr == nil && d.Scan(t) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("scanning col:%d into %T: %w", n, d, err)
+}
+
+// scanValue scans the value of column n into the destination.
+func (qr *QueryResult) scanValue(n int, src interface{}, dest interface{}) error {
+	switch d := dest.(type) {
+	case nullable:
 		if src == nil {
-			trace("%s: skipping nil scan data for variable #%d (%s)", qr.ID, n, qr.columns[n])
-			continue
+			d.setNull()
+			return nil
 		}
-		switch d := d.(type) {
-		case *time.Time:
-			if src == nil {
-				continue
-			}
-			t, err := toTime(src)
+		if err := qr.scanValue(n, src, d.valuePtr()); err != nil {
+			return err
+		}
+		d.setValid()
+		return nil
+	case sql.Scanner:
+		return qr.scanScanner(n, src, d)
+	}
+
+	if src == nil {
+		trace("%s: skipping nil scan data for variable #%d (%s)", qr.ID, n, qr.columns[n])
+		return nil
+	}
+	switch d := dest.(type) {
+	case *time.Time:
+		t, err := toTime(src)
+		if err != nil {
+			return fmt.Errorf("%v: bad time col:(%d/%s) val:%v", err, n, qr.Columns()[n], src)
+		}
+		*d = t
+	case *int:
+		switch src := src.(type) {
+		case float64:
+			*d = int(src)
+		case int64:
+			*d = int(src)
+		case string:
+			i, err := strconv.Atoi(src)
 			if err != nil {
-				return fmt.Errorf("%v: bad time col:(%d/%s) val:%v", err, n, qr.Columns()[n], src)
-			}
-			*d = t
-		case *int:
-			switch src := src.(type) {
-			case float64:
-				*d = int(src)
-			case int64:
-				*d = int(src)
-			case string:
-				i, err := strconv.Atoi(src)
-				if err != nil {
-					return err
-				}
-				*d = i
-			case nil:
-				trace("%s: skipping nil scan data for variable #%d (%s)", qr.ID, n, qr.columns[n])
-			default:
-				return fmt.Errorf("invalid int col:%d type:%T val:%v", n, src, src)
-			}
-		case *int64:
-			switch src := src.(type) {
-			case float64:
-				*d = int64(src)
-			case int64:
-				*d = src
-			case string:
-				i, err := strconv.ParseInt(src, 10, 64)
-				if err != nil {
-					return err
-				}
-				*d = i
-			case nil:
-				trace("%s: skipping nil scan data for variable #%d (%s)", qr.ID, n, qr.columns[n])
-			default:
-				return fmt.Errorf("invalid int64 col:%d type:%T val:%v", n, src, src)
-			}
-		case *float64:
-			switch src := src.(type) {
-			case float64:
-				*d = src
-			case int64:
-				*d = float64(src)
-			case string:
-				f, err := strconv.ParseFloat(src, 64)
-				if err != nil {
-					return err
-				}
-				*d = f
-			case nil:
-				trace("%s: skipping nil scan data for variable #%d (%s)", qr.ID, n, qr.columns[n])
-			default:
-				return fmt.Errorf("invalid float64 col:%d type:%T val:%v", n, src, src)
-			}
-		case *string:
-			switch src := src.(type) {
-			case string:
-				*d = src
-			case nil:
-				trace("%s: skipping nil scan data for variable #%d (%s)", qr.ID, n, qr.columns[n])
-			default:
-				return fmt.Errorf("invalid string col:%d type:%T val:%v", n, src, src)
+				return err
 			}
-		case *bool:
-			// Note: Rqlite does not support bool, but this is a loop from dest
-			// meaning, the user might be targeting to a bool-type variable.
-			// Per Go convention, and per strconv.ParseBool documentation, bool might be
-			// coming from value of "1", "t", "T", "TRUE", "true", "True", for `true` and
-			// "0", "f", "F", "FALSE", "false", "False" for `false`
-			switch src := src.(type) {
-			case bool:
-				*d = src
-			case float64:
-				b, err := strconv.ParseBool(strconv.FormatFloat(src, 'g', -1, 64))
-				if err != nil {
-					return err
-				}
-				*d = b
-			case int64:
-				b, err := strconv.ParseBool(strconv.FormatInt(src, 10))
-				if err != nil {
-					return err
-				}
-				*d = b
-			case string:
-				b, err := strconv.ParseBool(src)
-				if err != nil {
-					return err
-				}
-				*d = b
-			case nil:
-				trace("%s: skipping nil scan data for variable #%d (%s)", qr.ID, n, qr.columns[n])
-			default:
-				return fmt.Errorf("invalid bool col:%d type:%T val:%v", n, src, src)
-			}
-		case *[]byte:
-			switch src := src.(type) {
-			case []byte:
-				*d = src
-			case string:
-				*d = []byte(src)
-			default:
-				return fmt.Errorf("invalid []byte col:%d type:%T val:%v", n, src, src)
-			}
-		case *NullString:
-			switch src := src.(type) {
-			case string:
-				*d = NullString{Valid: true, String: src}
-			case nil:
-				*d = NullString{Valid: false}
-			default:
-				return fmt.Errorf("invalid string col:%d type:%T val:%v", n, src, src)
+			*d = i
+		case nil:
+			trace("%s: skipping nil scan data for variable #%d (%s)", qr.ID, n, qr.columns[n])
+		default:
+			return fmt.Errorf("invalid int col:%d type:%T val:%v", n, src, src)
+		}
+	case *int64:
+		switch src := src.(type) {
+		case float64:
+			*d = int64(src)
+		case int64:
+			*d = src
+		case string:
+			i, err := strconv.ParseInt(src, 10, 64)
+			if err != nil {
+				return err
 			}
-		case *NullInt64:
-			switch src := src.(type) {
-			case float64:
-				*d = NullInt64{Valid: true, Int64: int64(src)}
-			case int64:
-				*d = NullInt64{Valid: true, Int64: src}
-			case string:
-				i, err := strconv.ParseInt(src, 10, 64)
-				if err != nil {
-					return err
-				}
-				*d = NullInt64{Valid: true, Int64: i}
-			case nil:
-				*d = NullInt64{Valid: false}
-			default:
-				return fmt.Errorf("invalid int64 col:%d type:%T val:%v", n, src, src)
+			*d = i
+		case nil:
+			trace("%s: skipping nil scan data for variable #%d (%s)", qr.ID, n, qr.columns[n])
+		default:
+			return fmt.Errorf("invalid int64 col:%d type:%T val:%v", n, src, src)
+		}
+	case *int32:
+		switch src := src.(type) {
+		case float64:
+			*d = int32(src)
+		case int64:
+			*d = int32(src)
+		case string:
+			i, err := strconv.ParseInt(src, 10, 32)
+			if err != nil {
+				return err
 			}
-		case *NullInt32:
-			switch src := src.(type) {
-			case float64:
-				*d = NullInt32{Valid: true, Int32: int32(src)}
-			case int64:
-				*d = NullInt32{Valid: true, Int32: int32(src)}
-			case string:
-				i, err := strconv.ParseInt(src, 10, 32)
-				if err != nil {
-					return err
-				}
-				*d = NullInt32{Valid: true, Int32: int32(i)}
-			case nil:
-				*d = NullInt32{Valid: false}
-			default:
-				return fmt.Errorf("invalid int32 col:%d type:%T val:%v", n, src, src)
+			*d = int32(i)
+		default:
+			return fmt.Errorf("invalid int32 col:%d type:%T val:%v", n, src, src)
+		}
+	case *int16:
+		switch src := src.(type) {
+		case float64:
+			*d = int16(src)
+		case int64:
+			*d = int16(src)
+		case string:
+			i, err := strconv.ParseInt(src, 10, 16)
+			if err != nil {
+				return err
 			}
-		case *NullInt16:
-			switch src := src.(type) {
-			case float64:
-				*d = NullInt16{Valid: true, Int16: int16(src)}
-			case int64:
-				*d = NullInt16{Valid: true, Int16: int16(src)}
-			case string:
-				i, err := strconv.ParseInt(src, 10, 16)
-				if err != nil {
-					return err
-				}
-				*d = NullInt16{Valid: true, Int16: int16(i)}
-			case nil:
-				*d = NullInt16{Valid: false}
-			default:
-				return fmt.Errorf("invalid int16 col:%d type:%T val:%v", n, src, src)
+			*d = int16(i)
+		default:
+			return fmt.Errorf("invalid int16 col:%d type:%T val:%v", n, src, src)
+		}
+	case *float64:
+		switch src := src.(type) {
+		case float64:
+			*d = src
+		case int64:
+			*d = float64(src)
+		case string:
+			f, err := strconv.ParseFloat(src, 64)
+			if err != nil {
+				return err
 			}
-		case *NullFloat64:
-			switch src := src.(type) {
-			case float64:
-				*d = NullFloat64{Valid: true, Float64: src}
-			case int64:
-				*d = NullFloat64{Valid: true, Float64: float64(src)}
-			case string:
-				f, err := strconv.ParseFloat(src, 64)
-				if err != nil {
-					return err
-				}
-				*d = NullFloat64{Valid: true, Float64: f}
-			case nil:
-				*d = NullFloat64{Valid: false}
-			default:
-				return fmt.Errorf("invalid float64 col:%d type:%T val:%v", n, src, src)
+			*d = f
+		case nil:
+			trace("%s: skipping nil scan data for variable #%d (%s)", qr.ID, n, qr.columns[n])
+		default:
+			return fmt.Errorf("invalid float64 col:%d type:%T val:%v", n, src, src)
+		}
+	case *string:
+		switch src := src.(type) {
+		case string:
+			*d = src
+		case nil:
+			trace("%s: skipping nil scan data for variable #%d (%s)", qr.ID, n, qr.columns[n])
+		default:
+			return fmt.Errorf("invalid string col:%d type:%T val:%v", n, src, src)
+		}
+	case *bool:
+		// Note: Rqlite does not support bool, but this is a loop from dest
+		// meaning, the user might be targeting to a bool-type variable.
+		// Per Go convention, and per strconv.ParseBool documentation, bool might be
+		// coming from value of "1", "t", "T", "TRUE", "true", "True", for `true` and
+		// "0", "f", "F", "FALSE", "false", "False" for `false`
+		switch src := src.(type) {
+		case bool:
+			*d = src
+		case float64:
+			b, err := strconv.ParseBool(strconv.FormatFloat(src, 'g', -1, 64))
+			if err != nil {
+				return err
 			}
-		case *NullBool:
-			switch src := src.(type) {
-			case float64:
-				b, err := strconv.ParseBool(strconv.FormatFloat(src, 'g', -1, 64))
-				if err != nil {
-					return err
-				}
-				*d = NullBool{Valid: true, Bool: b}
-			case int64:
-				b, err := strconv.ParseBool(strconv.FormatInt(src, 10))
-				if err != nil {
-					return err
-				}
-				*d = NullBool{Valid: true, Bool: b}
-			case string:
-				b, err := strconv.ParseBool(src)
-				if err != nil {
-					return err
-				}
-				*d = NullBool{Valid: true, Bool: b}
-			case nil:
-				*d = NullBool{Valid: false}
-			default:
-				return fmt.Errorf("invalid bool col:%d type:%T val:%v", n, src, src)
+			*d = b
+		case int64:
+			b, err := strconv.ParseBool(strconv.FormatInt(src, 10))
+			if err != nil {
+				return err
 			}
-		case *NullTime:
-			if src == nil {
-				*d = NullTime{Valid: false}
-			} else {
-				t, err := toTime(src)
-				if err != nil {
-					return fmt.Errorf("%v: bad time col:(%d/%s) val:%v", err, n, qr.Columns()[n], src)
-				}
-				*d = NullTime{Valid: true, Time: t}
+			*d = b
+		case string:
+			b, err := strconv.ParseBool(src)
+			if err != nil {
+				return err
 			}
+			*d = b
+		case nil:
+			trace("%s: skipping nil scan data for variable #%d (%s)", qr.ID, n, qr.columns[n])
 		default:
-			return fmt.Errorf("unknown destination type (%T) to scan into in variable #%d", d, n)
+			return fmt.Errorf("invalid bool col:%d type:%T val:%v", n, src, src)
+		}
+	case *[]byte:
+		switch src := src.(type) {
+		case []byte:
+			*d = src
+		case string:
+			*d = []byte(src)
+		default:
+			return fmt.Errorf("invalid []byte col:%d type:%T val:%v", n, src, src)
+		}
+	case *NullString:
+		v, err := scanAs[string](qr, n, src)
+		if err != nil {
+			return err
 		}
+		*d = NullString{String: v, Valid: true}
+	case *NullInt64:
+		v, err := scanAs[int64](qr, n, src)
+		if err != nil {
+			return err
+		}
+		*d = NullInt64{Int64: v, Valid: true}
+	case *NullInt32:
+		v, err := scanAs[int32](qr, n, src)
+		if err != nil {
+			return err
+		}
+		*d = NullInt32{Int32: v, Valid: true}
+	case *NullInt16:
+		v, err := scanAs[int16](qr, n, src)
+		if err != nil {
+			return err
+		}
+		*d = NullInt16{Int16: v, Valid: true}
+	case *NullFloat64:
+		v, err := scanAs[float64](qr, n, src)
+		if err != nil {
+			return err
+		}
+		*d = NullFloat64{Float64: v, Valid: true}
+	case *NullBool:
+		v, err := scanAs[bool](qr, n, src)
+		if err != nil {
+			return err
+		}
+		*d = NullBool{Bool: v, Valid: true}
+	case *NullTime:
+		v, err := scanAs[time.Time](qr, n, src)
+		if err != nil {
+			return err
+		}
+		*d = NullTime{Time: v, Valid: true}
+	default:
+		return fmt.Errorf("unknown destination type (%T) to scan into in variable #%d", d, n)
 	}
 
 	return nil
@@ -548,7 +548,7 @@ func TestQueries(t *testing.T) {
 	//NewStatement("DROP TABLE IF EXISTS ? " , testTableName())
 	wr, err := conn.WriteStmt(
 		context.Background(),
-		NewStatement("DROP TABLE IF EXISTS "+testTableName()))
+		[]*Statement{NewStatement("DROP TABLE IF EXISTS " + testTableName())})
 	if err != nil {
 		t.Logf("--> FATAL")
 		t.Fatal()
@@ -567,7 +567,7 @@ func TestQueries(t *testing.T) {
 	//       This does not work any more Sept. 16 using a fresh checkout of master.
 	wr, err = conn.WriteStmt(
 		context.Background(),
-		NewStatement("CREATE TABLE "+testTableName()+" (id integer, name text, ts INT_DATETIME DEFAULT CURRENT_TIMESTAMP)"))
+		[]*Statement{NewStatement("CREATE TABLE " + testTableName() + " (id integer, name text, ts INT_DATETIME DEFAULT CURRENT_TIMESTAMP)")})
 	if err != nil {
 		t.Logf("--> FATAL")
 		t.Fatal()
@@ -585,7 +585,7 @@ func TestQueries(t *testing.T) {
 	s = append(s, NewStatement(insert, 3, "Klingon"))
 	s = append(s, NewStatement(insert, 4, "Ferengi"))
 	s = append(s, NewStatement(insertTs, 5, "Cardassian", meeting.Unix()))
-	wResults, err := conn.WriteStmt(context.Background(), s...)
+	wResults, err := conn.WriteStmt(context.Background(), s)
 	if err != nil {
 		t.Logf("--> FATAL")
 		t.Fatal()
@@ -594,7 +594,7 @@ func TestQueries(t *testing.T) {
 	t.Logf("trying Queries")
 	qrs, err := conn.QueryStmt(
 		context.Background(),
-		NewStatement("SELECT name, ts FROM "+testTableName()+" WHERE id > 3", 3))
+		[]*Statement{NewStatement("SELECT name, ts FROM "+testTableName()+" WHERE id > 3", 3)})
 	if err != nil {
 		t.Logf("--> FAILED")
 		t.Fail()
@@ -676,7 +676,7 @@ func TestQueries(t *testing.T) {
 	t.Logf("trying WriteOne DROP")
 	wr, err = conn.WriteStmt(
 		context.Background(),
-		NewStatement("DROP TABLE IF EXISTS "+testTableName()))
+		[]*Statement{NewStatement("DROP TABLE IF EXISTS " + testTableName())})
 	if err != nil {
 		t.Logf("--> FAILED")
 		t.Fail()
@@ -687,7 +687,7 @@ func TestQueries(t *testing.T) {
 
 	t.Logf("trying WriteOne after Close")
 	del := NewStatement("DROP TABLE IF EXISTS " + testTableName())
-	wr, err = conn.WriteStmt(context.Background(), del)
+	wr, err = conn.WriteStmt(context.Background(), []*Statement{del})
 	if err != ErrClosed {
 		t.Logf("--> FAILED")
 		t.Fail()
@@ -695,7 +695,7 @@ func TestQueries(t *testing.T) {
 
 	t.Logf("trying Write after Close")
 	t1 := []*Statement{del, del}
-	wResults, err = conn.WriteStmt(context.Background(), t1...)
+	wResults, err = conn.WriteStmt(context.Background(), t1)
 	if err != ErrClosed {
 		t.Logf("--> FAILED")
 		t.Fail()
@@ -704,7 +704,7 @@ func TestQueries(t *testing.T) {
 	t.Logf("trying Queries after Close")
 	_, err = conn.QueryStmt(
 		context.Background(),
-		NewStatement("SELECT id FROM ?", testTableName()))
+		[]*Statement{NewStatement("SELECT id FROM ?", testTableName())})
 	if err != ErrClosed {
 		t.Logf("--> FAILED")
 		t.Fail()
@@ -716,7 +716,7 @@ func TestQueries(t *testing.T) {
 		NewStatement("SELECT name FROM ?", testTableName()),
 		NewStatement("SELECT id,name FROM ?", testTableName()),
 	}
-	_, err = conn.QueryStmt(context.Background(), t2...)
+	_, err = conn.QueryStmt(context.Background(), t2)
 	if err != ErrClosed {
 		t.Logf("--> FAILED")
 		t.Fail()
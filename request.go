@@ -16,8 +16,8 @@ func (conn *Connection) RequestOne(sqlStatement string) (RequestResult, error) {
 }
 
 // RequestOneContext wraps RequestContext() into a single-statement
-func (conn *Connection) RequestOneContext(ctx context.Context, sqlStatement string) (RequestResult, error) {
-	wra, err := conn.RequestContext(ctx, []string{sqlStatement})
+func (conn *Connection) RequestOneContext(ctx context.Context, sqlStatement string, opts ...CallOption) (RequestResult, error) {
+	wra, err := conn.RequestContext(ctx, []string{sqlStatement}, opts...)
 	return wra[0], err
 }
 
@@ -31,13 +31,16 @@ func (conn *Connection) RequestOneParameterized(statement ParameterizedStatement
 
 // RequestOneParameterizedContext wraps RequestParameterizedContext into
 // a single-statement method.
-func (conn *Connection) RequestOneParameterizedContext(ctx context.Context, statement ParameterizedStatement) (RequestResult, error) {
-	wra, err := conn.RequestParameterizedContext(ctx, statement)
+func (conn *Connection) RequestOneParameterizedContext(ctx context.Context, statement ParameterizedStatement, opts ...CallOption) (RequestResult, error) {
+	wra, err := conn.RequestParameterizedContext(ctx, []ParameterizedStatement{statement}, opts...)
 	return wra[0], err
 }
 
-func (conn *Connection) RequestStmt(ctx context.Context, sqlStatements ...*Statement) ([]RequestResult, error) {
-	return conn.RequestParameterizedContext(ctx, makeParameterizedStatements(sqlStatements)...)
+// RequestStmt is used to perform DDL/DML in the database with the given statements.
+//
+// The call options override the settings of the connection for this call only.
+func (conn *Connection) RequestStmt(ctx context.Context, sqlStatements []*Statement, opts ...CallOption) ([]RequestResult, error) {
+	return conn.RequestParameterizedContext(ctx, makeParameterizedStatements(sqlStatements), opts...)
 }
 
 // Request is used to perform DDL/DML in the database synchronously without parameters.
@@ -58,7 +61,8 @@ func (conn *Connection) Request(sqlStatements []string) ([]RequestResult, error)
 // RequestContext is used to perform DDL/DML in the database synchronously without parameters.
 //
 // To use RequestContext with parameterized queries, use RequestParameterizedContext.
-func (conn *Connection) RequestContext(ctx context.Context, sqlStatements []string) ([]RequestResult, error) {
+// The call options override the settings of the connection for this call only.
+func (conn *Connection) RequestContext(ctx context.Context, sqlStatements []string, opts ...CallOption) ([]RequestResult, error) {
 	parameterizedStatements := make([]ParameterizedStatement, 0, len(sqlStatements))
 	for _, sqlStatement := range sqlStatements {
 		parameterizedStatements = append(parameterizedStatements, ParameterizedStatement{
@@ -66,7 +70,7 @@ func (conn *Connection) RequestContext(ctx context.Context, sqlStatements []stri
 		})
 	}
 
-	return conn.RequestParameterizedContext(ctx, parameterizedStatements...)
+	return conn.RequestParameterizedContext(ctx, parameterizedStatements, opts...)
 }
 
 // RequestParameterized is used to perform DDL/DML in the database synchronously.
@@ -83,7 +87,7 @@ func (conn *Connection) RequestContext(ctx context.Context, sqlStatements []stri
 //
 // RequestParameterized uses context.Background() internally; to specify the context, use RequestParameterizedContext.
 func (conn *Connection) RequestParameterized(sqlStatements []ParameterizedStatement) ([]RequestResult, error) {
-	return conn.RequestParameterizedContext(context.Background(), sqlStatements...)
+	return conn.RequestParameterizedContext(context.Background(), sqlStatements)
 }
 
 // RequestParameterizedContext is used to perform DDL/DML in the database synchronously.
@@ -97,14 +101,27 @@ func (conn *Connection) RequestParameterized(sqlStatements []ParameterizedStatem
 // If it's something like a call to the rqlite API, then it'll return that error.
 // If one statement out of several has an error, it will return a generic
 // "there were %d statement errors" and you'll have to look at the individual statement's Err for more info.
-func (conn *Connection) RequestParameterizedContext(ctx context.Context, sqlStatements ...ParameterizedStatement) ([]RequestResult, error) {
+//
+// The call options override the settings of the connection for this call only.
+func (conn *Connection) RequestParameterizedContext(ctx context.Context, sqlStatements []ParameterizedStatement, opts ...CallOption) ([]RequestResult, error) {
 	results := make([]RequestResult, 0)
 
-	if conn.hasBeenClosed {
+	if conn.isClosed() {
 		results = append(results, RequestResult{Err: ErrClosed})
 		return results, ErrClosed
 	}
 
+	conn, err := conn.withCallOptions(opts)
+	if err != nil {
+		results = append(results, RequestResult{Err: err})
+		return results, err
+	}
+	conn, sqlStatements, err = conn.withIdempotencyKey(sqlStatements)
+	if err != nil {
+		results = append(results, RequestResult{Err: err})
+		return results, err
+	}
+
 	trace("%s: Write() for %d statements", conn.ID, len(sqlStatements))
 
 	response, err := conn.rqliteApiPost(ctx, api_REQUEST, sqlStatements)
@@ -149,6 +166,11 @@ func (conn *Connection) RequestParameterizedContext(ctx context.Context, sqlStat
 		results = append(results, RequestResult{Err: err})
 		return results, err
 	}
+	resultsArray, err = conn.checkIdempotencyResults(resultsArray)
+	if err != nil {
+		results = append(results, RequestResult{Err: err})
+		return results, err
+	}
 
 	trace("%s: I have %d result(s) to parse", conn.ID, len(resultsArray))
 	numStatementErrors := 0
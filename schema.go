@@ -0,0 +1,199 @@
+package gorqlite
+
+// this file contains the schema introspection:
+//
+//   Connection.Tables() from sqlite_master
+//   Connection.Columns(), Indexes() and ForeignKeys() from the PRAGMAs
+//   table_info, index_list, index_info and foreign_key_list
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrNoSuchTable is returned, wrapped, when introspecting a table that
+// doesn't exist.
+var ErrNoSuchTable = errors.New("gorqlite: no such table")
+
+// Table describes a table of the database.
+type Table struct {
+	Name string
+	SQL  string // the CREATE TABLE statement
+}
+
+// Column describes a column of a table, as returned by Columns().
+type Column struct {
+	Name       string
+	Type       string       // the declared type, e.g. INTEGER or VARCHAR(20), may be empty
+	NotNull    bool         // true if the column is declared NOT NULL
+	Default    Null[string] // the default value expression, e.g. 'none' or CURRENT_TIMESTAMP
+	PrimaryKey int          // position in the primary key starting at 1, 0 if not part of it
+}
+
+// Index describes an index of a table, as returned by Indexes().
+type Index struct {
+	Name    string
+	Unique  bool
+	Origin  string   // "c" for CREATE INDEX, "u" for a UNIQUE constraint, "pk" for the primary key
+	Partial bool     // true if the index has a WHERE clause
+	Columns []string // the indexed columns, in order; empty names stand for expressions
+}
+
+// ForeignKey describes a foreign key of a table, as returned by
+// ForeignKeys().
+type ForeignKey struct {
+	ID       int
+	Table    string   // the referenced table
+	From     []string // the columns of the table
+	To       []string // the referenced columns; empty names stand for the primary key
+	OnUpdate string   // e.g. NO ACTION or CASCADE
+	OnDelete string
+	Match    string
+}
+
+// quoteIdentifier quotes a table or index name for the PRAGMAs, which don't
+// take parameters.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// queryOne runs a single introspection query, returning the error of the
+// statement if it failed.
+func (conn *Connection) queryOne(ctx context.Context, stmt Statement, opts []CallOption) (QueryResult, error) {
+	qr, err := conn.QueryOneParameterizedContext(ctx, stmt, opts...)
+	if err != nil {
+		if qr.Err != nil {
+			return qr, qr.Err
+		}
+		return qr, err
+	}
+	return qr, nil
+}
+
+// Tables returns the tables of the database sorted by name, the internal
+// sqlite_ tables excluded.
+func (conn *Connection) Tables(ctx context.Context, opts ...CallOption) ([]Table, error) {
+	qr, err := conn.queryOne(ctx, Statement{
+		Query: "SELECT name, sql FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite\\_%' ESCAPE '\\' ORDER BY name",
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]Table, 0, qr.NumRows())
+	for qr.Next() {
+		var t Table
+		if err := qr.Scan(&t.Name, &t.SQL); err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// Columns returns the columns of the table in their declaration order. It
+// fails with ErrNoSuchTable if the table doesn't exist.
+func (conn *Connection) Columns(ctx context.Context, table string, opts ...CallOption) ([]Column, error) {
+	qr, err := conn.queryOne(ctx, Statement{Query: "PRAGMA table_info(" + quoteIdentifier(table) + ")"}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if qr.NumRows() == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchTable, table)
+	}
+	columns := make([]Column, 0, qr.NumRows())
+	for qr.Next() {
+		var cid, notNull, pk int64
+		var c Column
+		if err := qr.Scan(&cid, &c.Name, &c.Type, &notNull, &c.Default, &pk); err != nil {
+			return nil, err
+		}
+		c.NotNull = notNull != 0
+		c.PrimaryKey = int(pk)
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+// Indexes returns the indexes of the table sorted by name, including the
+// automatic indexes of the UNIQUE and PRIMARY KEY constraints.
+func (conn *Connection) Indexes(ctx context.Context, table string, opts ...CallOption) ([]Index, error) {
+	qr, err := conn.queryOne(ctx, Statement{Query: "PRAGMA index_list(" + quoteIdentifier(table) + ")"}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []Index
+	for qr.Next() {
+		var seq, unique, partial int64
+		var idx Index
+		if err := qr.Scan(&seq, &idx.Name, &unique, &idx.Origin, &partial); err != nil {
+			return nil, err
+		}
+		idx.Unique = unique != 0
+		idx.Partial = partial != 0
+		indexes = append(indexes, idx)
+	}
+	if len(indexes) == 0 {
+		return indexes, nil
+	}
+
+	// the columns of all the indexes in a single call
+	stmts := make([]string, 0, len(indexes))
+	for _, idx := range indexes {
+		stmts = append(stmts, "PRAGMA index_info("+quoteIdentifier(idx.Name)+")")
+	}
+	results, err := conn.QueryContext(ctx, stmts, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != len(indexes) {
+		return nil, fmt.Errorf("expected %d results, got %d", len(indexes), len(results))
+	}
+	for i := range indexes {
+		qr := results[i]
+		for qr.Next() {
+			var seqno, cid int64
+			var name string
+			if err := qr.Scan(&seqno, &cid, &name); err != nil {
+				return nil, err
+			}
+			indexes[i].Columns = append(indexes[i].Columns, name)
+		}
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i].Name < indexes[j].Name })
+	return indexes, nil
+}
+
+// ForeignKeys returns the foreign keys of the table, by ID.
+func (conn *Connection) ForeignKeys(ctx context.Context, table string, opts ...CallOption) ([]ForeignKey, error) {
+	qr, err := conn.queryOne(ctx, Statement{Query: "PRAGMA foreign_key_list(" + quoteIdentifier(table) + ")"}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var fks []ForeignKey
+	for qr.Next() {
+		var id, seq int64
+		var refTable, from, onUpdate, onDelete, match string
+		var to Null[string]
+		if err := qr.Scan(&id, &seq, &refTable, &from, &to, &onUpdate, &onDelete, &match); err != nil {
+			return nil, err
+		}
+		// the rows of a multi-column key are consecutive
+		if len(fks) == 0 || fks[len(fks)-1].ID != int(id) {
+			fks = append(fks, ForeignKey{
+				ID:       int(id),
+				Table:    refTable,
+				OnUpdate: onUpdate,
+				OnDelete: onDelete,
+				Match:    match,
+			})
+		}
+		fk := &fks[len(fks)-1]
+		fk.From = append(fk.From, from)
+		fk.To = append(fk.To, to.V)
+	}
+	sort.Slice(fks, func(i, j int) bool { return fks[i].ID < fks[j].ID })
+	return fks, nil
+}
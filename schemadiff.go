@@ -0,0 +1,789 @@
+package gorqlite
+
+// this file contains the declarative schema sync:
+//
+//   Connection.SchemaSnapshot() returning the CREATE statements of the schema
+//   DiffSchema() and Connection.PlanSchema() comparing two schemas
+//   Connection.ApplySchemaPlan() applying a plan in a single transaction
+//
+// the statements are compared after normalization (keywords case, spacing,
+// quoting and comments don't matter). A table whose only change is new
+// columns at the end gets ALTER TABLE ADD COLUMN, if SQLite allows it for
+// these columns; any other change rebuilds the table: the new table is
+// created under a temporary name, the rows are copied, the old table is
+// dropped and the new one renamed. With foreign keys enforced, dropping the
+// old table runs the ON DELETE actions of the tables referencing it, so these
+// rebuilds are marked destructive.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrDestructiveChange is returned, wrapped, when applying a plan that would
+// drop tables or columns without allowing it.
+var ErrDestructiveChange = errors.New("gorqlite: destructive schema change")
+
+// SchemaChangeKind is the kind of a change of a SchemaPlan.
+type SchemaChangeKind int
+
+const (
+	CreateTable SchemaChangeKind = iota
+	AddColumn
+	RebuildTable
+	DropTable
+	CreateIndex
+	DropIndex
+)
+
+func (k SchemaChangeKind) String() string {
+	switch k {
+	case CreateTable:
+		return "create table"
+	case AddColumn:
+		return "add column"
+	case RebuildTable:
+		return "rebuild table"
+	case DropTable:
+		return "drop table"
+	case CreateIndex:
+		return "create index"
+	case DropIndex:
+		return "drop index"
+	}
+	return "unknown"
+}
+
+// SchemaChange is a change of a SchemaPlan.
+type SchemaChange struct {
+	Kind        SchemaChangeKind
+	Table       string
+	Name        string   // the column or index, empty for table changes
+	Reason      string   // why a table is rebuilt
+	Destructive bool     // true if data is lost: a table or columns are dropped
+	Statements  []string // the statements making the change
+}
+
+func (c SchemaChange) String() string {
+	s := c.Kind.String() + " " + c.Table
+	if c.Name != "" {
+		s += "." + c.Name
+	}
+	if c.Reason != "" {
+		s += ": " + c.Reason
+	}
+	if c.Destructive {
+		s += " (destructive)"
+	}
+	return s
+}
+
+// SchemaPlan is the list of changes turning a schema into another one, see
+// DiffSchema().
+type SchemaPlan struct {
+	Changes []SchemaChange
+}
+
+// Empty tells whether the schemas are the same.
+func (p *SchemaPlan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// Destructive tells whether applying the plan loses data.
+func (p *SchemaPlan) Destructive() bool {
+	for _, c := range p.Changes {
+		if c.Destructive {
+			return true
+		}
+	}
+	return false
+}
+
+// Statements returns the statements of all the changes, in order.
+func (p *SchemaPlan) Statements() []string {
+	var stmts []string
+	for _, c := range p.Changes {
+		stmts = append(stmts, c.Statements...)
+	}
+	return stmts
+}
+
+// String describes the changes, one per line.
+func (p *SchemaPlan) String() string {
+	lines := make([]string, 0, len(p.Changes))
+	for _, c := range p.Changes {
+		lines = append(lines, c.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SchemaSnapshot returns the CREATE statements of the tables and indexes of
+// the database, sorted by name, the internal sqlite_ objects excluded.
+func (conn *Connection) SchemaSnapshot(ctx context.Context, opts ...CallOption) ([]string, error) {
+	tables, err := conn.Tables(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	stmts := make([]string, 0, len(tables))
+	for _, t := range tables {
+		stmts = append(stmts, t.SQL)
+	}
+
+	// the indexes created by the constraints have no statement
+	qr, err := conn.queryOne(ctx, Statement{
+		Query: "SELECT sql FROM sqlite_master WHERE type = 'index' AND sql IS NOT NULL ORDER BY name",
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	for qr.Next() {
+		var sql string
+		if err := qr.Scan(&sql); err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, sql)
+	}
+	return stmts, nil
+}
+
+// PlanSchema compares the schema of the database with the desired one, given
+// as CREATE TABLE and CREATE INDEX statements, see DiffSchema().
+func (conn *Connection) PlanSchema(ctx context.Context, desired []string, opts ...CallOption) (*SchemaPlan, error) {
+	live, err := conn.SchemaSnapshot(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return DiffSchema(live, desired)
+}
+
+// ApplySchemaPlan executes the statements of the plan in a single
+// transaction. It fails with ErrDestructiveChange if the plan drops tables or
+// columns, unless allowDestructive is true.
+//
+// The rebuilt tables lose their triggers. SQLite can't turn the foreign keys
+// off within a transaction: on a cluster enforcing them (rqlited -fk), the
+// DROP TABLE of a rebuilt table deletes its rows first, which runs the ON
+// DELETE actions of the tables referencing it, e.g. deletes their rows with
+// ON DELETE CASCADE. The rebuilds of the referenced tables are therefore
+// destructive.
+func (conn *Connection) ApplySchemaPlan(ctx context.Context, plan *SchemaPlan, allowDestructive bool, opts ...CallOption) error {
+	if plan.Destructive() && !allowDestructive {
+		var changes []string
+		for _, c := range plan.Changes {
+			if c.Destructive {
+				changes = append(changes, c.String())
+			}
+		}
+		return fmt.Errorf("%w: %s", ErrDestructiveChange, strings.Join(changes, ", "))
+	}
+	stmts := plan.Statements()
+	if len(stmts) == 0 {
+		return nil
+	}
+	opts = append(opts[:len(opts):len(opts)], CallTransaction(true))
+	results, err := conn.WriteContext(ctx, stmts, opts...)
+	if err != nil && len(results) == len(stmts) {
+		for i, wr := range results {
+			if wr.Err != nil {
+				return fmt.Errorf("statement %q: %w", stmts[i], wr.Err)
+			}
+		}
+	}
+	return err
+}
+
+// DiffSchema returns the plan turning the live schema into the desired one,
+// both given as CREATE TABLE and CREATE INDEX statements, e.g. from
+// SchemaSnapshot(). Other statements are an error.
+//
+// Tables and indexes missing from the desired schema are dropped: dropping a
+// table is destructive, as is rebuilding a table without some of its columns
+// or referenced by the foreign keys of other tables, see ApplySchemaPlan().
+func DiffSchema(live, desired []string) (*SchemaPlan, error) {
+	liveTables, liveIndexes, err := parseSchema(live)
+	if err != nil {
+		return nil, fmt.Errorf("live schema: %w", err)
+	}
+	desiredTables, desiredIndexes, err := parseSchema(desired)
+	if err != nil {
+		return nil, fmt.Errorf("desired schema: %w", err)
+	}
+
+	plan := &SchemaPlan{}
+	var creates, alters, rebuilds, drops, indexDrops, indexCreates []SchemaChange
+	rebuilt := make(map[string]bool) // the tables losing their indexes
+
+	for _, key := range sortedKeys(desiredTables) {
+		d := desiredTables[key]
+		l, ok := liveTables[key]
+		if !ok {
+			creates = append(creates, SchemaChange{Kind: CreateTable, Table: d.name, Statements: []string{d.sql}})
+			continue
+		}
+		if l.normalized == d.normalized || sameTable(l, d) {
+			continue
+		}
+		if added, ok := addedColumns(l, d); ok {
+			for _, c := range added {
+				alters = append(alters, SchemaChange{
+					Kind:       AddColumn,
+					Table:      d.name,
+					Name:       c.name,
+					Statements: []string{"ALTER TABLE " + quoteIdentifier(d.name) + " ADD COLUMN " + c.text},
+				})
+			}
+			continue
+		}
+		rebuilds = append(rebuilds, rebuildTable(l, d, referencing(liveTables, key)))
+		rebuilt[key] = true
+	}
+	for _, key := range sortedKeys(liveTables) {
+		if _, ok := desiredTables[key]; !ok {
+			l := liveTables[key]
+			drops = append(drops, SchemaChange{
+				Kind:        DropTable,
+				Table:       l.name,
+				Destructive: true,
+				Statements:  []string{"DROP TABLE " + quoteIdentifier(l.name)},
+			})
+			rebuilt[key] = true
+		}
+	}
+
+	for _, key := range sortedKeys(liveIndexes) {
+		l := liveIndexes[key]
+		if rebuilt[strings.ToLower(l.table)] {
+			continue // dropped with its table
+		}
+		if d, ok := desiredIndexes[key]; !ok || d.normalized != l.normalized {
+			indexDrops = append(indexDrops, SchemaChange{
+				Kind:       DropIndex,
+				Table:      l.table,
+				Name:       l.name,
+				Statements: []string{"DROP INDEX " + quoteIdentifier(l.name)},
+			})
+		}
+	}
+	for _, key := range sortedKeys(desiredIndexes) {
+		d := desiredIndexes[key]
+		l, ok := liveIndexes[key]
+		if ok && l.normalized == d.normalized && !rebuilt[strings.ToLower(d.table)] {
+			continue
+		}
+		indexCreates = append(indexCreates, SchemaChange{Kind: CreateIndex, Table: d.table, Name: d.name, Statements: []string{d.sql}})
+	}
+
+	for _, changes := range [][]SchemaChange{indexDrops, creates, alters, rebuilds, indexCreates, drops} {
+		plan.Changes = append(plan.Changes, changes...)
+	}
+	return plan, nil
+}
+
+// sameTable tells whether the tables only differ by the place of the
+// constraints, e.g. after ALTER TABLE ADD COLUMN.
+func sameTable(l, d *parsedTable) bool {
+	if len(l.columns) != len(d.columns) || l.constraints != d.constraints || l.options != d.options {
+		return false
+	}
+	for i, c := range l.columns {
+		if c.normalized != d.columns[i].normalized {
+			return false
+		}
+	}
+	return true
+}
+
+// addedColumns returns the columns to add to the live table to get the
+// desired one, if that's the only difference and SQLite allows adding them.
+func addedColumns(l, d *parsedTable) ([]parsedColumn, bool) {
+	if len(d.columns) <= len(l.columns) || l.constraints != d.constraints || l.options != d.options {
+		return nil, false
+	}
+	for i, c := range l.columns {
+		if c.normalized != d.columns[i].normalized {
+			return nil, false
+		}
+	}
+	added := d.columns[len(l.columns):]
+	for _, c := range added {
+		if !c.addable() {
+			return nil, false
+		}
+	}
+	return added, true
+}
+
+// referencing returns the names of the tables whose foreign keys reference
+// the table, itself excluded.
+func referencing(tables map[string]*parsedTable, key string) []string {
+	var names []string
+	for _, k := range sortedKeys(tables) {
+		if k == key {
+			continue
+		}
+		for _, ref := range tables[k].references {
+			if ref == key {
+				names = append(names, tables[k].name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// rebuildTable returns the change rebuilding the live table into the desired
+// one, keeping the rows of the common columns. Rebuilding a table referenced
+// by others is destructive: with foreign keys enforced, dropping the old
+// table runs the ON DELETE actions of the referencing rows.
+func rebuildTable(l, d *parsedTable, referencedBy []string) SchemaChange {
+	desiredCols := make(map[string]bool, len(d.columns))
+	for _, c := range d.columns {
+		desiredCols[strings.ToLower(c.name)] = true
+	}
+	liveCols := make(map[string]bool, len(l.columns))
+	var common, reasons []string
+	destructive := false
+	for _, c := range l.columns {
+		liveCols[strings.ToLower(c.name)] = true
+		if desiredCols[strings.ToLower(c.name)] {
+			common = append(common, quoteIdentifier(c.name))
+		} else {
+			reasons = append(reasons, "column "+c.name+" dropped")
+			destructive = true
+		}
+	}
+	for _, c := range d.columns {
+		if !liveCols[strings.ToLower(c.name)] {
+			reasons = append(reasons, "column "+c.name+" added")
+			continue
+		}
+		for _, lc := range l.columns {
+			if strings.EqualFold(lc.name, c.name) && lc.normalized != c.normalized {
+				reasons = append(reasons, "column "+c.name+" changed")
+			}
+		}
+	}
+	if l.constraints != d.constraints {
+		reasons = append(reasons, "table constraints changed")
+	}
+	if l.options != d.options {
+		reasons = append(reasons, "table options changed")
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, "column order changed")
+	}
+	if len(referencedBy) > 0 {
+		reasons = append(reasons, "referenced by "+strings.Join(referencedBy, ", ")+": dropping it may cascade to them")
+		destructive = true
+	}
+
+	tmp := quoteIdentifier("_gorqlite_new_" + d.name)
+	stmts := []string{"CREATE TABLE " + tmp + " " + d.definition}
+	if len(common) > 0 {
+		cols := strings.Join(common, ", ")
+		stmts = append(stmts, "INSERT INTO "+tmp+" ("+cols+") SELECT "+cols+" FROM "+quoteIdentifier(l.name))
+	}
+	stmts = append(stmts,
+		"DROP TABLE "+quoteIdentifier(l.name),
+		"ALTER TABLE "+tmp+" RENAME TO "+quoteIdentifier(d.name))
+
+	return SchemaChange{
+		Kind:        RebuildTable,
+		Table:       d.name,
+		Reason:      strings.Join(reasons, ", "),
+		Destructive: destructive,
+		Statements:  stmts,
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parsedTable is a CREATE TABLE statement, split into its parts.
+type parsedTable struct {
+	name        string
+	sql         string
+	definition  string // the text following the name: column definitions and options
+	normalized  string // the whole statement
+	columns     []parsedColumn
+	constraints string   // the normalized table constraints
+	options     string   // the normalized table options, e.g. WITHOUT ROWID
+	references  []string // the tables referenced by its foreign keys, lowercase
+}
+
+// parsedColumn is a column definition of a CREATE TABLE statement.
+type parsedColumn struct {
+	name       string
+	text       string
+	normalized string
+	primaryKey bool
+	unique     bool
+	notNull    bool
+	def        []sqlToken // the DEFAULT value, nil if none
+	generated  bool
+}
+
+// addable tells whether SQLite allows ALTER TABLE ADD COLUMN for the column.
+func (c parsedColumn) addable() bool {
+	if c.primaryKey || c.unique || c.generated {
+		return false
+	}
+	if len(c.def) > 0 {
+		first := strings.ToUpper(c.def[0].text)
+		if first == "(" || first == "CURRENT_TIME" || first == "CURRENT_DATE" || first == "CURRENT_TIMESTAMP" {
+			return false
+		}
+	}
+	if c.notNull && (len(c.def) == 0 || strings.EqualFold(c.def[0].text, "NULL")) {
+		return false
+	}
+	return true
+}
+
+// parsedIndex is a CREATE INDEX statement.
+type parsedIndex struct {
+	name       string
+	table      string
+	sql        string
+	normalized string
+}
+
+// parseSchema parses CREATE TABLE and CREATE INDEX statements, by lower case
+// name.
+func parseSchema(stmts []string) (map[string]*parsedTable, map[string]*parsedIndex, error) {
+	tables := make(map[string]*parsedTable)
+	indexes := make(map[string]*parsedIndex)
+	for _, stmt := range stmts {
+		stmt = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(stmt), ";"))
+		if stmt == "" {
+			continue
+		}
+		tokens, err := tokenizeSQL(stmt)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(tokens) == 0 {
+			// only comments
+			continue
+		}
+		kind := ""
+		for k := 1; k < len(tokens); k++ {
+			t := tokens[k]
+			w := strings.ToUpper(t.text)
+			if w == "TABLE" || w == "INDEX" {
+				kind = w
+				break
+			}
+			if w != "TEMP" && w != "TEMPORARY" && w != "UNIQUE" {
+				break
+			}
+		}
+		if !tokens[0].isWord("CREATE") || kind == "" {
+			return nil, nil, fmt.Errorf("not a CREATE TABLE or CREATE INDEX statement: %q", stmt)
+		}
+		if kind == "TABLE" {
+			t, err := parseCreateTable(stmt, tokens)
+			if err != nil {
+				return nil, nil, err
+			}
+			tables[strings.ToLower(t.name)] = t
+		} else {
+			idx, err := parseCreateIndex(stmt, tokens)
+			if err != nil {
+				return nil, nil, err
+			}
+			indexes[strings.ToLower(idx.name)] = idx
+		}
+	}
+	return tables, indexes, nil
+}
+
+// skipName returns the name starting at tokens[i], without its schema, and
+// the index of the token following it.
+func skipName(tokens []sqlToken, i int) (string, int, error) {
+	if i >= len(tokens) || tokens[i].kind == tokPunct {
+		return "", i, errors.New("missing name")
+	}
+	name := tokens[i].name()
+	i++
+	if i+1 < len(tokens) && tokens[i].text == "." {
+		name = tokens[i+1].name()
+		i += 2
+	}
+	return name, i, nil
+}
+
+// skipIfNotExists skips IF NOT EXISTS at tokens[i].
+func skipIfNotExists(tokens []sqlToken, i int) int {
+	if i+2 < len(tokens) && tokens[i].isWord("IF") && tokens[i+1].isWord("NOT") && tokens[i+2].isWord("EXISTS") {
+		return i + 3
+	}
+	return i
+}
+
+func parseCreateTable(stmt string, tokens []sqlToken) (*parsedTable, error) {
+	i := 1
+	for !tokens[i].isWord("TABLE") {
+		i++
+	}
+	i = skipIfNotExists(tokens, i+1)
+	name, i, err := skipName(tokens, i)
+	if err != nil {
+		return nil, fmt.Errorf("%v in %q", err, stmt)
+	}
+	if i >= len(tokens) || tokens[i].text != "(" {
+		return nil, fmt.Errorf("CREATE TABLE ... AS SELECT is not supported: %q", stmt)
+	}
+	t := &parsedTable{
+		name:       name,
+		sql:        stmt,
+		definition: stmt[tokens[i].pos:],
+		normalized: normalizeTokens(tokens, true),
+	}
+
+	// split the definitions on the commas outside of parentheses
+	depth, start := 0, i+1
+	var defs [][]sqlToken
+	for j := i; j < len(tokens); j++ {
+		switch tokens[j].text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				defs = append(defs, tokens[start:j])
+				t.options = normalizeTokens(tokens[j+1:], false)
+				j = len(tokens)
+			}
+		case ",":
+			if depth == 1 {
+				defs = append(defs, tokens[start:j])
+				start = j + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses in %q", stmt)
+	}
+
+	for j := i; j < len(tokens); j++ {
+		if tokens[j].isWord("REFERENCES") {
+			if ref, _, err := skipName(tokens, j+1); err == nil {
+				t.references = append(t.references, strings.ToLower(ref))
+			}
+		}
+	}
+
+	var constraints []string
+	for _, def := range defs {
+		if len(def) == 0 {
+			return nil, fmt.Errorf("empty definition in %q", stmt)
+		}
+		switch strings.ToUpper(def[0].text) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			if def[0].kind == tokWord {
+				constraints = append(constraints, normalizeTokens(def, false))
+				if def[0].isWord("PRIMARY") {
+					for _, tok := range def {
+						for k := range t.columns {
+							if tok.kind != tokPunct && strings.EqualFold(tok.name(), t.columns[k].name) {
+								t.columns[k].primaryKey = true
+							}
+						}
+					}
+				}
+				continue
+			}
+		}
+		t.columns = append(t.columns, parseColumn(stmt, def))
+	}
+	t.constraints = strings.Join(constraints, ", ")
+	return t, nil
+}
+
+func parseColumn(stmt string, def []sqlToken) parsedColumn {
+	c := parsedColumn{
+		name:       def[0].name(),
+		text:       stmt[def[0].pos:def[len(def)-1].end],
+		normalized: normalizeTokens(def, false),
+	}
+	for k := 1; k < len(def); k++ {
+		switch {
+		case def[k].isWord("PRIMARY"):
+			c.primaryKey = true
+		case def[k].isWord("UNIQUE"):
+			c.unique = true
+		case def[k].isWord("NOT") && k+1 < len(def) && def[k+1].isWord("NULL"):
+			c.notNull = true
+			k++
+		case def[k].isWord("GENERATED") || def[k].isWord("AS"):
+			c.generated = true
+		case def[k].isWord("DEFAULT") && k+1 < len(def):
+			c.def = def[k+1:]
+		}
+	}
+	return c
+}
+
+func parseCreateIndex(stmt string, tokens []sqlToken) (*parsedIndex, error) {
+	i := 1
+	for !tokens[i].isWord("INDEX") {
+		i++
+	}
+	i = skipIfNotExists(tokens, i+1)
+	name, i, err := skipName(tokens, i)
+	if err != nil {
+		return nil, fmt.Errorf("%v in %q", err, stmt)
+	}
+	if i >= len(tokens) || !tokens[i].isWord("ON") {
+		return nil, fmt.Errorf("missing ON in %q", stmt)
+	}
+	table, _, err := skipName(tokens, i+1)
+	if err != nil {
+		return nil, fmt.Errorf("%v in %q", err, stmt)
+	}
+	return &parsedIndex{
+		name:       name,
+		table:      table,
+		sql:        stmt,
+		normalized: normalizeTokens(tokens, true),
+	}, nil
+}
+
+// normalizeTokens joins the tokens with single spaces, with the keywords and
+// identifiers in upper case, dropping IF NOT EXISTS if asked.
+func normalizeTokens(tokens []sqlToken, dropIfNotExists bool) string {
+	parts := make([]string, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		if dropIfNotExists && skipIfNotExists(tokens, i) != i {
+			i += 2
+			continue
+		}
+		t := tokens[i]
+		switch t.kind {
+		case tokWord, tokIdent:
+			parts = append(parts, strings.ToUpper(t.name()))
+		default:
+			parts = append(parts, t.text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+type sqlTokenKind int
+
+const (
+	tokWord   sqlTokenKind = iota // keyword or bare identifier
+	tokIdent                      // quoted identifier
+	tokString                     // string literal
+	tokNumber
+	tokPunct
+)
+
+// sqlToken is a token of a SQL statement, at stmt[pos:end].
+type sqlToken struct {
+	kind     sqlTokenKind
+	text     string
+	pos, end int
+}
+
+func (t sqlToken) isWord(w string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.text, w)
+}
+
+// name returns the identifier of the token, unquoted.
+func (t sqlToken) name() string {
+	if t.kind != tokIdent {
+		return t.text
+	}
+	inner := t.text[1 : len(t.text)-1]
+	if t.text[0] == '[' {
+		return inner
+	}
+	q := t.text[:1]
+	return strings.ReplaceAll(inner, q+q, q)
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// tokenizeSQL splits a statement into tokens, dropping the comments.
+func tokenizeSQL(s string) ([]sqlToken, error) {
+	var tokens []sqlToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+			continue
+		case c == '-' && i+1 < len(s) && s[i+1] == '-':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return nil, errors.New("unterminated comment")
+			}
+			i += end + 4
+			continue
+		case c == '\'' || c == '"' || c == '`':
+			i++
+			for {
+				if i >= len(s) {
+					return nil, fmt.Errorf("unterminated quote at %d", start)
+				}
+				if s[i] == c {
+					if i+1 < len(s) && s[i+1] == c {
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				i++
+			}
+			kind := tokIdent
+			if c == '\'' {
+				kind = tokString
+			}
+			tokens = append(tokens, sqlToken{kind: kind, text: s[start:i], pos: start, end: i})
+			continue
+		case c == '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote at %d", start)
+			}
+			i += end + 1
+			tokens = append(tokens, sqlToken{kind: tokIdent, text: s[start:i], pos: start, end: i})
+			continue
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			for i < len(s) && (isIdentChar(s[i]) || s[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: tokNumber, text: s[start:i], pos: start, end: i})
+			continue
+		case isIdentChar(c):
+			for i < len(s) && isIdentChar(s[i]) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: tokWord, text: s[start:i], pos: start, end: i})
+			continue
+		}
+		i++
+		tokens = append(tokens, sqlToken{kind: tokPunct, text: s[start:i], pos: start, end: i})
+	}
+	return tokens, nil
+}
@@ -0,0 +1,183 @@
+package gorqlite
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDiffSchemaNoChange(t *testing.T) {
+	live := []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL, PRIMARY KEY (id)) WITHOUT ROWID`,
+		`CREATE INDEX users_email ON users (email)`,
+	}
+	desired := []string{
+		"create table if not exists \"users\" (\n  id integer primary key, -- the id\n  email TEXT not null,\n  primary key (id)\n) without rowid;",
+		`CREATE INDEX IF NOT EXISTS users_email ON users(email)`,
+	}
+	plan, err := DiffSchema(live, desired)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !plan.Empty() {
+		t.Errorf("expected no change, got:\n%s", plan)
+	}
+
+	// ALTER TABLE ADD COLUMN appends the column after the constraints
+	live = []string{`CREATE TABLE t (a INTEGER, UNIQUE (a), b TEXT)`}
+	desired = []string{`CREATE TABLE t (a INTEGER, b TEXT, UNIQUE (a))`}
+	if plan, err = DiffSchema(live, desired); err != nil || !plan.Empty() {
+		t.Errorf("expected no change, got %v:\n%s", err, plan)
+	}
+
+	// splitting a schema file on ; leaves the trailing comments alone
+	live = []string{`CREATE TABLE a (x INT)`}
+	desired = []string{"CREATE TABLE a (x INT);", "-- trailing comment", "/* another */"}
+	if plan, err = DiffSchema(live, desired); err != nil || !plan.Empty() {
+		t.Errorf("expected no change, got %v:\n%s", err, plan)
+	}
+}
+
+func TestDiffSchemaAddColumns(t *testing.T) {
+	live := []string{`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)`}
+	desired := []string{`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, status TEXT NOT NULL DEFAULT 'new', age INTEGER)`}
+	plan, err := DiffSchema(live, desired)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		`ALTER TABLE "users" ADD COLUMN status TEXT NOT NULL DEFAULT 'new'`,
+		`ALTER TABLE "users" ADD COLUMN age INTEGER`,
+	}
+	if !reflect.DeepEqual(plan.Statements(), expected) {
+		t.Errorf("expected %q, got %q", expected, plan.Statements())
+	}
+	requireBool(t, false, plan.Destructive())
+}
+
+func TestDiffSchemaRebuild(t *testing.T) {
+	for name, desired := range map[string]string{
+		"not null without default": `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, status TEXT NOT NULL)`,
+		"unique column":            `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, status TEXT UNIQUE)`,
+		"changed type":             `CREATE TABLE users (id INTEGER PRIMARY KEY, email BLOB)`,
+		"column in the middle":     `CREATE TABLE users (id INTEGER PRIMARY KEY, status TEXT, email TEXT)`,
+		"new constraint":           `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, UNIQUE (email))`,
+	} {
+		plan, err := DiffSchema([]string{
+			`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)`,
+			`CREATE INDEX users_email ON users (email)`,
+		}, []string{desired, `CREATE INDEX users_email ON users (email)`})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if len(plan.Changes) != 2 || plan.Changes[0].Kind != RebuildTable || plan.Changes[1].Kind != CreateIndex {
+			t.Errorf("%s: expected a rebuild and the index recreated, got:\n%s", name, plan)
+			continue
+		}
+		stmts := plan.Changes[0].Statements
+		requireInt(t, 4, len(stmts))
+		requireString(t, `INSERT INTO "_gorqlite_new_users" ("id", "email") SELECT "id", "email" FROM "users"`, stmts[1])
+		requireString(t, `ALTER TABLE "_gorqlite_new_users" RENAME TO "users"`, stmts[3])
+		requireBool(t, false, plan.Destructive())
+	}
+}
+
+func TestDiffSchemaDestructive(t *testing.T) {
+	live := []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, legacy TEXT)`,
+		`CREATE TABLE old (id INTEGER)`,
+		`CREATE INDEX old_id ON old (id)`,
+		`CREATE INDEX users_legacy ON users (legacy)`,
+	}
+	desired := []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)`,
+		`CREATE TABLE new (id INTEGER)`,
+		`CREATE UNIQUE INDEX new_id ON new (id)`,
+	}
+	plan, err := DiffSchema(live, desired)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var kinds []SchemaChangeKind
+	for _, c := range plan.Changes {
+		kinds = append(kinds, c.Kind)
+	}
+	// the indexes of the rebuilt and dropped tables go with them
+	expected := []SchemaChangeKind{CreateTable, RebuildTable, CreateIndex, DropTable}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("expected changes %v, got:\n%s", expected, plan)
+	}
+	requireBool(t, true, plan.Changes[1].Destructive)
+	requireString(t, "column legacy dropped", plan.Changes[1].Reason)
+	requireBool(t, true, plan.Destructive())
+
+	err = (&Connection{connShared: &connShared{}}).ApplySchemaPlan(context.Background(), plan, false)
+	if !errors.Is(err, ErrDestructiveChange) {
+		t.Errorf("expected ErrDestructiveChange, got %v", err)
+	}
+}
+
+func TestDiffSchemaReferencedRebuild(t *testing.T) {
+	live := []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)`,
+		`CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES "users" (id) ON DELETE CASCADE)`,
+		`CREATE TABLE tags (id INTEGER PRIMARY KEY, post INTEGER, FOREIGN KEY (post) REFERENCES posts (id))`,
+	}
+	desired := []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, status TEXT NOT NULL)`,
+		live[1],
+		live[2],
+	}
+	plan, err := DiffSchema(live, desired)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0].Kind != RebuildTable {
+		t.Fatalf("expected a rebuild, got:\n%s", plan)
+	}
+	// dropping the old users table would cascade to the posts
+	requireBool(t, true, plan.Changes[0].Destructive)
+	requireString(t, "column status added, referenced by posts: dropping it may cascade to them", plan.Changes[0].Reason)
+
+	// a table referencing itself only
+	live = []string{`CREATE TABLE nodes (id INTEGER PRIMARY KEY, parent INTEGER REFERENCES nodes (id))`}
+	desired = []string{`CREATE TABLE nodes (id INTEGER PRIMARY KEY, parent INTEGER REFERENCES nodes (id), name TEXT UNIQUE)`}
+	if plan, err = DiffSchema(live, desired); err != nil || len(plan.Changes) != 1 {
+		t.Fatalf("expected a rebuild, got %v:\n%s", err, plan)
+	}
+	requireBool(t, false, plan.Destructive())
+}
+
+func TestDiffSchemaIndexes(t *testing.T) {
+	table := `CREATE TABLE t (a INTEGER, b INTEGER)`
+	plan, err := DiffSchema(
+		[]string{table, `CREATE INDEX t_a ON t (a)`, `CREATE INDEX t_b ON t (b)`},
+		[]string{table, `CREATE INDEX t_a ON t (a, b)`, `CREATE INDEX t_ab ON t (a, b) WHERE a > 0`},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		`DROP INDEX "t_a"`,
+		`DROP INDEX "t_b"`,
+		`CREATE INDEX t_a ON t (a, b)`,
+		`CREATE INDEX t_ab ON t (a, b) WHERE a > 0`,
+	}
+	if !reflect.DeepEqual(plan.Statements(), expected) {
+		t.Errorf("expected %q, got %q", expected, plan.Statements())
+	}
+}
+
+func TestDiffSchemaInvalid(t *testing.T) {
+	for _, stmt := range []string{
+		`CREATE VIEW v AS SELECT 1`,
+		`DROP TABLE t`,
+		`CREATE TABLE t AS SELECT 1`,
+		`CREATE TABLE t (a TEXT DEFAULT 'x)`,
+	} {
+		if _, err := DiffSchema(nil, []string{stmt}); err == nil {
+			t.Errorf("expected an error for %q", stmt)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package gorqlite
+
+// this file contains the typed versions of the node APIs:
+//
+//   Connection.Status() for /status
+//   Connection.Nodes() for /nodes
+//   Connection.Ready() for /readyz
+//
+// the parsing tolerates the differences between the rqlite versions: the
+// leader is a raft address in 5.x and an object since 6.x, the raft stats
+// used to be strings and /nodes is either a map or a list of nodes.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BuildInfo describes the build of the rqlite server.
+type BuildInfo struct {
+	Version   string // e.g. v8.0.0
+	Commit    string
+	Branch    string
+	BuildTime string
+}
+
+// RaftStatus describes the raft state of a node.
+type RaftStatus struct {
+	State        string // Leader, Follower, Candidate or Shutdown
+	Term         uint64
+	AppliedIndex uint64
+	CommitIndex  uint64
+	LastLogIndex uint64
+	NumPeers     uint64
+}
+
+// LeaderInfo identifies the leader of the cluster as seen by a node.
+type LeaderInfo struct {
+	NodeID  string // empty for 5.x servers
+	Addr    string // raft address
+	APIAddr string // HTTP address, known for 5.x servers only
+}
+
+// Status is the status of the node that answered a Status() call.
+type Status struct {
+	Build  BuildInfo
+	NodeID string
+	Addr   string // raft address
+	Voter  bool
+	Raft   RaftStatus
+	Leader LeaderInfo
+
+	// Raw holds the full /status response, for the sections without a
+	// typed counterpart.
+	Raw map[string]interface{}
+}
+
+// Node describes a node of the cluster, as returned by Nodes().
+type Node struct {
+	ID        string
+	APIAddr   string  // e.g. http://host1:4001
+	Addr      string  // raft address
+	Voter     bool    // false for read-only nodes
+	Reachable bool    // false if the node didn't answer the node that was asked
+	Leader    bool    // true for the leader
+	Time      float64 // time taken to reach the node, in seconds
+	Error     string  // why the node is unreachable
+}
+
+// Readiness is the answer of a node to a Ready() call.
+type Readiness struct {
+	Ready  bool            // true if all the checks passed
+	Checks map[string]bool // result of each check, e.g. "node", "leader", "store"
+
+	// Message is the response of the node, describing failed checks.
+	Message string
+}
+
+// ErrInvalidResponse is wrapped by the errors returned when a node API
+// answers with an unexpected response.
+var ErrInvalidResponse = errors.New("gorqlite: invalid response")
+
+// Status returns the status of the first node that answers, normally the
+// leader.
+func (conn *Connection) Status(ctx context.Context) (Status, error) {
+	if conn.isClosed() {
+		return Status{}, ErrClosed
+	}
+	responseBody, err := conn.rqliteApiGet(ctx, api_STATUS)
+	if err != nil {
+		return Status{}, err
+	}
+	return parseStatus(responseBody)
+}
+
+// Nodes returns the nodes of the cluster sorted by ID. Read-only nodes are
+// only included if includeNonVoters is true.
+func (conn *Connection) Nodes(ctx context.Context, includeNonVoters bool) ([]Node, error) {
+	if conn.isClosed() {
+		return nil, ErrClosed
+	}
+	var query []string
+	if includeNonVoters {
+		query = append(query, "nonvoters")
+	}
+	responseBody, err := conn.rqliteApiGet(ctx, api_NODES, query...)
+	if err != nil {
+		return nil, err
+	}
+	return parseNodes(responseBody)
+}
+
+// Ready tells whether the first node that answers is ready to serve
+// requests. A node that is not ready is not an error: the failed checks are
+// listed in the returned Readiness.
+func (conn *Connection) Ready(ctx context.Context) (Readiness, error) {
+	if conn.isClosed() {
+		return Readiness{}, ErrClosed
+	}
+	responseBody, err := conn.rqliteApiGet(ctx, api_READY)
+	if err != nil {
+		return Readiness{}, err
+	}
+	return parseReadiness(responseBody), nil
+}
+
+// jsonUint64 decodes a number that older servers encode as a string.
+type jsonUint64 uint64
+
+func (u *jsonUint64) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*u = 0
+		return nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*u = jsonUint64(v)
+	return nil
+}
+
+// statusResponse is the part of /status that is decoded into a Status.
+type statusResponse struct {
+	Build struct {
+		Version   string `json:"version"`
+		Commit    string `json:"commit"`
+		Branch    string `json:"branch"`
+		BuildTime string `json:"build_time"`
+	} `json:"build"`
+	Store struct {
+		NodeID string          `json:"node_id"`
+		Addr   string          `json:"addr"`
+		Leader json.RawMessage `json:"leader"`
+		Raft   struct {
+			State        string     `json:"state"`
+			Term         jsonUint64 `json:"term"`
+			AppliedIndex jsonUint64 `json:"applied_index"`
+			CommitIndex  jsonUint64 `json:"commit_index"`
+			LastLogIndex jsonUint64 `json:"last_log_index"`
+			NumPeers     jsonUint64 `json:"num_peers"`
+			Voter        *bool      `json:"voter"`
+		} `json:"raft"`
+		Nodes []struct {
+			ID       string `json:"id"`
+			Suffrage string `json:"suffrage"`
+		} `json:"nodes"`
+		Metadata map[string]struct {
+			APIAddr string `json:"api_addr"`
+		} `json:"metadata"`
+	} `json:"store"`
+}
+
+func parseStatus(responseBody []byte) (Status, error) {
+	var sr statusResponse
+	if err := json.Unmarshal(responseBody, &sr); err != nil {
+		return Status{}, fmt.Errorf("%w: could not unmarshal /status response: %v", ErrInvalidResponse, err)
+	}
+	st := Status{
+		Build: BuildInfo{
+			Version:   sr.Build.Version,
+			Commit:    sr.Build.Commit,
+			Branch:    sr.Build.Branch,
+			BuildTime: sr.Build.BuildTime,
+		},
+		NodeID: sr.Store.NodeID,
+		Addr:   sr.Store.Addr,
+		Raft: RaftStatus{
+			State:        sr.Store.Raft.State,
+			Term:         uint64(sr.Store.Raft.Term),
+			AppliedIndex: uint64(sr.Store.Raft.AppliedIndex),
+			CommitIndex:  uint64(sr.Store.Raft.CommitIndex),
+			LastLogIndex: uint64(sr.Store.Raft.LastLogIndex),
+			NumPeers:     uint64(sr.Store.Raft.NumPeers),
+		},
+	}
+
+	// nodes are voters unless stated otherwise
+	st.Voter = true
+	if sr.Store.Raft.Voter != nil {
+		st.Voter = *sr.Store.Raft.Voter
+	}
+	for _, n := range sr.Store.Nodes {
+		if n.ID == st.NodeID && n.Suffrage != "" {
+			st.Voter = n.Suffrage == "Voter"
+		}
+	}
+
+	// the leader is an object since 6.x, the raft address before
+	if len(sr.Store.Leader) > 0 {
+		var leader struct {
+			NodeID string `json:"node_id"`
+			Addr   string `json:"addr"`
+		}
+		if err := json.Unmarshal(sr.Store.Leader, &leader); err == nil {
+			st.Leader.NodeID = leader.NodeID
+			st.Leader.Addr = leader.Addr
+		} else if err := json.Unmarshal(sr.Store.Leader, &st.Leader.Addr); err != nil {
+			return Status{}, fmt.Errorf("%w: unexpected leader in /status response: %s", ErrInvalidResponse, sr.Store.Leader)
+		}
+	}
+	// in 5.x the metadata maps the raft addresses to the HTTP addresses
+	if md, ok := sr.Store.Metadata[st.Leader.Addr]; ok {
+		st.Leader.APIAddr = md.APIAddr
+	}
+
+	if err := json.Unmarshal(responseBody, &st.Raw); err != nil {
+		return Status{}, fmt.Errorf("%w: could not unmarshal /status response: %v", ErrInvalidResponse, err)
+	}
+	return st, nil
+}
+
+// nodeResponse is a node in the /nodes response.
+type nodeResponse struct {
+	ID        string  `json:"id"`
+	APIAddr   string  `json:"api_addr"`
+	Addr      string  `json:"addr"`
+	Voter     *bool   `json:"voter"`
+	Reachable bool    `json:"reachable"`
+	Leader    bool    `json:"leader"`
+	Time      float64 `json:"time"`
+	Error     string  `json:"error"`
+}
+
+func parseNodes(responseBody []byte) ([]Node, error) {
+	// a map of nodes by ID, or {"nodes": [...]} with ver=2
+	var byID map[string]json.RawMessage
+	if err := json.Unmarshal(responseBody, &byID); err != nil {
+		return nil, fmt.Errorf("%w: could not unmarshal /nodes response: %v", ErrInvalidResponse, err)
+	}
+	var list []nodeResponse
+	if raw, ok := byID["nodes"]; ok && len(byID) == 1 && strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, fmt.Errorf("%w: could not unmarshal /nodes response: %v", ErrInvalidResponse, err)
+		}
+	} else {
+		for id, raw := range byID {
+			var n nodeResponse
+			if err := json.Unmarshal(raw, &n); err != nil {
+				return nil, fmt.Errorf("%w: could not unmarshal node %s: %v", ErrInvalidResponse, id, err)
+			}
+			n.ID = id
+			list = append(list, n)
+		}
+	}
+
+	nodes := make([]Node, 0, len(list))
+	for _, n := range list {
+		node := Node{
+			ID:        n.ID,
+			APIAddr:   n.APIAddr,
+			Addr:      n.Addr,
+			Voter:     true,
+			Reachable: n.Reachable,
+			Leader:    n.Leader,
+			Time:      n.Time,
+			Error:     n.Error,
+		}
+		if n.Voter != nil {
+			node.Voter = *n.Voter
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes, nil
+}
+
+// parseReadiness parses the /readyz response, made of lines like
+// "[+]node ok" or "[-]leader not ok: ...".
+func parseReadiness(responseBody []byte) Readiness {
+	r := Readiness{
+		Ready:   true,
+		Checks:  make(map[string]bool),
+		Message: strings.TrimSpace(string(responseBody)),
+	}
+	for _, line := range strings.Split(r.Message, "\n") {
+		line = strings.TrimSpace(line)
+		var ok bool
+		switch {
+		case strings.HasPrefix(line, "[+]"):
+			ok = true
+		case strings.HasPrefix(line, "[-]"):
+			ok = false
+		default:
+			continue
+		}
+		fields := strings.Fields(line[3:])
+		if len(fields) == 0 {
+			continue
+		}
+		check := strings.TrimSuffix(fields[0], ":")
+		r.Checks[check] = ok
+		if !ok {
+			r.Ready = false
+		}
+	}
+	if len(r.Checks) == 0 {
+		r.Ready = false
+	}
+	return r
+}
+
+// apiPeer returns the peer of the given API address, e.g. host1:4001 for
+// http://host1:4001, using the default port if the address has none.
+func apiPeer(apiAddr string) (peer, error) {
+	u, err := url.Parse(apiAddr)
+	if err != nil {
+		return "", errors.New("could not parse API address")
+	}
+	if _, _, err = net.SplitHostPort(u.Host); err != nil {
+		return peer(fmt.Sprintf("%s:%d", u.Host, 4001)), nil
+	}
+	return peer(u.Host), nil
+}
@@ -0,0 +1,97 @@
+package gorqlite
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	body, err := os.ReadFile("integration/assets/three_node_cluster_status.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := parseStatus(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requireString(t, "v7.9.2", st.Build.Version)
+	requireString(t, "rqlite-0", st.NodeID)
+	requireString(t, "Follower", st.Raft.State)
+	requireInt(t, 13, int(st.Raft.AppliedIndex))
+	requireInt(t, 4, int(st.Raft.Term))
+	requireBool(t, true, st.Voter)
+	requireString(t, "rqlite-2", st.Leader.NodeID)
+	requireString(t, "localhost:14002", st.Leader.Addr)
+	requireBool(t, true, st.Raw["runtime"] != nil)
+
+	// 5.x: raft stats as strings, leader as raft address with metadata
+	st, err = parseStatus([]byte(`{
+		"build": {"version": "5.12.1"},
+		"store": {
+			"leader": "host1:4002",
+			"metadata": {"host1:4002": {"api_addr": "host1:4001", "api_proto": "http"}},
+			"raft": {"state": "Leader", "applied_index": "42"}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	requireInt(t, 42, int(st.Raft.AppliedIndex))
+	requireString(t, "host1:4002", st.Leader.Addr)
+	requireString(t, "host1:4001", st.Leader.APIAddr)
+
+	// read-only node
+	st, err = parseStatus([]byte(`{"store": {"node_id": "ro1", "nodes": [{"id": "ro1", "suffrage": "Nonvoter"}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	requireBool(t, false, st.Voter)
+}
+
+func TestParseNodes(t *testing.T) {
+	for name, body := range map[string]string{
+		"map": `{
+			"2": {"api_addr": "http://host2:4001", "addr": "host2:4002", "reachable": true, "leader": true, "voter": true},
+			"1": {"api_addr": "http://host1:4001", "addr": "host1:4002", "reachable": true, "leader": false},
+			"3": {"api_addr": "http://host3:4001", "addr": "host3:4002", "reachable": false, "voter": false, "error": "connection refused"}
+		}`,
+		"list": `{"nodes": [
+			{"id": "3", "api_addr": "http://host3:4001", "addr": "host3:4002", "reachable": false, "voter": false, "error": "connection refused"},
+			{"id": "2", "api_addr": "http://host2:4001", "addr": "host2:4002", "reachable": true, "leader": true, "voter": true},
+			{"id": "1", "api_addr": "http://host1:4001", "addr": "host1:4002", "reachable": true}
+		]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			nodes, err := parseNodes([]byte(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			requireInt(t, 3, len(nodes))
+			requireString(t, "1", nodes[0].ID)
+			requireBool(t, true, nodes[0].Voter)
+			requireBool(t, true, nodes[1].Leader)
+			requireString(t, "http://host2:4001", nodes[1].APIAddr)
+			requireBool(t, false, nodes[2].Reachable)
+			requireBool(t, false, nodes[2].Voter)
+			requireString(t, "connection refused", nodes[2].Error)
+		})
+	}
+
+	if _, err := parseNodes([]byte(`[1, 2]`)); err == nil {
+		t.Errorf("expected error for invalid response")
+	}
+}
+
+func TestParseReadiness(t *testing.T) {
+	r := parseReadiness([]byte("[+]node ok\n[+]leader ok\n[+]store ok\n"))
+	requireBool(t, true, r.Ready)
+	requireInt(t, 3, len(r.Checks))
+
+	r = parseReadiness([]byte("[+]node ok\n[-]leader not ok: no leader\n[+]store ok"))
+	requireBool(t, false, r.Ready)
+	requireBool(t, false, r.Checks["leader"])
+	requireBool(t, true, r.Checks["store"])
+
+	r = parseReadiness(nil)
+	requireBool(t, false, r.Ready)
+}
@@ -0,0 +1,190 @@
+package gorqlite
+
+// this file contains the TLS setup of connections:
+//
+//   buildTLSConfig() loads the CA bundle and client certificate files
+//   reloadingTransport swaps its transport when those files change on disk
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultTLSReloadInterval is how often the TLS files are checked for changes
+const defaultTLSReloadInterval = 10 * time.Second
+
+// hasTLSFiles returns true if the config references certificate files.
+func (c *Config) hasTLSFiles() bool {
+	return c.TLSCAFile != "" || c.TLSCertFile != "" || c.TLSKeyFile != ""
+}
+
+// hasTLSSettings returns true if the config needs a custom TLS configuration.
+func (c *Config) hasTLSSettings() bool {
+	return c.TLSConfig != nil || c.hasTLSFiles() || c.TLSServerName != "" || c.TLSInsecureSkipVerify
+}
+
+// buildTLSConfig creates the TLS configuration for the given config, loading
+// the CA bundle and client certificate from disk.
+func buildTLSConfig(c *Config) (*tls.Config, error) {
+	var tlsConfig *tls.Config
+	if c.TLSConfig != nil {
+		tlsConfig = c.TLSConfig.Clone()
+	} else {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if c.TLSCAFile != "" {
+		pem, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in CA bundle %s", c.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.TLSCertFile != "" || c.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.TLSServerName != "" {
+		tlsConfig.ServerName = c.TLSServerName
+	}
+	if c.TLSInsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
+
+// fileStamp identifies a version of a file on disk
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFiles(paths ...string) ([]fileStamp, error) {
+	stamps := make([]fileStamp, len(paths))
+	for i, p := range paths {
+		if p == "" {
+			continue
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		stamps[i] = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+	}
+	return stamps, nil
+}
+
+// reloadingTransport is an http.RoundTripper that rebuilds its underlying
+// transport whenever the TLS files of the config change on disk, so that
+// rotated certificates are picked up without recreating the Connection.
+//
+// Files are checked at most once per interval. If the new files cannot be
+// loaded (e.g. the certificate was rewritten but not the key yet), the
+// previous transport is kept and loading is attempted again on the next
+// check.
+type reloadingTransport struct {
+	cfg      Config
+	build    func(*tls.Config) *http.Transport
+	interval time.Duration
+
+	mu        sync.Mutex
+	current   *http.Transport
+	stamps    []fileStamp
+	lastCheck time.Time
+}
+
+func newReloadingTransport(cfg *Config, build func(*tls.Config) *http.Transport) (*reloadingTransport, error) {
+	rt := &reloadingTransport{
+		cfg:      *cfg,
+		build:    build,
+		interval: defaultTLSReloadInterval,
+	}
+	// fail early if the files can't be loaded
+	if _, err := rt.transport(); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+func (rt *reloadingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t, err := rt.transport()
+	if err != nil {
+		return nil, err
+	}
+	return t.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of the current transport.
+func (rt *reloadingTransport) CloseIdleConnections() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.current != nil {
+		rt.current.CloseIdleConnections()
+	}
+}
+
+// transport returns the current transport, reloading the TLS files first if
+// they changed.
+func (rt *reloadingTransport) transport() (*http.Transport, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	now := time.Now()
+	if rt.current != nil && now.Sub(rt.lastCheck) < rt.interval {
+		return rt.current, nil
+	}
+	rt.lastCheck = now
+
+	stamps, err := statFiles(rt.cfg.TLSCAFile, rt.cfg.TLSCertFile, rt.cfg.TLSKeyFile)
+	if err == nil && rt.current != nil && equalStamps(stamps, rt.stamps) {
+		return rt.current, nil
+	}
+
+	var tlsConfig *tls.Config
+	if err == nil {
+		tlsConfig, err = buildTLSConfig(&rt.cfg)
+	}
+	if err != nil {
+		if rt.current != nil {
+			trace("reloading TLS files failed, keeping the previous ones: %v", err)
+			return rt.current, nil
+		}
+		return nil, err
+	}
+
+	trace("TLS files loaded")
+	old := rt.current
+	rt.current = rt.build(tlsConfig)
+	rt.stamps = stamps
+	if old != nil {
+		old.CloseIdleConnections()
+	}
+	return rt.current, nil
+}
+
+func equalStamps(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
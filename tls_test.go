@@ -0,0 +1,170 @@
+package gorqlite
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         isCA,
+		DNSNames:     []string{"localhost", "rqlite.internal"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+
+		BasicConstraintsValid: true,
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadingTransport(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil, true)
+	server := newTestCert(t, "rqlite", ca, false)
+	clientA := newTestCert(t, "client-a", ca, false)
+	clientB := newTestCert(t, "client-b", ca, false)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	serverCert, err := tls.X509KeyPair(server.certPEM, server.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	now := time.Now()
+	writeFile(t, caFile, ca.certPEM, now)
+	writeFile(t, certFile, clientA.certPEM, now)
+	writeFile(t, keyFile, clientA.keyPEM, now)
+
+	cfg := NewConfig()
+	dsn := "https://" + srv.Listener.Addr().String() +
+		"?tlsCA=" + caFile + "&tlsCert=" + certFile + "&tlsKey=" + keyFile + "&tlsServerName=rqlite.internal"
+	if err := cfg.ParseDSN(dsn); err != nil {
+		t.Fatal(err)
+	}
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := client.Transport
+
+	get := func() string {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		buf := make([]byte, 64)
+		n, _ := resp.Body.Read(buf)
+		return string(buf[:n])
+	}
+	requireString(t, "client-a", get())
+
+	// rotate the client certificate
+	later := now.Add(time.Minute)
+	writeFile(t, certFile, clientB.certPEM, later)
+	writeFile(t, keyFile, clientB.keyPEM, later)
+
+	// not reloaded before the check interval elapses
+	requireString(t, "client-a", get())
+
+	rt.(*reloadingTransport).interval = 0
+	requireString(t, "client-b", get())
+
+	// a half-written rotation keeps the previous certificate
+	writeFile(t, certFile, clientA.certPEM, later.Add(time.Minute))
+	requireString(t, "client-b", get())
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	for _, dsn := range []string{
+		"http://localhost:4001?tlsCA=/etc/ssl/ca.pem",
+		"https://localhost:4001?tlsCert=/etc/ssl/tls.crt",
+		"https://localhost:4001?tlsCA=/does/not/exist.pem",
+		"https://localhost:4001?tlsInsecureSkipVerify=maybe",
+	} {
+		var conn Connection
+		if err := conn.initConnection(dsn); err == nil {
+			t.Errorf("expected error for %s", dsn)
+		}
+	}
+
+	var conn Connection
+	if err := conn.initConnection("https://localhost:4001?tlsInsecureSkipVerify=true&tlsServerName=rqlite"); err != nil {
+		t.Fatal(err)
+	}
+	tr := conn.client.Transport.(*http.Transport)
+	requireBool(t, true, tr.TLSClientConfig.InsecureSkipVerify)
+	requireString(t, "rqlite", tr.TLSClientConfig.ServerName)
+}
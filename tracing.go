@@ -0,0 +1,186 @@
+package gorqlite
+
+// this file contains distributed tracing support:
+//
+//   SpanContext and the W3C trace context encoding (traceparent/tracestate)
+//   Propagator, which injects trace headers into outgoing requests
+//   SpanTracer and Span, a minimal interface to emit client spans
+//
+// gorqlite has no external dependencies, so these types are deliberately
+// small: adapting them to OpenTelemetry or any other tracing library only
+// takes a few lines in the caller's code.
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// SpanContext identifies a span within a distributed trace, as carried by
+// the W3C trace context headers. See https://www.w3.org/TR/trace-context/
+type SpanContext struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	Flags      byte   // trace flags, 0x01 means sampled
+	TraceState string // vendor specific tracestate, passed as-is
+}
+
+// IsValid returns true if both the trace ID and the span ID are non-zero.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// IsSampled returns true if the sampled flag is set.
+func (sc SpanContext) IsSampled() bool {
+	return sc.Flags&0x01 == 0x01
+}
+
+// Traceparent returns the value of the traceparent header for this span
+// context, e.g.
+//
+//	00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x",
+		hex.EncodeToString(sc.TraceID[:]),
+		hex.EncodeToString(sc.SpanID[:]),
+		sc.Flags)
+}
+
+// ParseTraceparent parses the values of the traceparent and tracestate
+// headers into a SpanContext.
+func ParseTraceparent(traceparent, tracestate string) (SpanContext, error) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 {
+		return sc, fmt.Errorf("invalid traceparent: %s", traceparent)
+	}
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("invalid traceparent version: %s", traceparent)
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("invalid traceparent: %s", traceparent)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("invalid trace id: %v", err)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("invalid span id: %v", err)
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, fmt.Errorf("invalid trace flags: %v", err)
+	}
+	sc.Flags = flags[0]
+	sc.TraceState = strings.TrimSpace(tracestate)
+
+	if !sc.IsValid() {
+		return sc, errors.New("invalid traceparent: zero trace or span id")
+	}
+	return sc, nil
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx carrying the given SpanContext.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the SpanContext carried by ctx, if any.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// Propagator injects trace headers taken from a context into the headers of
+// an outgoing HTTP request. It is called once for every request sent to an
+// rqlite node.
+type Propagator interface {
+	Inject(ctx context.Context, header http.Header)
+}
+
+// PropagatorFunc adapts an ordinary function to the Propagator interface.
+// With OpenTelemetry for instance:
+//
+//	gorqlite.PropagatorFunc(func(ctx context.Context, h http.Header) {
+//	    otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(h))
+//	})
+type PropagatorFunc func(ctx context.Context, header http.Header)
+
+func (f PropagatorFunc) Inject(ctx context.Context, header http.Header) {
+	f(ctx, header)
+}
+
+// TraceContextPropagator is a Propagator that writes the W3C traceparent and
+// tracestate headers from the SpanContext found in the context (see
+// ContextWithSpanContext). Nothing is written if the context carries no
+// valid SpanContext.
+type TraceContextPropagator struct{}
+
+func (TraceContextPropagator) Inject(ctx context.Context, header http.Header) {
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok {
+		return
+	}
+	header.Set(traceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		header.Set(tracestateHeader, sc.TraceState)
+	}
+}
+
+// SpanTracer emits client spans. gorqlite starts one span per API call
+// (named after the operation, e.g. "gorqlite.query") and one child span per
+// attempt to contact a peer ("gorqlite.attempt").
+//
+// The context returned by StartSpan is the one used to build the HTTP request
+// and passed to the Propagator: tracers that want their span to be the parent
+// of the server-side work should store its SpanContext in the returned
+// context with ContextWithSpanContext.
+type SpanTracer interface {
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation started by a SpanTracer.
+type Span interface {
+	// SetAttribute records a key/value pair on the span
+	SetAttribute(key string, value interface{})
+	// End completes the span. err is nil if the operation succeeded.
+	End(err error)
+}
+
+type noopSpanTracer struct{}
+
+func (noopSpanTracer) StartSpan(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) End(error)                        {}
+
+// startSpan starts a span with the connection's span tracer, if any.
+func (conn *Connection) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if conn.spanTracer == nil {
+		return noopSpanTracer{}.StartSpan(ctx, name)
+	}
+	return conn.spanTracer.StartSpan(ctx, name)
+}
+
+// injectTraceHeaders adds the trace headers to the request, if a propagator
+// was configured.
+func (conn *Connection) injectTraceHeaders(ctx context.Context, req *http.Request) {
+	if conn.propagator == nil {
+		return
+	}
+	conn.propagator.Inject(ctx, req.Header)
+}
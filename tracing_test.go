@@ -0,0 +1,90 @@
+package gorqlite
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		wantErr     bool
+	}{
+		{
+			name:        "valid sampled",
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			name:        "valid not sampled",
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		},
+		{
+			name:        "empty",
+			traceparent: "",
+			wantErr:     true,
+		},
+		{
+			name:        "invalid version",
+			traceparent: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantErr:     true,
+		},
+		{
+			name:        "short trace id",
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+			wantErr:     true,
+		},
+		{
+			name:        "zero trace id",
+			traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			wantErr:     true,
+		},
+		{
+			name:        "not hex",
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902zz-01",
+			wantErr:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(test.traceparent, "")
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := sc.Traceparent(); got != test.traceparent {
+				t.Errorf("got %s, want %s", got, test.traceparent)
+			}
+		})
+	}
+}
+
+func TestTraceContextPropagator(t *testing.T) {
+	p := TraceContextPropagator{}
+
+	h := http.Header{}
+	p.Inject(context.Background(), h)
+	if len(h) != 0 {
+		t.Errorf("expected no header without span context, got %v", h)
+	}
+
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "congo=t61rcWkgMzE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.IsSampled() {
+		t.Errorf("expected span context to be sampled")
+	}
+
+	h = http.Header{}
+	p.Inject(ContextWithSpanContext(context.Background(), sc), h)
+	requireString(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", h.Get("traceparent"))
+	requireString(t, "congo=t61rcWkgMzE", h.Get("tracestate"))
+}
@@ -0,0 +1,139 @@
+package gorqlite
+
+// this file contains the HTTP transport owned by each Connection:
+//
+//   TransportConfig
+//   newHTTPClient() builds the client shared by all requests of a Connection
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxIdleConns          = 100
+	defaultMaxIdleConnsPerHost   = 32
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultDialTimeout           = 5 * time.Second
+	defaultKeepAlive             = 30 * time.Second
+	defaultTLSHandshakeTimeout   = 5 * time.Second
+	defaultResponseHeaderTimeout = 30 * time.Second
+)
+
+// TransportConfig tunes the HTTP transport owned by a Connection. The
+// transport keeps a pool of keep-alive connections per peer, shared by all
+// requests of the Connection. Zero values mean defaults.
+type TransportConfig struct {
+	// MaxIdleConns limits the idle connections over all peers, default 100
+	MaxIdleConns int
+	// MaxIdleConnsPerHost limits the idle connections kept per peer, default 32
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits the connections per peer, default no limit
+	MaxConnsPerHost int
+	// IdleConnTimeout closes connections idle for longer, default 90s
+	IdleConnTimeout time.Duration
+	// DialTimeout limits the time to establish a TCP connection, default 5s
+	DialTimeout time.Duration
+	// KeepAlive is the TCP keep-alive period, default 30s
+	KeepAlive time.Duration
+	// TLSHandshakeTimeout limits the TLS handshake, default 5s
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout limits the wait for the response headers once
+	// the request is sent, default 30s
+	ResponseHeaderTimeout time.Duration
+	// HTTP2 attempts to use HTTP/2 with https peers
+	HTTP2 bool
+}
+
+func (tc TransportConfig) withDefaults() TransportConfig {
+	if tc.MaxIdleConns == 0 {
+		tc.MaxIdleConns = defaultMaxIdleConns
+	}
+	if tc.MaxIdleConnsPerHost == 0 {
+		tc.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if tc.IdleConnTimeout == 0 {
+		tc.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if tc.DialTimeout == 0 {
+		tc.DialTimeout = defaultDialTimeout
+	}
+	if tc.KeepAlive == 0 {
+		tc.KeepAlive = defaultKeepAlive
+	}
+	if tc.TLSHandshakeTimeout == 0 {
+		tc.TLSHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+	if tc.ResponseHeaderTimeout == 0 {
+		tc.ResponseHeaderTimeout = defaultResponseHeaderTimeout
+	}
+	return tc
+}
+
+func (tc TransportConfig) validate() error {
+	if tc.MaxIdleConns < 0 || tc.MaxIdleConnsPerHost < 0 || tc.MaxConnsPerHost < 0 ||
+		tc.IdleConnTimeout < 0 || tc.DialTimeout < 0 || tc.KeepAlive < 0 ||
+		tc.TLSHandshakeTimeout < 0 || tc.ResponseHeaderTimeout < 0 {
+		return errors.New("invalid transport config: negative value")
+	}
+	return nil
+}
+
+// buildTransport creates an http.Transport with the given settings.
+func buildTransport(tc TransportConfig, tlsConfig *tls.Config) *http.Transport {
+	tc = tc.withDefaults()
+	dialer := &net.Dialer{
+		Timeout:   tc.DialTimeout,
+		KeepAlive: tc.KeepAlive,
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          tc.MaxIdleConns,
+		MaxIdleConnsPerHost:   tc.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       tc.MaxConnsPerHost,
+		IdleConnTimeout:       tc.IdleConnTimeout,
+		TLSHandshakeTimeout:   tc.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: tc.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     tc.HTTP2,
+	}
+}
+
+// newHTTPClient creates the http client used for all requests of a
+// connection with the given config. If certificate files are configured, its
+// transport rebuilds itself when they change on disk.
+func newHTTPClient(cfg *Config) (*http.Client, error) {
+	if err := cfg.Transport.validate(); err != nil {
+		return nil, err
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, errors.New("both a client certificate and a key file are required for mutual TLS")
+	}
+
+	build := func(tlsConfig *tls.Config) *http.Transport {
+		return buildTransport(cfg.Transport, tlsConfig)
+	}
+
+	if cfg.hasTLSFiles() {
+		rt, err := newReloadingTransport(cfg, build)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{Transport: rt}, nil
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.hasTLSSettings() {
+		var err error
+		tlsConfig, err = buildTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &http.Client{Transport: build(tlsConfig)}, nil
+}
@@ -0,0 +1,35 @@
+package gorqlite
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildTransport(t *testing.T) {
+	tr := buildTransport(TransportConfig{}, nil)
+	requireInt(t, defaultMaxIdleConnsPerHost, tr.MaxIdleConnsPerHost)
+	requireDuration(t, defaultIdleConnTimeout, tr.IdleConnTimeout)
+	requireDuration(t, defaultResponseHeaderTimeout, tr.ResponseHeaderTimeout)
+	requireBool(t, false, tr.ForceAttemptHTTP2)
+
+	tr = buildTransport(TransportConfig{MaxIdleConnsPerHost: 4, ResponseHeaderTimeout: time.Second, HTTP2: true}, nil)
+	requireInt(t, 4, tr.MaxIdleConnsPerHost)
+	requireDuration(t, time.Second, tr.ResponseHeaderTimeout)
+	requireBool(t, true, tr.ForceAttemptHTTP2)
+
+	if err := (TransportConfig{DialTimeout: -1}).validate(); err == nil {
+		t.Errorf("expected error for negative timeout")
+	}
+}
+
+func TestConnectionOwnsClient(t *testing.T) {
+	var conn Connection
+	if err := conn.initConnection("http://localhost:4001"); err != nil {
+		t.Fatal(err)
+	}
+	requireBool(t, true, conn.ownsClient)
+	if conn.client == nil || conn.client.Transport == nil {
+		t.Fatalf("expected the connection to own a client with a transport")
+	}
+	conn.Close()
+}
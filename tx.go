@@ -0,0 +1,204 @@
+package gorqlite
+
+// this file contains the transaction builder:
+//
+//   Connection.Begin() and Tx
+//   Tx.Exec(), Tx.Query(), Tx.ExecReturning() and Tx.Guard() adding statements
+//   Tx.Commit() sending them in a single /db/request call
+//   TxExec and TxQuery, the handles of the statements
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTxDone is returned when committing a transaction twice, or adding
+	// statements to a committed one.
+	ErrTxDone = errors.New("gorqlite: transaction already committed")
+
+	// ErrTxNotCommitted is returned by the handles of the statements of a
+	// transaction that was not committed, or that failed before reaching
+	// the statement.
+	ErrTxNotCommitted = errors.New("gorqlite: transaction not committed")
+
+	// ErrGuardFailed is returned, wrapped, when a guard statement aborted the
+	// transaction.
+	ErrGuardFailed = errors.New("gorqlite: transaction guard failed")
+)
+
+// Tx builds a transaction: its statements are sent to rqlite in a single
+// /db/request call by Commit, and executed atomically.
+//
+//	tx := conn.Begin()
+//	tx.Guard(1, "SELECT 1 FROM accounts WHERE id = ? AND balance >= ?", from, amount)
+//	debit := tx.Exec("UPDATE accounts SET balance = balance - ? WHERE id = ?", amount, from)
+//	tx.Exec("UPDATE accounts SET balance = balance + ? WHERE id = ?", amount, to)
+//	balance := tx.Query("SELECT balance FROM accounts WHERE id = ?", from)
+//	err := tx.Commit(ctx)
+//
+// A Tx is not safe for concurrent use.
+type Tx struct {
+	conn    *Connection
+	stmts   []Statement
+	handles []txHandle
+	guards  map[int]bool
+	done    bool
+}
+
+// txHandle receives the result of a statement of the transaction.
+type txHandle interface {
+	set(rr RequestResult)
+}
+
+// TxExec is the handle of a statement added with Tx.Exec().
+type TxExec struct {
+	result WriteResult
+	err    error
+}
+
+func (h *TxExec) set(rr RequestResult) {
+	h.result, h.err = rr.Write, rr.Err
+}
+
+// Result returns the result of the statement once the transaction is
+// committed.
+func (h *TxExec) Result() (WriteResult, error) {
+	return h.result, h.err
+}
+
+// TxQuery is the handle of a statement added with Tx.Query() or
+// Tx.ExecReturning().
+type TxQuery struct {
+	result QueryResult
+	err    error
+}
+
+func (h *TxQuery) set(rr RequestResult) {
+	h.result, h.err = rr.Query, rr.Err
+}
+
+// Result returns the rows of the statement once the transaction is
+// committed.
+func (h *TxQuery) Result() (QueryResult, error) {
+	return h.result, h.err
+}
+
+// Begin starts building a transaction. The call options apply to the commit.
+func (conn *Connection) Begin(opts ...CallOption) *Tx {
+	opts = append(opts[:len(opts):len(opts)], CallTransaction(true))
+	return &Tx{
+		conn:   conn.With(opts...),
+		guards: make(map[int]bool),
+	}
+}
+
+func (tx *Tx) add(stmt Statement, h txHandle) {
+	if tx.done {
+		// the handle keeps its ErrTxDone
+		return
+	}
+	tx.stmts = append(tx.stmts, stmt)
+	tx.handles = append(tx.handles, h)
+}
+
+// Exec adds a statement that modifies the database.
+func (tx *Tx) Exec(query string, args ...interface{}) *TxExec {
+	h := &TxExec{err: tx.pendingErr()}
+	tx.add(Statement{Query: query, Arguments: args}, h)
+	return h
+}
+
+// ExecReturning adds a statement with a RETURNING clause, whose rows are
+// returned by the handle.
+func (tx *Tx) ExecReturning(query string, args ...interface{}) *TxQuery {
+	h := &TxQuery{err: tx.pendingErr()}
+	tx.add(Statement{Query: query, Arguments: args, Returning: true}, h)
+	return h
+}
+
+// Query adds a query, which sees the changes of the previous statements.
+func (tx *Tx) Query(query string, args ...interface{}) *TxQuery {
+	h := &TxQuery{err: tx.pendingErr()}
+	tx.add(Statement{Query: query, Arguments: args}, h)
+	return h
+}
+
+// Guard adds a precondition: the transaction is aborted, and Commit fails
+// with ErrGuardFailed, unless the query returns exactly expectedRows rows
+// when the guard is reached.
+//
+// SQLite only allows RAISE(ABORT) in triggers: the guard makes its statement
+// fail with a runtime error instead, which rolls back the transaction.
+func (tx *Tx) Guard(expectedRows int64, query string, args ...interface{}) {
+	if tx.done {
+		return
+	}
+	tx.guards[len(tx.stmts)] = true
+	tx.add(guardStatement(expectedRows, query, args), &TxQuery{})
+}
+
+// guardStatement returns the statement failing unless the query returns the
+// expected number of rows. json() fails on the malformed JSON it is given,
+// which depends on the count so that SQLite doesn't evaluate it up front.
+func guardStatement(expectedRows int64, query string, args []interface{}) Statement {
+	return Statement{
+		Query: "SELECT CASE WHEN n = ? THEN n ELSE json('gorqlite guard failed: ' || n || ' rows') END " +
+			"FROM (SELECT COUNT(*) AS n FROM (" + query + "))",
+		Arguments: append([]interface{}{expectedRows}, args...),
+	}
+}
+
+func (tx *Tx) pendingErr() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	return ErrTxNotCommitted
+}
+
+// Len returns the number of statements of the transaction, guards included.
+func (tx *Tx) Len() int {
+	return len(tx.stmts)
+}
+
+// Commit sends the statements of the transaction in a single request and
+// sets the results of their handles. If a statement fails, the transaction
+// is rolled back and its error returned: only the handle of that statement is
+// set, the others keep ErrTxNotCommitted.
+func (tx *Tx) Commit(ctx context.Context) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	if len(tx.stmts) == 0 {
+		return nil
+	}
+
+	trace("%s: Commit() for %d statements", tx.conn.ID, len(tx.stmts))
+	results, err := tx.conn.RequestParameterizedContext(ctx, tx.stmts)
+	if err != nil && len(results) == 1 && results[0].Err == err {
+		// the call itself failed
+		return err
+	}
+	for i, rr := range results {
+		if rr.Err == nil || i >= len(tx.handles) {
+			continue
+		}
+		tx.handles[i].set(rr)
+		if tx.guards[i] {
+			return fmt.Errorf("%w: statement %d: %v", ErrGuardFailed, i, rr.Err)
+		}
+		return fmt.Errorf("statement %d %q: %w", i, tx.stmts[i].Query, rr.Err)
+	}
+	if err != nil {
+		return err
+	}
+	if len(results) != len(tx.handles) {
+		return fmt.Errorf("expected %d results, got %d", len(tx.handles), len(results))
+	}
+	for i, rr := range results {
+		tx.handles[i].set(rr)
+	}
+	return nil
+}
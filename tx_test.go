@@ -0,0 +1,47 @@
+package gorqlite
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestGuardStatement(t *testing.T) {
+	stmt := guardStatement(1, "SELECT 1 FROM accounts WHERE id = ?", []interface{}{42})
+	requireString(t, "SELECT CASE WHEN n = ? THEN n ELSE json('gorqlite guard failed: ' || n || ' rows') END "+
+		"FROM (SELECT COUNT(*) AS n FROM (SELECT 1 FROM accounts WHERE id = ?))", stmt.Query)
+	requireInt(t, 2, len(stmt.Arguments))
+	requireInt(t, 1, int(stmt.Arguments[0].(int64)))
+	requireInt(t, 42, stmt.Arguments[1].(int))
+}
+
+func TestTxBuilder(t *testing.T) {
+	conn := &Connection{connShared: &connShared{}}
+	tx := conn.Begin(CallTransaction(false))
+	requireBool(t, true, tx.conn.wantsTransactions)
+
+	tx.Guard(1, "SELECT 1 FROM accounts WHERE id = ?", 1)
+	exec := tx.Exec("UPDATE accounts SET balance = balance - 1 WHERE id = ?", 1)
+	query := tx.Query("SELECT balance FROM accounts WHERE id = ?", 1)
+	requireInt(t, 3, tx.Len())
+	requireBool(t, true, tx.guards[0])
+
+	if _, err := exec.Result(); !errors.Is(err, ErrTxNotCommitted) {
+		t.Errorf("expected ErrTxNotCommitted, got %v", err)
+	}
+	if _, err := query.Result(); !errors.Is(err, ErrTxNotCommitted) {
+		t.Errorf("expected ErrTxNotCommitted, got %v", err)
+	}
+
+	tx = conn.Begin()
+	if err := tx.Commit(context.Background()); err != nil {
+		t.Errorf("an empty transaction should commit, got %v", err)
+	}
+	if err := tx.Commit(context.Background()); !errors.Is(err, ErrTxDone) {
+		t.Errorf("expected ErrTxDone, got %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM accounts").Result(); !errors.Is(err, ErrTxDone) {
+		t.Errorf("expected ErrTxDone, got %v", err)
+	}
+	requireInt(t, 0, tx.Len())
+}
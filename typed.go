@@ -0,0 +1,161 @@
+package gorqlite
+
+// this file contains the typed query helpers:
+//
+//   QueryAs() scanning the rows into a slice of T
+//   QueryOneAs() scanning the first row into a T
+//   QueryScalar() scanning the single value of e.g. a COUNT(*)
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// QueryAs runs the query and scans its rows into values of type T.
+//
+// If T is a struct, other than time.Time, Null[T] or a sql.Scanner such as
+// sql.NullString, each column is scanned
+// into the exported field of the same name, case-insensitively, or into the
+// field whose db tag is the column name. The fields of the embedded structs
+// are used too, and the fields tagged db:"-" ignored. A column without a
+// field is an error.
+//
+// Otherwise the query must return a single column, scanned into T.
+//
+//	type user struct {
+//		ID    int64
+//		Email string
+//		Name  gorqlite.Null[string] `db:"full_name"`
+//	}
+//	users, err := gorqlite.QueryAs[user](ctx, conn, gorqlite.Statement{
+//		Query:     "SELECT id, email, full_name FROM users WHERE status = ?",
+//		Arguments: []interface{}{"active"},
+//	})
+func QueryAs[T any](ctx context.Context, conn *Connection, stmt Statement, opts ...CallOption) ([]T, error) {
+	qr, err := conn.queryOne(ctx, stmt, opts)
+	if err != nil {
+		return nil, err
+	}
+	return scanAll[T](&qr)
+}
+
+// QueryOneAs runs the query and scans its first row into a T, as QueryAs
+// does. It fails with ErrNoRow if the query returned no row.
+func QueryOneAs[T any](ctx context.Context, conn *Connection, stmt Statement, opts ...CallOption) (T, error) {
+	var zero T
+	qr, err := conn.queryOne(ctx, stmt, opts)
+	if err != nil {
+		return zero, err
+	}
+	if !qr.Next() {
+		return zero, fmt.Errorf("%w: %s", ErrNoRow, stmt.Query)
+	}
+	return scanRow[T](&qr)
+}
+
+// QueryScalar runs a query returning a single value, e.g. a COUNT(*), and
+// scans it into a T. It fails with ErrNoRow if the query returned no row.
+//
+//	n, err := gorqlite.QueryScalar[int64](ctx, conn, gorqlite.Statement{Query: "SELECT COUNT(*) FROM users"})
+func QueryScalar[T any](ctx context.Context, conn *Connection, stmt Statement, opts ...CallOption) (T, error) {
+	var v T
+	qr, err := conn.queryOne(ctx, stmt, opts)
+	if err != nil {
+		return v, err
+	}
+	if !qr.Next() {
+		return v, fmt.Errorf("%w: %s", ErrNoRow, stmt.Query)
+	}
+	err = qr.Scan(&v)
+	return v, err
+}
+
+// scanAll scans the remaining rows of the result.
+func scanAll[T any](qr *QueryResult) ([]T, error) {
+	values := make([]T, 0, qr.NumRows())
+	for qr.Next() {
+		v, err := scanRow[T](qr)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// scanRow scans the current row into a T.
+func scanRow[T any](qr *QueryResult) (T, error) {
+	var v T
+	rv := reflect.ValueOf(&v).Elem()
+	if !isRowStruct(rv) {
+		err := qr.Scan(&v)
+		return v, err
+	}
+
+	fields := structFields(rv.Type())
+	dest := make([]interface{}, len(qr.columns))
+	for i, col := range qr.columns {
+		index, ok := fields[strings.ToLower(col)]
+		if !ok {
+			return v, fmt.Errorf("column %q has no field in %s", col, rv.Type())
+		}
+		dest[i] = rv.FieldByIndex(index).Addr().Interface()
+	}
+	err := qr.Scan(dest...)
+	return v, err
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// isRowStruct tells whether the value is a struct whose fields receive the
+// columns, rather than a value Scan supports.
+func isRowStruct(rv reflect.Value) bool {
+	if rv.Kind() != reflect.Struct || rv.Type() == timeType {
+		return false
+	}
+	switch rv.Addr().Interface().(type) {
+	case nullable, sql.Scanner, *NullString, *NullInt64, *NullInt32, *NullInt16, *NullFloat64, *NullBool, *NullTime:
+		return false
+	}
+	return true
+}
+
+// structFields returns the index of the fields of the struct type receiving
+// the columns, by lowercase column name.
+func structFields(t reflect.Type) map[string][]int {
+	fields := make(map[string][]int)
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous && f.Type.Kind() == reflect.Struct {
+			continue
+		}
+		if promotedThroughPointer(t, f.Index) {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("db"); ok {
+			if tag == "-" {
+				continue
+			}
+			name = tag
+		}
+		fields[strings.ToLower(name)] = f.Index
+	}
+	return fields
+}
+
+// promotedThroughPointer tells whether the field is promoted from an
+// embedded pointer, which may be nil.
+func promotedThroughPointer(t reflect.Type, index []int) bool {
+	for _, i := range index[:len(index)-1] {
+		f := t.Field(i)
+		if f.Type.Kind() == reflect.Ptr {
+			return true
+		}
+		t = f.Type
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package gorqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func testQueryResult(columns []string, rows ...[]interface{}) *QueryResult {
+	values := make([]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = row
+	}
+	return &QueryResult{
+		columns:   columns,
+		types:     make([]string, len(columns)),
+		values:    values,
+		rowNumber: -1,
+	}
+}
+
+type testAudit struct {
+	Created time.Time
+}
+
+type testUser struct {
+	testAudit
+	ID      int64
+	Email   string
+	Name    Null[string] `db:"full_name"`
+	Age     Null[int32]
+	Ignored string `db:"-"`
+	secret  string
+}
+
+func TestScanAllStructs(t *testing.T) {
+	qr := testQueryResult([]string{"id", "EMAIL", "full_name", "age", "created"},
+		[]interface{}{int64(1), "a@example.com", "Ann", int64(42), "2024-01-02 03:04:05"},
+		[]interface{}{int64(2), "b@example.com", nil, nil, nil})
+	users, err := scanAll[testUser](qr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requireInt(t, 2, len(users))
+	requireInt(t, 1, int(users[0].ID))
+	requireString(t, "a@example.com", users[0].Email)
+	requireBool(t, true, users[0].Name.Valid)
+	requireString(t, "Ann", users[0].Name.V)
+	requireInt(t, 42, int(users[0].Age.V))
+	requireInt(t, 2024, users[0].Created.Year())
+	requireBool(t, false, users[1].Name.Valid)
+	requireBool(t, false, users[1].Age.Valid)
+
+	qr = testQueryResult([]string{"id", "unknown"}, []interface{}{int64(1), "x"})
+	if _, err := scanAll[testUser](qr); err == nil {
+		t.Error("expected an error for a column without field")
+	}
+}
+
+func TestScanAllScalars(t *testing.T) {
+	qr := testQueryResult([]string{"name"}, []interface{}{"a"}, []interface{}{nil})
+	names, err := scanAll[Null[string]](qr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requireInt(t, 2, len(names))
+	requireBool(t, true, names[0].Valid)
+	requireString(t, "a", names[0].V)
+	requireBool(t, false, names[1].Valid)
+
+	qr = testQueryResult([]string{"name"}, []interface{}{"b"}, []interface{}{nil})
+	nullStrings, err := scanAll[sql.NullString](qr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requireInt(t, 2, len(nullStrings))
+	requireString(t, "b", nullStrings[0].String)
+	requireBool(t, false, nullStrings[1].Valid)
+
+	qr = testQueryResult([]string{"created"}, []interface{}{"2024-01-02 03:04:05"})
+	qr.types[0] = "datetime"
+	times, err := scanAll[sql.NullTime](qr)
+	if err != nil || len(times) != 1 || times[0].Time.Year() != 2024 {
+		t.Errorf("expected a time in 2024, got %v, %v", times, err)
+	}
+
+	qr = testQueryResult([]string{"n"}, []interface{}{int64(7)})
+	counts, err := scanAll[int64](qr)
+	if err != nil || len(counts) != 1 || counts[0] != 7 {
+		t.Errorf("expected [7], got %v, %v", counts, err)
+	}
+
+	qr = testQueryResult([]string{"a", "b"}, []interface{}{int64(1), int64(2)})
+	if _, err := scanAll[int64](qr); err == nil {
+		t.Error("expected an error when scanning 2 columns into a scalar")
+	}
+}
+
+func TestScanNull(t *testing.T) {
+	qr := testQueryResult([]string{"s", "legacy"}, []interface{}{"x", "y"}, []interface{}{nil, nil}, []interface{}{"z", true})
+	var s Null[string]
+	var legacy NullString
+	qr.Next()
+	if err := qr.Scan(&s, &legacy); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requireBool(t, true, s.Valid && legacy.Valid)
+
+	// a null resets Null[T], but leaves the deprecated types untouched
+	qr.Next()
+	if err := qr.Scan(&s, &legacy); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requireBool(t, false, s.Valid)
+	requireString(t, "", s.V)
+	requireBool(t, true, legacy.Valid)
+	requireString(t, "y", legacy.String)
+
+	// and so does an error
+	qr.Next()
+	if err := qr.Scan(&s, &legacy); err == nil {
+		t.Fatal("expected an error scanning a bool into a NullString")
+	}
+	requireBool(t, true, legacy.Valid)
+	requireString(t, "y", legacy.String)
+}
+
+type testID [2]byte
+
+func (id *testID) Scan(src interface{}) error {
+	s, ok := src.(string)
+	if !ok || len(s) != 2 {
+		return fmt.Errorf("invalid id %v", src)
+	}
+	copy(id[:], s)
+	return nil
+}
+
+func TestScanScanners(t *testing.T) {
+	qr := testQueryResult([]string{"s", "n", "created", "at", "id"},
+		[]interface{}{"x", int64(3), "2024-01-02 03:04:05", "2024-01-02T03:04:05Z", "ab"},
+		[]interface{}{nil, nil, nil, nil, "cd"})
+	qr.types[2] = "datetime"
+	var s sql.NullString
+	var n sql.NullInt64
+	var created, at sql.NullTime
+	var id testID
+	qr.Next()
+	if err := qr.Scan(&s, &n, &created, &at, &id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requireString(t, "x", s.String)
+	requireInt(t, 3, int(n.Int64))
+	requireBool(t, true, created.Valid && at.Valid)
+	requireInt(t, 2024, created.Time.Year())
+	requireInt(t, 3, at.Time.Hour())
+	requireString(t, "ab", string(id[:]))
+
+	qr.Next()
+	if err := qr.Scan(&s, &n, &created, &at, &id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requireBool(t, false, s.Valid || n.Valid || created.Valid || at.Valid)
+	requireString(t, "cd", string(id[:]))
+
+	// the scanners accepting the stored value get it as is
+	qr = testQueryResult([]string{"created", "stamp"}, []interface{}{"2024-01-02 03:04:05", float64(1704164645)})
+	qr.types[0], qr.types[1] = "datetime", "timestamp"
+	var raw sql.NullString
+	var stamp sql.NullTime
+	qr.Next()
+	if err := qr.Scan(&raw, &stamp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requireString(t, "2024-01-02 03:04:05", raw.String)
+	requireInt(t, 2024, stamp.Time.Year())
+
+	qr = testQueryResult([]string{"id"}, []interface{}{int64(1)})
+	qr.Next()
+	if err := qr.Scan(&id); err == nil {
+		t.Error("expected the error of the scanner")
+	}
+}
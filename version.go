@@ -0,0 +1,159 @@
+package gorqlite
+
+// this file contains the server version detection:
+//
+//   Version and parseVersion() for the build.version of /status
+//   the features gated by the server version
+//   Connection.ServerVersion() and Connection.checkFeature()
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedByServer is returned, wrapped, by the calls that need a newer
+// rqlite server than the one the connection talks to.
+var ErrUnsupportedByServer = errors.New("gorqlite: unsupported by the server")
+
+// Version is the version of a rqlite server. The zero Version means the
+// version is unknown.
+type Version struct {
+	Major, Minor, Patch int
+	Raw                 string // as reported by the server, e.g. v8.0.0
+}
+
+// parseVersion parses versions like v7.9.2, 5.12.1 or v8.0.0-rc1.
+func parseVersion(s string) (Version, error) {
+	v := Version{Raw: s}
+	core := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(core, "-+ "); i >= 0 {
+		core = core[:i]
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid server version: %q", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("invalid server version: %q", s)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// IsZero tells whether the version is unknown.
+func (v Version) IsZero() bool {
+	return v.Major == 0 && v.Minor == 0 && v.Patch == 0
+}
+
+// AtLeast tells whether the version is the given one or later.
+func (v Version) AtLeast(major, minor, patch int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+	return v.Patch >= patch
+}
+
+func (v Version) String() string {
+	if v.IsZero() {
+		return "unknown"
+	}
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// feature is a server feature that only some versions support.
+type feature struct {
+	name                string
+	major, minor, patch int // first version supporting the feature
+}
+
+// The first releases of the features, from the rqlite CHANGELOG.
+var (
+	featureNodesAPI  = feature{"the /nodes api", 6, 0, 0}       // 6.0.0 added /nodes
+	featureRequest   = feature{"the /db/request api", 7, 5, 0}  // 7.5.0 added the unified endpoint
+	featureReturning = feature{"the RETURNING clause", 8, 0, 0} // 8.0.0 added RETURNING
+)
+
+// supports tells whether the version supports the feature. An unknown
+// version, e.g. one the server didn't report or that couldn't be parsed, is
+// assumed to support everything: the server then rejects what it doesn't
+// support.
+func (v Version) supports(f feature) bool {
+	return v.IsZero() || v.AtLeast(f.major, f.minor, f.patch)
+}
+
+// ServerVersion returns the version of the rqlite server recorded on Open, or
+// the zero Version if it could not be determined, in which case no feature
+// is gated.
+//
+// The version comes from /status: Open calls it once, before discovering the
+// cluster, and reuses its answer for the servers without the /nodes api. With
+// cluster discovery disabled, Open doesn't call the server: the version is
+// then detected by the first call that depends on it.
+func (conn *Connection) ServerVersion() Version {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	return conn.serverVersion
+}
+
+// shouldDetectServerVersion tells whether the version detection didn't run
+// yet.
+func (conn *Connection) shouldDetectServerVersion() bool {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	return !conn.versionTried
+}
+
+// checkFeature fails with ErrUnsupportedByServer if the server is known not
+// to support the feature.
+func (conn *Connection) checkFeature(ctx context.Context, f feature) error {
+	if conn.shouldDetectServerVersion() {
+		conn.detectServerVersion(ctx)
+	}
+	v := conn.ServerVersion()
+	if v.supports(f) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s needs rqlite %d.%d.%d or later, the server is %s",
+		ErrUnsupportedByServer, f.name, f.major, f.minor, f.patch, v)
+}
+
+// detectServerVersion records the server version from /status. Failures are
+// only traced, since /status may not be available to the user: the version
+// then stays unknown and no feature is gated.
+func (conn *Connection) detectServerVersion(ctx context.Context) *Status {
+	conn.mu.Lock()
+	conn.versionTried = true
+	conn.mu.Unlock()
+
+	responseBody, err := conn.rqliteApiGet(ctx, api_STATUS)
+	if err != nil {
+		trace("%s: could not get the server version: %v", conn.ID, err)
+		return nil
+	}
+	status, err := parseStatus(responseBody)
+	if err != nil {
+		trace("%s: could not parse /status: %v", conn.ID, err)
+		return nil
+	}
+	v, err := parseVersion(status.Build.Version)
+	if err != nil {
+		trace("%s: %v", conn.ID, err)
+		return &status
+	}
+	trace("%s: server version is %s", conn.ID, v)
+
+	conn.mu.Lock()
+	conn.serverVersion = v
+	conn.mu.Unlock()
+	return &status
+}
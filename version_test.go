@@ -0,0 +1,65 @@
+package gorqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	for s, want := range map[string]Version{
+		"v7.9.2":      {Major: 7, Minor: 9, Patch: 2, Raw: "v7.9.2"},
+		"5.12.1":      {Major: 5, Minor: 12, Patch: 1, Raw: "5.12.1"},
+		"v8.0.0-rc1":  {Major: 8, Raw: "v8.0.0-rc1"},
+		"v8.16":       {Major: 8, Minor: 16, Raw: "v8.16"},
+		"v8.1.0+dirt": {Major: 8, Minor: 1, Raw: "v8.1.0+dirt"},
+	} {
+		got, err := parseVersion(s)
+		if err != nil {
+			t.Errorf("unexpected error parsing %s: %v", s, err)
+		}
+		if got != want {
+			t.Errorf("parsing %s: expected %+v, got %+v", s, want, got)
+		}
+	}
+
+	for _, s := range []string{"", "unknown", "v8", "v8.x.1", "1.2.3.4"} {
+		if _, err := parseVersion(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
+
+func TestCheckFeature(t *testing.T) {
+	conn := Connection{connShared: &connShared{versionTried: true}}
+
+	// unknown versions support everything
+	if err := conn.checkFeature(context.Background(), featureReturning); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	conn.serverVersion, _ = parseVersion("v7.9.2")
+	requireBool(t, true, conn.ServerVersion().AtLeast(7, 9, 2))
+	requireBool(t, false, conn.ServerVersion().AtLeast(7, 10, 0))
+	for _, f := range []feature{featureNodesAPI, featureRequest} {
+		if err := conn.checkFeature(context.Background(), f); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	err := conn.checkFeature(context.Background(), featureReturning)
+	if !errors.Is(err, ErrUnsupportedByServer) {
+		t.Errorf("expected ErrUnsupportedByServer, got %v", err)
+	}
+	requireString(t, "gorqlite: unsupported by the server: the RETURNING clause needs rqlite 8.0.0 or later, the server is 7.9.2", err.Error())
+
+	conn.serverVersion, _ = parseVersion("v7.4.1")
+	err = conn.checkFeature(context.Background(), featureRequest)
+	requireString(t, "gorqlite: unsupported by the server: the /db/request api needs rqlite 7.5.0 or later, the server is 7.4.1", fmt.Sprint(err))
+
+	// unparseable versions support everything too
+	conn.serverVersion, _ = parseVersion("v8.x.1")
+	if err := conn.checkFeature(context.Background(), featureRequest); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -58,8 +58,8 @@ func (conn *Connection) WriteOne(sqlStatement string) (wr WriteResult, err error
 }
 
 // WriteOneContext wraps WriteContext() into a single-statement
-func (conn *Connection) WriteOneContext(ctx context.Context, sqlStatement string) (wr WriteResult, err error) {
-	wra, err := conn.WriteContext(ctx, []string{sqlStatement})
+func (conn *Connection) WriteOneContext(ctx context.Context, sqlStatement string, opts ...CallOption) (wr WriteResult, err error) {
+	wra, err := conn.WriteContext(ctx, []string{sqlStatement}, opts...)
 	return wra[0], err
 }
 
@@ -73,13 +73,16 @@ func (conn *Connection) WriteOneParameterized(statement ParameterizedStatement)
 
 // WriteOneParameterizedContext wraps WriteParameterizedContext into
 // a single-statement method.
-func (conn *Connection) WriteOneParameterizedContext(ctx context.Context, statement ParameterizedStatement) (wr WriteResult, err error) {
-	wra, err := conn.WriteParameterizedContext(ctx, []ParameterizedStatement{statement})
+func (conn *Connection) WriteOneParameterizedContext(ctx context.Context, statement ParameterizedStatement, opts ...CallOption) (wr WriteResult, err error) {
+	wra, err := conn.WriteParameterizedContext(ctx, []ParameterizedStatement{statement}, opts...)
 	return wra[0], err
 }
 
-func (conn *Connection) WriteStmt(ctx context.Context, sqlStatements ...*Statement) (results []WriteResult, err error) {
-	return conn.WriteParameterizedContext(ctx, makeParameterizedStatements(sqlStatements))
+// WriteStmt is used to perform DDL/DML in the database with the given statements.
+//
+// The call options override the settings of the connection for this call only.
+func (conn *Connection) WriteStmt(ctx context.Context, sqlStatements []*Statement, opts ...CallOption) (results []WriteResult, err error) {
+	return conn.WriteParameterizedContext(ctx, makeParameterizedStatements(sqlStatements), opts...)
 }
 
 // Write is used to perform DDL/DML in the database synchronously without parameters.
@@ -100,7 +103,8 @@ func (conn *Connection) Write(sqlStatements []string) (results []WriteResult, er
 // WriteContext is used to perform DDL/DML in the database synchronously without parameters.
 //
 // To use WriteContext with parameterized queries, use WriteParameterizedContext.
-func (conn *Connection) WriteContext(ctx context.Context, sqlStatements []string) (results []WriteResult, err error) {
+// The call options override the settings of the connection for this call only.
+func (conn *Connection) WriteContext(ctx context.Context, sqlStatements []string, opts ...CallOption) (results []WriteResult, err error) {
 	parameterizedStatements := make([]ParameterizedStatement, 0, len(sqlStatements))
 	for _, sqlStatement := range sqlStatements {
 		parameterizedStatements = append(parameterizedStatements, ParameterizedStatement{
@@ -108,7 +112,7 @@ func (conn *Connection) WriteContext(ctx context.Context, sqlStatements []string
 		})
 	}
 
-	return conn.WriteParameterizedContext(ctx, parameterizedStatements)
+	return conn.WriteParameterizedContext(ctx, parameterizedStatements, opts...)
 }
 
 // WriteParameterized is used to perform DDL/DML in the database synchronously.
@@ -139,14 +143,45 @@ func (conn *Connection) WriteParameterized(sqlStatements []ParameterizedStatemen
 // If it's something like a call to the rqlite API, then it'll return that error.
 // If one statement out of several has an error, it will return a generic
 // "there were %d statement errors" and you'll have to look at the individual statement's Err for more info.
-func (conn *Connection) WriteParameterizedContext(ctx context.Context, sqlStatements []ParameterizedStatement) (results []WriteResult, err error) {
+//
+// The call options override the settings of the connection for this call only.
+// With CallQueueing(), the statements are queued and each result only carries
+// the sequence number of the queue.
+//
+// A write whose response was lost fails with ErrAmbiguousWrite rather than
+// being replayed on another peer, unless it has an idempotency key, see
+// CallIdempotencyKey.
+func (conn *Connection) WriteParameterizedContext(ctx context.Context, sqlStatements []ParameterizedStatement, opts ...CallOption) (results []WriteResult, err error) {
 	results = make([]WriteResult, 0)
 
-	if conn.hasBeenClosed {
+	if conn.isClosed() {
 		results = append(results, WriteResult{Err: ErrClosed})
 		return results, ErrClosed
 	}
 
+	conn, err = conn.withCallOptions(opts)
+	if err != nil {
+		results = append(results, WriteResult{Err: err})
+		return results, err
+	}
+	conn, sqlStatements, err = conn.withIdempotencyKey(sqlStatements)
+	if err != nil {
+		results = append(results, WriteResult{Err: err})
+		return results, err
+	}
+
+	if conn.wantsQueueing {
+		seq, err := conn.queue(ctx, sqlStatements)
+		if err != nil {
+			results = append(results, WriteResult{Err: err})
+			return results, err
+		}
+		for range sqlStatements {
+			results = append(results, WriteResult{ID: conn.ID, SequenceNumber: seq})
+		}
+		return results, nil
+	}
+
 	trace("%s: Write() for %d statements", conn.ID, len(sqlStatements))
 
 	response, err := conn.rqliteApiPost(ctx, api_WRITE, sqlStatements)
@@ -183,6 +218,11 @@ func (conn *Connection) WriteParameterizedContext(ctx context.Context, sqlStatem
 		results = append(results, WriteResult{Err: err})
 		return results, err
 	}
+	resultsArray, err = conn.checkIdempotencyResults(resultsArray)
+	if err != nil {
+		results = append(results, WriteResult{Err: err})
+		return results, err
+	}
 
 	trace("%s: I have %d result(s) to parse", conn.ID, len(resultsArray))
 	numStatementErrors := 0
@@ -244,8 +284,8 @@ func (conn *Connection) QueueOne(sqlStatement string) (seq int64, err error) {
 }
 
 // QueueOneContext is a convenience method that wraps QueueContext into a single-statement
-func (conn *Connection) QueueOneContext(ctx context.Context, sqlStatement string) (seq int64, err error) {
-	return conn.QueueContext(ctx, []string{sqlStatement})
+func (conn *Connection) QueueOneContext(ctx context.Context, sqlStatement string, opts ...CallOption) (seq int64, err error) {
+	return conn.QueueContext(ctx, []string{sqlStatement}, opts...)
 }
 
 // QueueOneParameterized is a convenience method that wraps QueueParameterized into a single-statement method.
@@ -256,8 +296,8 @@ func (conn *Connection) QueueOneParameterized(statement ParameterizedStatement)
 }
 
 // QueueOneParameterizedContext is a convenience method that wraps QueueParameterizedContext() into a single-statement method.
-func (conn *Connection) QueueOneParameterizedContext(ctx context.Context, statement ParameterizedStatement) (seq int64, err error) {
-	return conn.QueueParameterizedContext(ctx, []ParameterizedStatement{statement})
+func (conn *Connection) QueueOneParameterizedContext(ctx context.Context, statement ParameterizedStatement, opts ...CallOption) (seq int64, err error) {
+	return conn.QueueParameterizedContext(ctx, []ParameterizedStatement{statement}, opts...)
 }
 
 // Queue is used to perform asynchronous writes to the rqlite database as defined in the documentation:
@@ -273,13 +313,13 @@ func (conn *Connection) Queue(sqlStatements []string) (seq int64, err error) {
 // https://github.com/rqlite/rqlite/blob/master/DOC/QUEUED_WRITES.md
 //
 // To use QueueContext with parameterized queries, use QueueParameterizedContext.
-func (conn *Connection) QueueContext(ctx context.Context, sqlStatements []string) (seq int64, err error) {
+func (conn *Connection) QueueContext(ctx context.Context, sqlStatements []string, opts ...CallOption) (seq int64, err error) {
 	parameterizedStatements := make([]ParameterizedStatement, 0)
 	for _, sqlStatement := range sqlStatements {
 		parameterizedStatements = append(parameterizedStatements, ParameterizedStatement{Query: sqlStatement})
 	}
 
-	return conn.QueueParameterizedContext(ctx, parameterizedStatements)
+	return conn.QueueParameterizedContext(ctx, parameterizedStatements, opts...)
 }
 
 // QueueParameterized is used to perform asynchronous writes with parameterized queries
@@ -294,18 +334,25 @@ func (conn *Connection) QueueParameterized(sqlStatements []ParameterizedStatemen
 // QueueParameterizedContext is used to perform asynchronous writes with parameterized queries
 // to the rqlite database as defined in the documentation:
 // https://github.com/rqlite/rqlite/blob/master/DOC/QUEUED_WRITES.md
-func (conn *Connection) QueueParameterizedContext(ctx context.Context, sqlStatements []ParameterizedStatement) (seq int64, err error) {
-	if conn.hasBeenClosed {
+//
+// The call options override the settings of the connection for this call only.
+func (conn *Connection) QueueParameterizedContext(ctx context.Context, sqlStatements []ParameterizedStatement, opts ...CallOption) (seq int64, err error) {
+	if conn.isClosed() {
 		return 0, ErrClosed
 	}
 
-	trace("%s: Write() for %d statements", conn.ID, len(sqlStatements))
+	opts = append(opts[:len(opts):len(opts)], CallQueueing())
+	conn, err = conn.withCallOptions(opts)
+	if err != nil {
+		return 0, err
+	}
+	return conn.queue(ctx, sqlStatements)
+}
 
-	// Set queuing mode just for this call.
-	conn.wantsQueueing = true
-	defer func() {
-		conn.wantsQueueing = false
-	}()
+// queue performs the queued write of the statements; the connection must be
+// a view with queueing set.
+func (conn *Connection) queue(ctx context.Context, sqlStatements []ParameterizedStatement) (seq int64, err error) {
+	trace("%s: Queue() for %d statements", conn.ID, len(sqlStatements))
 
 	response, err := conn.rqliteApiPost(ctx, api_WRITE, sqlStatements)
 	if err != nil {
@@ -333,6 +380,8 @@ type WriteResult struct {
 	Timing       float64 // timing
 	RowsAffected int64   // affected by the change
 	LastInsertID int64   // if relevant, otherwise zero value
+
+	SequenceNumber int64 // sequence number of the queue, for queued writes only
 }
 
 func (w *WriteResult) IsZero() bool {
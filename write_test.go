@@ -378,7 +378,7 @@ func TestWrites(t *testing.T) {
 	s = append(s, NewStatement(insert, 2, "ddd eee fff"))
 	s = append(s, NewStatement(insert, 3, "ggg hhh iii"))
 	s = append(s, NewStatement(insert, 4, "jjj kkk lll"))
-	results, err = conn.WriteStmt(context.Background(), s...)
+	results, err = conn.WriteStmt(context.Background(), s)
 	if err != nil {
 		t.Logf("--> FAILED")
 		t.Fail()
@@ -433,7 +433,7 @@ func TestRequests(t *testing.T) {
 	get := "SELECT id FROM " + testTableName() + " WHERE id=? "
 	s = append(s, NewStatement(get, 1))
 
-	reqResults, err := conn.RequestStmt(context.Background(), s...)
+	reqResults, err := conn.RequestStmt(context.Background(), s)
 	if err != nil {
 		t.Logf("--> FAILED")
 		t.Fail()
@@ -527,7 +527,7 @@ func TestReadWriteLargeNumbers(t *testing.T) {
 	for _, ti := range toInsert {
 		s = append(s, NewStatement(insert, ti.id, ti.nanos, ti.length, ti.name))
 	}
-	_, err = conn.WriteStmt(context.Background(), s...)
+	_, err = conn.WriteStmt(context.Background(), s)
 	if err != nil {
 		t.Logf("--> INSERT FAILED %v", err)
 		t.Fatal(err)
@@ -535,7 +535,7 @@ func TestReadWriteLargeNumbers(t *testing.T) {
 
 	qrs, err := conn.QueryStmt(
 		context.Background(),
-		NewStatement("SELECT id, nanos, length, name FROM "+testTableName()))
+		[]*Statement{NewStatement("SELECT id, nanos, length, name FROM " + testTableName())})
 	if err != nil {
 		t.Logf("--> QUERY FAILED %v", err)
 		t.Fatal(err)